@@ -0,0 +1,83 @@
+package dbgo
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/ext"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/tracer"
+	"gorm.io/gorm"
+)
+
+type acquireProbeKey struct{}
+
+// acquireProbe records when a statement started waiting for a pool connection. The connector calls
+// markAcquired with the statement context once database/sql hands it a connection.
+type acquireProbe struct {
+	start   time.Time
+	service string
+	done    atomic.Bool
+}
+
+// withAcquireProbe returns ctx carrying a probe that starts now.
+func withAcquireProbe(ctx context.Context, service string) context.Context {
+	return context.WithValue(ctx, acquireProbeKey{}, &acquireProbe{start: time.Now(), service: service})
+}
+
+// markAcquired emits the connection acquisition span for the probe in ctx, if any. Only the first
+// acquisition per probe is recorded (e.g. a retry after driver.ErrBadConn is not reported twice).
+func markAcquired(ctx context.Context) {
+	if ctx == nil {
+		return
+	}
+	probe, ok := ctx.Value(acquireProbeKey{}).(*acquireProbe)
+	if !ok || !probe.done.CompareAndSwap(false, true) {
+		return
+	}
+	span, _ := tracer.StartSpanFromContext(ctx, SpanNameConnectionAcquire,
+		tracer.ServiceName(probe.service),
+		tracer.SpanType(ext.SpanTypeSQL),
+		tracer.StartTime(probe.start),
+	)
+	span.Finish()
+}
+
+// acquirePlugin starts an acquisition probe before each statement. Its callbacks run after the tracing
+// plugin's before callbacks, so the acquisition span is a child of the statement span.
+type acquirePlugin struct {
+	service string
+	tracing *liveTracing
+}
+
+func (acquirePlugin) Name() string {
+	return "dbgo:acquire_trace"
+}
+
+func (p acquirePlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").After("dd-trace-go:before_create").Register("dbgo:acquire_trace", p.probe); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").After("dd-trace-go:before_query").Register("dbgo:acquire_trace", p.probe); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").After("dd-trace-go:before_update").Register("dbgo:acquire_trace", p.probe); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").After("dd-trace-go:before_delete").Register("dbgo:acquire_trace", p.probe); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").After("dd-trace-go:before_row_query").Register("dbgo:acquire_trace", p.probe); err != nil {
+		return err
+	}
+	return cb.Raw().Before("gorm:raw").After("dd-trace-go:before_raw_query").Register("dbgo:acquire_trace", p.probe)
+}
+
+// probe skips statements that already hold a connection (transactions), dry runs and untraced statements.
+func (p acquirePlugin) probe(db *gorm.DB) {
+	if db.Error != nil || db.DryRun || db.Statement.Context == nil || isTransaction(db) || !p.tracing.active(db) {
+		return
+	}
+	db.Statement.Context = withAcquireProbe(db.Statement.Context, p.service)
+}
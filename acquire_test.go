@@ -0,0 +1,86 @@
+package dbgo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/mocktracer"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/tracer"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// dsnConnector adapts a driver without DriverContext (such as sqlmock's) to driver.Connector.
+type dsnConnector struct {
+	drv driver.Driver
+	dsn string
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.drv.Open(c.dsn) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.drv }
+
+func TestMarkAcquired_EmitsOnceUnderParent(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	parent, ctx := tracer.StartSpanFromContext(context.Background(), "gorm.query")
+	ctx = withAcquireProbe(ctx, "svc")
+	start := ctx.Value(acquireProbeKey{}).(*acquireProbe).start
+
+	markAcquired(ctx)
+	markAcquired(ctx)
+	markAcquired(context.Background()) // no probe: no span
+
+	spans := mt.FinishedSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, SpanNameConnectionAcquire, spans[0].OperationName())
+		assert.Equal(t, parent.Context().SpanID(), spans[0].ParentID())
+		assert.True(t, start.Equal(spans[0].StartTime()), "span starts when the acquire began")
+		assert.Equal(t, "svc", spans[0].Tag("service.name"))
+	}
+}
+
+func TestTraceConnectionAcquire_SpanUnderStatement(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mockDB, mock, err := sqlmock.NewWithDSN("dbgo_acquire_test")
+	assert.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	sqlDB := sql.OpenDB(&connector{
+		Connector:    dsnConnector{drv: mockDB.Driver(), dsn: "dbgo_acquire_test"},
+		traceAcquire: true,
+	})
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	cfg := Config{EnableTracing: true, TraceConnectionAcquire: true}
+	db, err = EnableTracing(db, cfg)
+	assert.NoError(t, err)
+
+	mock.ExpectExec(`UPDATE users`).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, db.WithContext(context.Background()).Exec("UPDATE users SET active = true").Error)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var statement, acquire *mocktracer.Span
+	for _, s := range mt.FinishedSpans() {
+		switch s.OperationName() {
+		case "gorm.raw_query":
+			statement = s
+		case SpanNameConnectionAcquire:
+			acquire = s
+		}
+	}
+	if assert.NotNil(t, statement) && assert.NotNil(t, acquire) {
+		assert.Equal(t, statement.SpanID(), acquire.ParentID())
+		assert.False(t, acquire.FinishTime().Before(acquire.StartTime()))
+		assert.WithinDuration(t, time.Now(), acquire.StartTime(), time.Minute)
+	}
+}
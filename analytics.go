@@ -0,0 +1,81 @@
+package dbgo
+
+import (
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/ext"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/tracer"
+	"gorm.io/gorm"
+)
+
+// analyticsPlugin sets the analytics rate of statement spans: Config.TracingReadAnalyticsRate or
+// Config.TracingWriteAnalyticsRate, falling back to Config.TracingAnalyticsRate. Its callbacks run right after
+// the tracing plugin's before callbacks, once the statement span is in the statement context.
+type analyticsPlugin struct {
+	tracing *liveTracing
+}
+
+func (analyticsPlugin) Name() string {
+	return "dbgo:analytics_rate"
+}
+
+func (p analyticsPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").After("dd-trace-go:before_create").Register("dbgo:analytics_rate", p.tagWrite); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").After("dd-trace-go:before_query").Register("dbgo:analytics_rate", p.tagRead); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").After("dd-trace-go:before_update").Register("dbgo:analytics_rate", p.tagWrite); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").After("dd-trace-go:before_delete").Register("dbgo:analytics_rate", p.tagWrite); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").After("dd-trace-go:before_row_query").Register("dbgo:analytics_rate", p.tagRow); err != nil {
+		return err
+	}
+	return cb.Raw().Before("gorm:raw").After("dd-trace-go:before_raw_query").Register("dbgo:analytics_rate", p.tagRaw)
+}
+
+func (p analyticsPlugin) tagRead(db *gorm.DB)  { p.tag(db, p.tracing.load().read) }
+func (p analyticsPlugin) tagWrite(db *gorm.DB) { p.tag(db, p.tracing.load().write) }
+
+// tag sets rate, or the general rate when nil, on the span of a traced statement. Untraced statements have no
+// span of their own, so they are skipped.
+func (p analyticsPlugin) tag(db *gorm.DB, rate *float64) {
+	if rate == nil {
+		rate = p.tracing.load().rate
+	}
+	if p.tracing.active(db) {
+		setAnalyticsRate(db, rate)
+	}
+}
+
+// tagRow classifies Row/Rows by their SQL when it is already built (db.Raw(...).Row()); otherwise the
+// statement is a query built from the model and counts as a read.
+func (p analyticsPlugin) tagRow(db *gorm.DB) {
+	if sql := db.Statement.SQL.String(); sql != "" && !isReadOnlySQL(sql) {
+		p.tagWrite(db)
+		return
+	}
+	p.tagRead(db)
+}
+
+// tagRaw classifies raw statements (db.Exec) like SkipEmptyCommit does.
+func (p analyticsPlugin) tagRaw(db *gorm.DB) {
+	if isReadOnlySQL(db.Statement.SQL.String()) {
+		p.tagRead(db)
+		return
+	}
+	p.tagWrite(db)
+}
+
+// setAnalyticsRate sets rate on the span in the statement context; a nil rate keeps the tracer's default.
+func setAnalyticsRate(db *gorm.DB, rate *float64) {
+	if rate == nil || db.Statement.Context == nil {
+		return
+	}
+	if span, ok := tracer.SpanFromContext(db.Statement.Context); ok {
+		span.SetTag(ext.EventSampleRate, *rate)
+	}
+}
@@ -0,0 +1,62 @@
+package dbgo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/ext"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/mocktracer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracingOperationAnalyticsRates(t *testing.T) {
+	saveAndRestoreConn(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	db, mock := newMockDB(t)
+	cfg := *WithTracingOperationAnalyticsRates(0.1, 1.0, 0.5)(WithTracingAnalyticsRate(0.3)(&Config{EnableTracing: true}))
+	db, err := EnableTracing(db, cfg)
+	assert.NoError(t, err)
+
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = cfg
+	connMu.Unlock()
+
+	mock.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	var count int64
+	assert.NoError(t, Raw(context.Background(), &count, "SELECT count(*) FROM users"))
+	assert.NoError(t, WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := Exec(ctx, "UPDATE users SET active = true")
+		return err
+	}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	rates := map[string]interface{}{}
+	for _, s := range mt.FinishedSpans() {
+		name := s.OperationName()
+		if name != SpanNameTransaction {
+			name = s.Tag(ext.ResourceName).(string)
+		}
+		rates[name] = s.Tag(ext.EventSampleRate)
+	}
+	assert.Equal(t, 0.1, rates["SELECT count(*) FROM users"])
+	assert.Equal(t, 1.0, rates["UPDATE users SET active = true"])
+	assert.Equal(t, 0.5, rates[SpanNameTransaction])
+}
+
+func TestValidate_AnalyticsRates(t *testing.T) {
+	valid := Config{PrimaryDSN: "postgres://localhost/db"}
+	assert.NoError(t, (*WithTracingOperationAnalyticsRates(0, 1, 0.5)(&valid)).Validate())
+
+	rate := 1.5
+	err := Config{PrimaryDSN: "postgres://localhost/db", TracingWriteAnalyticsRate: &rate}.Validate()
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "TracingWriteAnalyticsRate")
+}
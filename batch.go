@@ -0,0 +1,50 @@
+package dbgo
+
+import (
+	"context"
+
+	logger "github.com/adnvilla/logger-go"
+)
+
+// batchSavepoint is the savepoint ProcessBatch wraps each item in. Each savepoint is released (after being rolled
+// back, for failed items) before the next one is created, so nested ProcessBatch calls can reuse the name.
+const batchSavepoint = "dbgo_batch_item"
+
+// ProcessBatch calls fn for each item in a single transaction (see WithTransaction), wrapping each call in a
+// savepoint: when fn fails, only that item's statements are rolled back, the error is logged and processing
+// continues with the next item. The transaction then commits the items that succeeded, and the failed ones are
+// returned in order. err is set, and the whole transaction rolled back, when ctx is cancelled, a savepoint
+// statement fails or the commit fails; failed is nil in that case.
+// Example:
+//
+//	failed, err := dbgo.ProcessBatch(ctx, rows, func(ctx context.Context, row Row) error {
+//	    return dbgo.GetFromContext(ctx).Create(&row).Error
+//	})
+func ProcessBatch[T any](ctx context.Context, items []T, fn func(ctx context.Context, item T) error) (failed []T, err error) {
+	err = WithTransaction(ctx, func(ctx context.Context) error {
+		db := GetFromContext(ctx)
+		for i, item := range items {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := db.Exec("SAVEPOINT " + batchSavepoint).Error; err != nil {
+				return err
+			}
+			if itemErr := fn(ctx, item); itemErr != nil {
+				logger.Warn(ctx, "dbgo: ProcessBatch item %d failed (rolled back to its savepoint): %v", i, itemErr)
+				if err := db.Exec("ROLLBACK TO SAVEPOINT " + batchSavepoint).Error; err != nil {
+					return err
+				}
+				failed = append(failed, item)
+			}
+			if err := db.Exec("RELEASE SAVEPOINT " + batchSavepoint).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return failed, nil
+}
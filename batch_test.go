@@ -0,0 +1,63 @@
+package dbgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessBatch(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	for _, id := range []int{1, 2, 3} {
+		mock.ExpectExec(`SAVEPOINT dbgo_batch_item`).WillReturnResult(sqlmock.NewResult(0, 0))
+		if id == 2 {
+			mock.ExpectExec(`INSERT INTO rows`).WithArgs(id).WillReturnError(errors.New("duplicate key"))
+			mock.ExpectExec(`ROLLBACK TO SAVEPOINT dbgo_batch_item`).WillReturnResult(sqlmock.NewResult(0, 0))
+		} else {
+			mock.ExpectExec(`INSERT INTO rows`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectExec(`RELEASE SAVEPOINT dbgo_batch_item`).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectCommit()
+
+	failed, err := ProcessBatch(context.Background(), []int{1, 2, 3}, func(ctx context.Context, id int) error {
+		return GetFromContext(ctx).Exec("INSERT INTO rows (id) VALUES (?)", id).Error
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2}, failed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProcessBatch_SavepointErrorRollsBack(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SAVEPOINT dbgo_batch_item`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO rows`).WillReturnError(errors.New("duplicate key"))
+	mock.ExpectExec(`ROLLBACK TO SAVEPOINT dbgo_batch_item`).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	failed, err := ProcessBatch(context.Background(), []int{1, 2}, func(ctx context.Context, id int) error {
+		return GetFromContext(ctx).Exec("INSERT INTO rows (id) VALUES (?)", id).Error
+	})
+
+	assert.ErrorContains(t, err, "connection reset")
+	assert.Nil(t, failed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
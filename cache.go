@@ -0,0 +1,148 @@
+package dbgo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+)
+
+// Cache is the backend used by the query result cache (see Config.QueryCache and WithCache).
+// Implementations must be safe for concurrent use. Values are opaque JSON-encoded query results.
+type Cache interface {
+	// Get returns the value stored under key and whether it was found (and not expired).
+	Get(ctx context.Context, key string) ([]byte, bool)
+	// Set stores value under key for the given ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+}
+
+type cacheContextKey struct{}
+
+type cacheSettings struct {
+	key string
+	ttl time.Duration
+}
+
+// cachedResult is the payload stored in the Cache for a single query.
+type cachedResult struct {
+	RowsAffected int64           `json:"rows_affected"`
+	Dest         json.RawMessage `json:"dest"`
+}
+
+// WithCache returns a context whose SELECT queries are served from Config.QueryCache under key.
+// It applies to statements executed through the query callback (Find, First, Take, Raw(...).Find, ...);
+// each statement is stored under its own entry, derived from key, its SQL, its bound values and the
+// destination type, so different queries on the same context never share a result. Preload sub-queries
+// are not cached.
+// On a miss the query runs against the database and its result is stored for ttl; on a hit the
+// database is not queried at all. Results are encoded with encoding/json, so only exported fields
+// survive the round trip. Queries inside a transaction always bypass the cache.
+// Example:
+//
+//	ctx = dbgo.WithCache(ctx, "dashboard:totals", time.Minute)
+//	err := dbgo.GetFromContext(ctx).Raw(expensiveAggregation).Find(&totals).Error
+func WithCache(ctx context.Context, key string, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, cacheContextKey{}, cacheSettings{key: key, ttl: ttl})
+}
+
+func cacheSettingsFrom(ctx context.Context) (cacheSettings, bool) {
+	if ctx == nil {
+		return cacheSettings{}, false
+	}
+	s, ok := ctx.Value(cacheContextKey{}).(cacheSettings)
+	return s, ok && s.key != ""
+}
+
+// cachePlugin wraps the "gorm:query" callback with a read-through cache.
+type cachePlugin struct {
+	backend Cache
+}
+
+func (cachePlugin) Name() string {
+	return "dbgo:cache"
+}
+
+func (p cachePlugin) Initialize(db *gorm.DB) error {
+	query := db.Callback().Query()
+	next := query.Get("gorm:query")
+	if err := query.Replace("gorm:query", func(db *gorm.DB) {
+		p.query(db, next)
+	}); err != nil {
+		return err
+	}
+	preload := query.Get("gorm:preload")
+	return query.Replace("gorm:preload", func(db *gorm.DB) {
+		withoutCache(db, preload)
+	})
+}
+
+// withoutCache runs next (the preload callback) with the cache disabled, so the sub-queries it executes
+// through the statement context are not served from, or stored under, the parent's WithCache key.
+func withoutCache(db *gorm.DB, next func(*gorm.DB)) {
+	ctx := db.Statement.Context
+	if _, ok := cacheSettingsFrom(ctx); !ok {
+		next(db)
+		return
+	}
+	db.Statement.Context = context.WithValue(ctx, cacheContextKey{}, cacheSettings{})
+	defer func() { db.Statement.Context = ctx }()
+	next(db)
+}
+
+// cacheKey returns the backend key of the statement: the WithCache key followed by a hash of the SQL, the
+// bound values and the destination type.
+func cacheKey(db *gorm.DB, key string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%T", db.Statement.SQL.String(), db.Statement.Dest)
+	for _, v := range db.Statement.Vars {
+		fmt.Fprintf(h, "\x00%T:%v", v, v)
+	}
+	return key + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+func (p cachePlugin) query(db *gorm.DB, next func(*gorm.DB)) {
+	settings, ok := cacheSettingsFrom(db.Statement.Context)
+	if !ok || db.Error != nil || db.DryRun || isTransaction(db) {
+		next(db)
+		return
+	}
+
+	// Build the SQL as gorm:query would (it is then reused as is) so that it is part of the key.
+	callbacks.BuildQuerySQL(db)
+	if db.Error != nil {
+		return
+	}
+	ctx := db.Statement.Context
+	key := cacheKey(db, settings.key)
+	if data, found := p.backend.Get(ctx, key); found {
+		var cached cachedResult
+		if err := json.Unmarshal(data, &cached); err == nil {
+			if err := json.Unmarshal(cached.Dest, db.Statement.Dest); err == nil {
+				db.RowsAffected = cached.RowsAffected
+				if db.RowsAffected == 0 && db.Statement.RaiseErrorOnNotFound {
+					db.AddError(gorm.ErrRecordNotFound)
+				}
+				return
+			}
+		}
+	}
+
+	next(db)
+	if db.Error != nil {
+		return
+	}
+	dest, err := json.Marshal(db.Statement.Dest)
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(cachedResult{RowsAffected: db.RowsAffected, Dest: dest})
+	if err != nil {
+		return
+	}
+	p.backend.Set(ctx, key, data, settings.ttl)
+}
@@ -0,0 +1,161 @@
+package dbgo
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type memCache struct {
+	mu    sync.Mutex
+	items map[string][]byte
+	ttls  map[string]time.Duration
+}
+
+func newMemCache() *memCache {
+	return &memCache{items: map[string][]byte{}, ttls: map[string]time.Duration{}}
+}
+
+func (c *memCache) Get(_ context.Context, key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.items[key]
+	return v, ok
+}
+
+func (c *memCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = value
+	c.ttls[key] = ttl
+}
+
+type cachedUser struct {
+	ID   uint
+	Name string
+}
+
+func TestCachePlugin_HitSkipsDatabase(t *testing.T) {
+	db, mock := newMockDB(t)
+	backend := newMemCache()
+	assert.NoError(t, db.Use(cachePlugin{backend: backend}))
+
+	mock.ExpectQuery(`SELECT \* FROM "cached_users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "ana").AddRow(2, "bob"))
+
+	ctx := WithCache(context.Background(), "users:all", time.Minute)
+
+	var first []cachedUser
+	assert.NoError(t, db.WithContext(ctx).Find(&first).Error)
+
+	var second []cachedUser
+	result := db.WithContext(ctx).Find(&second)
+	assert.NoError(t, result.Error)
+	assert.Equal(t, int64(2), result.RowsAffected)
+	assert.Equal(t, first, second)
+	assert.Len(t, backend.ttls, 1)
+	for key, ttl := range backend.ttls {
+		assert.True(t, strings.HasPrefix(key, "users:all:"), key)
+		assert.Equal(t, time.Minute, ttl)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCachePlugin_DifferentQueriesOnSameContext(t *testing.T) {
+	db, mock := newMockDB(t)
+	backend := newMemCache()
+	assert.NoError(t, db.Use(cachePlugin{backend: backend}))
+
+	mock.ExpectQuery(`SELECT \* FROM "cached_users" WHERE name = \$1`).WithArgs("ana").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "ana"))
+	mock.ExpectQuery(`SELECT \* FROM "cached_users" WHERE name = \$1`).WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(2, "bob"))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "cached_users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	ctx := WithCache(context.Background(), "users", time.Minute)
+
+	var ana, bob []cachedUser
+	assert.NoError(t, db.WithContext(ctx).Where("name = ?", "ana").Find(&ana).Error)
+	assert.NoError(t, db.WithContext(ctx).Where("name = ?", "bob").Find(&bob).Error)
+	var count int64
+	assert.NoError(t, db.WithContext(ctx).Model(&cachedUser{}).Count(&count).Error)
+
+	assert.Equal(t, []cachedUser{{ID: 1, Name: "ana"}}, ana)
+	assert.Equal(t, []cachedUser{{ID: 2, Name: "bob"}}, bob)
+	assert.Equal(t, int64(2), count)
+	assert.Len(t, backend.items, 3)
+
+	var cached []cachedUser
+	assert.NoError(t, db.WithContext(ctx).Where("name = ?", "bob").Find(&cached).Error)
+	assert.Equal(t, bob, cached)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+type cachedAuthor struct {
+	ID    uint
+	Books []cachedBook `gorm:"foreignKey:AuthorID"`
+}
+
+type cachedBook struct {
+	ID       uint
+	AuthorID uint
+}
+
+func TestCachePlugin_SkipsPreloadQueries(t *testing.T) {
+	db, mock := newMockDB(t)
+	backend := newMemCache()
+	assert.NoError(t, db.Use(cachePlugin{backend: backend}))
+
+	mock.ExpectQuery(`SELECT \* FROM "cached_authors"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`SELECT \* FROM "cached_books" WHERE "cached_books"."author_id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "author_id"}).AddRow(10, 1))
+
+	ctx := WithCache(context.Background(), "authors", time.Minute)
+	var authors []cachedAuthor
+	assert.NoError(t, db.WithContext(ctx).Preload("Books").Find(&authors).Error)
+
+	assert.Equal(t, []cachedAuthor{{ID: 1, Books: []cachedBook{{ID: 10, AuthorID: 1}}}}, authors)
+	assert.Len(t, backend.items, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCachePlugin_WithoutCacheContext_AlwaysQueries(t *testing.T) {
+	db, mock := newMockDB(t)
+	assert.NoError(t, db.Use(cachePlugin{backend: newMemCache()}))
+
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`SELECT \* FROM "cached_users"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "ana"))
+	}
+
+	for i := 0; i < 2; i++ {
+		var users []cachedUser
+		assert.NoError(t, db.WithContext(context.Background()).Find(&users).Error)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCachePlugin_NotFoundIsNotCached(t *testing.T) {
+	db, mock := newMockDB(t)
+	backend := newMemCache()
+	assert.NoError(t, db.Use(cachePlugin{backend: backend}))
+
+	mock.ExpectQuery(`SELECT \* FROM "cached_users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	ctx := WithCache(context.Background(), "users:first", time.Minute)
+	var user cachedUser
+	err := db.WithContext(ctx).First(&user).Error
+
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	assert.Empty(t, backend.items)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,65 @@
+package dbgo
+
+import (
+	"context"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type queryCommentKey struct{}
+
+// WithQueryComment returns a copy of ctx whose statements are prefixed with the SQL comment /* comment */,
+// e.g. WithQueryComment(ctx, "route:/users"). The comment shows up in pg_stat_statements, pg_stat_activity
+// and the server logs, so DBAs can attribute query load to application routes (as with sqlcommenter).
+// A nested call replaces the outer comment. Comment delimiters inside comment are neutralized, so it cannot
+// close the comment early. Comments are added by dbgo's GORM callbacks, so they only apply to connections
+// from GetConnection. With PrepareStmt, each distinct comment prepares its own statements.
+// Example:
+//
+//	ctx = dbgo.WithQueryComment(ctx, "route:"+r.URL.Path)
+//	err := dbgo.GetFromContext(ctx).Find(&users).Error // /* route:/users */ SELECT * FROM "users"
+func WithQueryComment(ctx context.Context, comment string) context.Context {
+	comment = strings.NewReplacer("/*", "/ *", "*/", "* /").Replace(comment)
+	return context.WithValue(ctx, queryCommentKey{}, queryComment("/* "+comment+" */"))
+}
+
+func queryCommentFrom(ctx context.Context) queryComment {
+	if ctx == nil {
+		return ""
+	}
+	comment, _ := ctx.Value(queryCommentKey{}).(queryComment)
+	return comment
+}
+
+// queryComment is a rendered SQL comment; it is a clause.Expression so it can precede a statement's first clause.
+type queryComment string
+
+func (c queryComment) Build(builder clause.Builder) {
+	builder.WriteString(string(c))
+}
+
+// commentStatement returns a callback that adds the context comment to statements whose SQL is built from
+// the clause named clauseName (e.g. "SELECT").
+func commentStatement(clauseName string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		comment := queryCommentFrom(db.Statement.Context)
+		if comment == "" || db.Error != nil {
+			return
+		}
+		// Raw SQL (db.Raw, db.Exec) is already built: prefix it directly.
+		if db.Statement.SQL.Len() > 0 {
+			sql := db.Statement.SQL.String()
+			db.Statement.SQL.Reset()
+			db.Statement.SQL.WriteString(string(comment) + " " + sql)
+			return
+		}
+		if clauseName == "" {
+			return
+		}
+		c := db.Statement.Clauses[clauseName]
+		c.BeforeExpression = comment
+		db.Statement.Clauses[clauseName] = c
+	}
+}
@@ -0,0 +1,52 @@
+package dbgo
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+type commentedUser struct {
+	ID   uint
+	Name string
+}
+
+func TestWithQueryComment_PrefixesStatements(t *testing.T) {
+	db, mock := newMockDB(t)
+	assert.NoError(t, db.Use(callbacksPlugin{}))
+	ctx := WithQueryComment(context.Background(), "route:/users")
+	db = db.WithContext(ctx)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`/* route:/users */ SELECT * FROM "commented_users"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "a"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`/* route:/users */ INSERT INTO "commented_users"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`/* route:/users */ DELETE FROM "commented_users" WHERE id = $1`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta(`/* route:/users */ UPDATE users SET name = $1`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`/* route:/users */ SELECT count(*) FROM users`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	var users []commentedUser
+	assert.NoError(t, db.Find(&users).Error)
+	assert.NoError(t, db.Create(&commentedUser{Name: "b"}).Error)
+	assert.NoError(t, db.Where("id = ?", 1).Delete(&commentedUser{}).Error)
+	assert.NoError(t, db.Exec("UPDATE users SET name = ?", "x").Error)
+	var count int64
+	assert.NoError(t, db.Raw("SELECT count(*) FROM users").Scan(&count).Error)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithQueryComment_NeutralizesDelimiters(t *testing.T) {
+	comment := queryCommentFrom(WithQueryComment(context.Background(), "x */ DROP TABLE users; /* y"))
+	assert.Equal(t, queryComment("/* x * / DROP TABLE users; / * y */"), comment)
+	assert.Equal(t, queryComment(""), queryCommentFrom(context.Background()))
+}
@@ -1,16 +1,139 @@
 package dbgo
 
-import "time"
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// PoolConfig holds the settings of one connection pool (see Config.ReplicaPoolConfigs). Nil fields keep the
+// database/sql defaults.
+type PoolConfig struct {
+	MaxOpenConns    *int
+	MaxIdleConns    *int
+	ConnMaxLifetime *time.Duration
+	ConnMaxIdleTime *time.Duration
+}
+
+// apply sets the non-nil settings on sqlDB.
+func (p PoolConfig) apply(sqlDB *sql.DB) {
+	if p.MaxOpenConns != nil {
+		sqlDB.SetMaxOpenConns(*p.MaxOpenConns)
+	}
+	if p.MaxIdleConns != nil {
+		sqlDB.SetMaxIdleConns(*p.MaxIdleConns)
+	}
+	if p.ConnMaxLifetime != nil {
+		sqlDB.SetConnMaxLifetime(*p.ConnMaxLifetime)
+	}
+	if p.ConnMaxIdleTime != nil {
+		sqlDB.SetConnMaxIdleTime(*p.ConnMaxIdleTime)
+	}
+}
+
+// validate checks the settings; field prefixes the setting names in errors (e.g. "ReplicaPoolConfigs[0].").
+func (p PoolConfig) validate(field string) error {
+	if p.MaxOpenConns != nil && *p.MaxOpenConns < 0 {
+		return fmt.Errorf("%w: %sMaxOpenConns must not be negative (got %d)", ErrInvalidConfig, field, *p.MaxOpenConns)
+	}
+	if p.MaxIdleConns != nil && *p.MaxIdleConns < 0 {
+		return fmt.Errorf("%w: %sMaxIdleConns must not be negative (got %d)", ErrInvalidConfig, field, *p.MaxIdleConns)
+	}
+	if p.ConnMaxLifetime != nil && *p.ConnMaxLifetime < 0 {
+		return fmt.Errorf("%w: %sConnMaxLifetime must not be negative (got %s)", ErrInvalidConfig, field, *p.ConnMaxLifetime)
+	}
+	if p.ConnMaxIdleTime != nil && *p.ConnMaxIdleTime < 0 {
+		return fmt.Errorf("%w: %sConnMaxIdleTime must not be negative (got %s)", ErrInvalidConfig, field, *p.ConnMaxIdleTime)
+	}
+	// Zero means unlimited for both MaxOpenConns and ConnMaxLifetime, so only compare against positive limits.
+	if p.MaxOpenConns != nil && p.MaxIdleConns != nil && *p.MaxOpenConns > 0 && *p.MaxIdleConns > *p.MaxOpenConns {
+		return fmt.Errorf("%w: %sMaxIdleConns (%d) exceeds MaxOpenConns (%d)", ErrInvalidConfig, field, *p.MaxIdleConns, *p.MaxOpenConns)
+	}
+	if p.ConnMaxLifetime != nil && p.ConnMaxIdleTime != nil && *p.ConnMaxLifetime > 0 && *p.ConnMaxIdleTime > *p.ConnMaxLifetime {
+		return fmt.Errorf("%w: %sConnMaxIdleTime (%s) exceeds ConnMaxLifetime (%s)", ErrInvalidConfig, field, *p.ConnMaxIdleTime, *p.ConnMaxLifetime)
+	}
+	return nil
+}
 
 // Config holds the settings for the database connection and optional features.
 type Config struct {
-	// PrimaryDSN is the data source name for the primary (read-write) PostgreSQL instance. Required.
+	// PrimaryDSN is the data source name for the primary (read-write) PostgreSQL instance. Required unless Dialector
+	// is set or ReadOnly is set with replicas.
 	PrimaryDSN string
 
+	// DSNProvider, when set, is used instead of PrimaryDSN: it is called for the DSN of each new primary connection,
+	// so credentials can come from a secrets manager (Vault, AWS Secrets Manager, ...) rather than the config, and
+	// rotated credentials are used by the connections opened after the rotation. ctx is the context of the
+	// statement (or ping) that needs the connection. It runs whenever the pool grows or replaces a connection, so
+	// cache the secret in the provider rather than fetching it on every call. Set either PrimaryDSN or DSNProvider.
+	DSNProvider func(ctx context.Context) (string, error)
+
+	// OnPrimaryChanged, when set, makes GetConnection probe the primary every PrimaryProbeInterval over a fresh
+	// connection (so the DSN's host is resolved again) for the address of the writable server behind it. When a
+	// failover promotes another server (e.g. an RDS cluster endpoint moving to the new writer), the primary pool's
+	// connections to the old one are retired as they return to the pool and replaced by connections to the new
+	// primary, then OnPrimaryChanged is called with the new "host:port" on a goroutine of its own, so it may call
+	// ResetConnection or Init (which stop the probing goroutine). Failed probes and read-only servers are ignored.
+	// It requires PrimaryDSN or DSNProvider (not Dialector).
+	OnPrimaryChanged func(newEndpoint string)
+
+	// PrimaryProbeInterval is how often the primary is probed for OnPrimaryChanged. Zero means 10s.
+	PrimaryProbeInterval time.Duration
+
 	// ReplicasDSN is the list of DSNs for read-only replicas. Queries that do not use dbresolver.Write
 	// may be executed against one of these replicas (policy: random). Leave nil or empty for no replicas.
 	ReplicasDSN []string
 
+	// Dialector, when set, is used to open the primary instead of building a postgres dialector from PrimaryDSN,
+	// giving full control over the driver and its connector. DSN-based options (PreferSimpleProtocol,
+	// ConnMaxLifetimeJitter, TraceConnectionAcquire, ConnInitSQL, StatementTimeout) do not apply to it; pool settings
+	// still do.
+	Dialector gorm.Dialector
+
+	// ReplicaDialectors, when set, are used as the replicas instead of ReplicasDSN (set only one of them).
+	ReplicaDialectors []gorm.Dialector
+
+	// ReadOnly allows a replica-only connection for services that never write: with ReadOnly and no PrimaryDSN
+	// (or Dialector), the first replica stands in for the primary, serving WithTransaction and any write, which the
+	// replica then rejects (see ErrReadOnlyConnection); reads are balanced across all replicas as usual.
+	ReadOnly bool
+
+	// ReplicaWeights sets the relative share of reads each replica receives, aligned by index with ReplicasDSN
+	// (or ReplicaDialectors, e.g. []int{70, 30}). When set, it must have one non-negative entry per replica and a positive total,
+	// and getConnection uses WeightedPolicy instead of random selection. Leave nil for uniform random.
+	ReplicaWeights []int
+
+	// ReplicaPoolConfigs sets the connection pool of each replica, aligned by index with ReplicasDSN (or
+	// ReplicaDialectors), so a small replica can be given fewer connections than the primary. When set, it must
+	// have one entry per replica; MaxOpenConns and the other pool settings above only apply to the primary.
+	// ReplicaDialectors must then be opened on a *sql.DB (postgres.Config{Conn: ...}). A ReadOnly connection to a
+	// single replica uses it as its primary pool, configured by the settings above instead.
+	ReplicaPoolConfigs []PoolConfig
+
+	// RejectPrimaryAsReplica makes Validate fail when a ReplicasDSN entry connects to the same host, port and
+	// database as PrimaryDSN (e.g. the primary DSN copy-pasted into the replica list), which would silently send
+	// every "replica" read to the primary. Without it, getConnection only logs a warning.
+	RejectPrimaryAsReplica bool
+
+	// PrepareStmt enables GORM's prepared statement cache on the primary. Nil means true (the default behavior).
+	// Set it to false behind poolers that do not support server-side prepared statements (e.g. PgBouncer in transaction mode).
+	PrepareStmt *bool
+
+	// ReplicaPrepareStmt enables the prepared statement cache on replicas. Nil follows PrepareStmt.
+	// Use it when replicas sit behind a different pooler than the primary.
+	ReplicaPrepareStmt *bool
+
+	// PreferSimpleProtocol makes pgx use the simple query protocol (no server-side prepared statements or
+	// described statements) for the primary and replicas. Combine it with PrepareStmt=false to run behind
+	// PgBouncer in transaction pooling mode.
+	PreferSimpleProtocol bool
+
 	// MaxOpenConns sets the maximum number of open connections to the database. Nil uses the driver default.
 	MaxOpenConns *int
 
@@ -23,7 +146,155 @@ type Config struct {
 	// ConnMaxIdleTime sets the maximum amount of time a connection may be idle before being closed. Nil uses the driver default.
 	ConnMaxIdleTime *time.Duration
 
-	// EnableTracing turns on Datadog APM tracing for GORM operations when true.
+	// ConnMaxLifetimeJitter shortens the lifetime of each primary connection by a random duration in [0, jitter),
+	// so connections opened together do not all expire (and reconnect) at the same moment.
+	// It requires ConnMaxLifetime and must be smaller than it. Zero disables jitter.
+	ConnMaxLifetimeJitter time.Duration
+
+	// ConnInitSQL lists statements run once on every new physical connection, before it is first used, e.g.
+	// "SET timezone = 'UTC'". Unlike per-query settings, they hold for the connection's whole life, on the primary
+	// and the replicas. A failing statement fails the connection attempt.
+	ConnInitSQL []string
+
+	// StatementTimeout, when positive, sets PostgreSQL's statement_timeout on every new connection of the primary
+	// and the replicas (run before ConnInitSQL, which can override it), rounded up to whole milliseconds: the server
+	// cancels any statement running longer, a ceiling that holds even when the application forgets a context
+	// deadline. A statement cancelled this way fails with SQLSTATE 57014 (query_canceled). Zero keeps the server
+	// default. Behind PgBouncer in transaction pooling mode, set it on the database or role instead.
+	StatementTimeout time.Duration
+
+	// DefaultIsolation is the isolation level used by WithTransaction when beginning a transaction.
+	// The zero value (sql.LevelDefault) uses the driver/server default.
+	DefaultIsolation sql.IsolationLevel
+
+	// SkipEmptyCommit makes WithTransaction roll back instead of commit when fn executed no write
+	// statements (INSERT/UPDATE/DELETE or raw SQL other than SELECT/SHOW/SET/RESET) through the context DB.
+	// Writes are detected by dbgo's GORM callbacks, so it only applies to connections from GetConnection.
+	// Raw SQL that is not provably read-only (see isReadOnlySQL) counts as a write, so it commits when in doubt.
+	SkipEmptyCommit bool
+
+	// SkipDefaultTransaction stops GORM from wrapping single creates, updates and deletes in an implicit transaction,
+	// saving a BEGIN/COMMIT round trip per write. Statements in WithTransaction are unaffected. Multi-statement
+	// writes (e.g. Create with associations) are then no longer atomic unless run inside WithTransaction.
+	SkipDefaultTransaction bool
+
+	// CreateBatchSize makes Create split slices into INSERT statements of at most CreateBatchSize rows, keeping
+	// bulk inserts under PostgreSQL's limit of 65535 bind parameters per statement. Zero inserts a slice in a
+	// single statement, as GORM does by default.
+	CreateBatchSize int
+
+	// DefaultQueryTimeout is the deadline RequestContext gives request contexts, bounding every statement run with
+	// them. Zero adds no deadline.
+	DefaultQueryTimeout time.Duration
+
+	// MaxTransactionDuration bounds how long WithTransaction keeps a transaction open: fn's context gets a deadline
+	// MaxTransactionDuration after BEGIN, and a transaction still running then is rolled back and returns
+	// ErrTransactionTimeout, even when fn returns nil. A safety net against units of work that hold a transaction
+	// (and its locks) across slow network calls. Zero sets no limit.
+	MaxTransactionDuration time.Duration
+
+	// MaxTransactionNesting limits how many WithTransaction calls may be nested inside a transaction (each reuses
+	// it): a deeper call returns ErrMaxNestingExceeded without running fn, turning runaway recursion through
+	// WithTransaction into a clear error. Zero sets no limit.
+	MaxTransactionNesting int
+
+	// OnTransactionEnd, when set, is called when each transaction begun by WithTransaction (or its variants, and
+	// MaybeTransaction once its transaction is begun) ends, with the caller's context, whether it was committed
+	// (see WithTransactionStatus), its duration from the start of the call and the error the call returns; a
+	// panic in fn is reported as an error before it is re-thrown. Use it to record transaction durations and commit/rollback rates. Nested calls, which
+	// reuse the outer transaction, do not call it. It runs synchronously, so keep it fast.
+	OnTransactionEnd func(ctx context.Context, committed bool, d time.Duration, err error)
+
+	// OnBeginTx, when set, is called right after WithTransaction (or its variants) begins a transaction, before fn,
+	// with fn's context and the transaction's DB, e.g. to set the session variables read by row-level security
+	// policies with SET LOCAL (reset by PostgreSQL when the transaction ends). An error rolls the transaction back
+	// and is returned without running fn. Nested calls, which reuse the outer transaction, do not call it.
+	OnBeginTx func(ctx context.Context, db *gorm.DB) error
+
+	// ContextKey is the key SetFromContext stores the DB under in contexts, and GetFromContext reads it from.
+	// Set it to a key of your own (an unexported type, as with context.WithValue) when several modules embedding
+	// dbgo share a process and must not see each other's context DB. It must be comparable. Nil uses dbgo's key.
+	ContextKey interface{}
+
+	// StrictContext disables the fallback to the default connection in GetFromContext (and everything built on it,
+	// such as WithTransaction and Exec): when the context carries no DB, nil/ErrNoDatabase is returned instead.
+	// Use it in tests to surface missing SetFromContext calls that would silently bypass a request's transaction.
+	StrictContext bool
+
+	// StrictTransactionContext makes WithTransaction require a DB in its context (set by SetFromContext or an outer
+	// WithTransaction) instead of falling back to the default connection. A nested call whose context lost the
+	// outer transaction then returns ErrNoDatabase rather than silently committing in a separate transaction.
+	// Unlike StrictContext, GetFromContext keeps its fallback, except in the goroutine running a transaction's
+	// fn: there a context without the transaction's DB makes it log an error and return nil.
+	StrictTransactionContext bool
+
+	// Debug enables development-time checks that log likely mistakes: WithTransaction warns when its context
+	// carries no DB and it falls back to the default connection, which usually means a missing SetFromContext, and
+	// every write (Create, Update, Delete, raw Exec) running outside WithTransaction is logged with whether GORM
+	// wrapped it in its implicit transaction, to audit what SkipDefaultTransaction would change. The one check that
+	// changes behavior catches a transaction used from several goroutines (the context DB inside fn holds its
+	// single connection): a statement started while another statement of the same transaction is running is
+	// logged with its stack and fails with ErrConcurrentTransactionUse instead of corrupting the connection (e.g.
+	// "unexpected Parse response"). Leave it off in production.
+	Debug bool
+
+	// NonFatalErrors lists errors (matched with errors.Is) that do not abort WithTransaction: when fn returns one of
+	// them, the transaction is committed and the error is still returned. Use it for expected outcomes such as
+	// gorm.ErrRecordNotFound that should keep fn's other writes. Only list errors that do not come from a failed
+	// statement: PostgreSQL aborts the transaction on any statement error, and its COMMIT then fails. Errors of
+	// OnBeginTx (and WithRole) always roll back, since fn did not run.
+	NonFatalErrors []error
+
+	// WrapErrors wraps errors returned by WithTransaction and the query helpers (Exec, Raw, DeleteInBatches)
+	// with the operation name, elapsed time and, for statements, whether they ran in a transaction, e.g.
+	// "dbgo: Exec failed after 1.2ms (in a transaction): ERROR: duplicate key ...". errors.Is/As still match.
+	// A nested WithTransaction returns fn's error as-is, leaving the wrapping to the outermost call.
+	WrapErrors bool
+
+	// LogRollbackSQL makes WithTransaction (and MaybeTransaction) log, at warn level, the error that rolled a
+	// transaction back together with the SQL (without bound values) of the last statement that failed in it, so a
+	// "constraint violation" can be traced to its statement. Failed statements are recorded by dbgo's GORM callbacks, so the SQL is only
+	// logged for connections from GetConnection.
+	LogRollbackSQL bool
+
+	// MaxQueryParams rejects statements with more bind parameters than this with ErrTooManyParameters (carrying
+	// the count) before they are sent, instead of the driver's error for PostgreSQL's limit of 65535, e.g. for
+	// a WHERE id IN (?) with a huge slice. It applies to connections from GetConnection, except for Row.
+	// Zero disables the check.
+	MaxQueryParams int
+
+	// QueryCache is the backend for the read-through query cache enabled per context with WithCache.
+	// Nil disables caching.
+	QueryCache Cache
+
+	// LogQueries logs every statement through logger-go with the statement's context, so query logs carry
+	// the request's logger fields (trace id, user id, ...). Failed statements are logged at error level.
+	LogQueries bool
+
+	// SlowQueryThreshold logs statements slower than the threshold at warn level through logger-go.
+	// Setting it (or LogQueries) replaces GORM's default stdout logger. Zero disables slow-query logging.
+	SlowQueryThreshold time.Duration
+
+	// OnSlowQuery, when set, is called with the statement's context, its SQL (with placeholders, without bound
+	// values) and its duration for every statement slower than SlowQueryThreshold, which it requires, e.g. to
+	// increment a metric tagged with the query or alert. It runs synchronously on the statement's goroutine, so it
+	// should be fast. Like the slow-query log, it only applies to connections from GetConnection.
+	OnSlowQuery func(ctx context.Context, sql string, elapsed time.Duration)
+
+	// NowFunc is the time source GORM uses for CreatedAt/UpdatedAt (and soft-delete timestamps).
+	// Nil uses GORM's default (time.Now().Local()). Set it in tests to freeze time.
+	NowFunc func() time.Time
+
+	// ForceUTC makes timestamps UTC regardless of the server's time zone: GORM stamps CreatedAt/UpdatedAt (and
+	// soft deletes) with NowFunc's time (time.Now by default) converted to UTC, and the time.Time and *time.Time
+	// fields of models loaded by queries are converted to UTC. Scans into structs other than the statement's model
+	// and raw Scan destinations are not converted.
+	ForceUTC bool
+
+	// EnableTracing turns on Datadog APM tracing for GORM operations when true. WithTransaction then starts a
+	// "db.transaction" span, parent of the spans of the statements run inside fn, and records a panic in fn on it.
+	// The Tracing* options below (and TraceConnectionAcquire, PoolMetricsInterval) only apply with it:
+	// getConnection logs a warning when they are set without it.
 	EnableTracing bool
 
 	// TracingServiceName is the service name shown in Datadog. If empty, the tracer default is used.
@@ -33,15 +304,308 @@ type Config struct {
 	// TracingAnalyticsRate sets the fraction of traces sent to analytics (0.0 to 1.0). Nil uses tracer default.
 	TracingAnalyticsRate *float64
 
+	// TracingReadAnalyticsRate overrides TracingAnalyticsRate for reads (queries and read-only raw SQL, see
+	// SkipEmptyCommit), so high-volume reads can be sampled less than writes. Nil keeps TracingAnalyticsRate.
+	TracingReadAnalyticsRate *float64
+
+	// TracingWriteAnalyticsRate overrides TracingAnalyticsRate for writes (create, update, delete and other raw SQL).
+	// Nil keeps TracingAnalyticsRate.
+	TracingWriteAnalyticsRate *float64
+
+	// TracingTransactionAnalyticsRate sets the analytics rate of the "db.transaction" spans started by
+	// WithTransaction. Nil leaves them without one.
+	TracingTransactionAnalyticsRate *float64
+
+	// TraceConnectionAcquire adds a "db.connection.acquire" span (see SpanNameConnectionAcquire) under each
+	// statement's span, covering the time spent waiting for a pool connection (including dialing a new one).
+	// It requires EnableTracing and wraps the driver connector of the primary and replicas.
+	TraceConnectionAcquire bool
+
+	// PoolMetricsInterval, when positive, reports the primary pool's sql.DBStats (open, in-use and idle connections,
+	// waits, ...) as gauges through PoolMetricsClient at that interval, tagged with the tracing service name, so pool
+	// saturation can be correlated with query latency. It requires EnableTracing and PoolMetricsClient.
+	PoolMetricsInterval time.Duration
+
+	// PoolMetricsClient receives the pool metrics enabled by PoolMetricsInterval, e.g. a DogStatsD *statsd.Client.
+	PoolMetricsClient MetricsClient
+
+	// TracingResourceNamer names the resource of statement spans, which defaults to the statement's SQL, so APM
+	// lists operations such as "SELECT users" instead of every distinct query. It receives the SQL keyword the
+	// statement starts with (e.g. "SELECT", "INSERT") and the statement's table, which is empty for raw SQL run
+	// without a model. Returning "" keeps the SQL. Requires EnableTracing.
+	TracingResourceNamer func(op, table string) string
+
 	// TracingErrorCheck is the function used to decide if an error is reported as an error span in Datadog.
 	// If nil, the tracing plugin's default behavior is used.
 	TracingErrorCheck func(error) bool
 }
 
-// Validate checks that Config has required fields. Returns an error suitable for DBConn.Error when invalid.
+// nowFunc returns the gorm.Config NowFunc for c: NowFunc, converted to UTC with ForceUTC.
+func (c Config) nowFunc() func() time.Time {
+	if !c.ForceUTC {
+		return c.NowFunc
+	}
+	now := c.NowFunc
+	if now == nil {
+		now = time.Now
+	}
+	return func() time.Time { return now().UTC() }
+}
+
+// replicaCount returns the number of configured replicas and the field they come from.
+func (c Config) replicaCount() (int, string) {
+	if len(c.ReplicaDialectors) > 0 {
+		return len(c.ReplicaDialectors), "ReplicaDialectors"
+	}
+	return len(c.ReplicasDSN), "ReplicasDSN"
+}
+
+// replicaOnly reports whether c describes a replica-only connection (ReadOnly without a primary).
+func (c Config) replicaOnly() bool {
+	return c.ReadOnly && c.PrimaryDSN == "" && c.DSNProvider == nil && c.Dialector == nil
+}
+
+// prepareStmt resolves PrepareStmt and ReplicaPrepareStmt to their effective values.
+func (c Config) prepareStmt() (primary, replicas bool) {
+	primary = c.PrepareStmt == nil || *c.PrepareStmt
+	replicas = primary
+	if c.ReplicaPrepareStmt != nil {
+		replicas = *c.ReplicaPrepareStmt
+	}
+	if c.replicaOnly() {
+		// The primary pool is a replica too.
+		primary = replicas
+	}
+	return primary, replicas
+}
+
+// Validate checks that Config has required fields and sane pool settings.
+// Returns an error wrapping ErrInvalidConfig (suitable for DBConn.Error) that describes the problem.
 func (c Config) Validate() error {
-	if c.PrimaryDSN == "" {
-		return ErrInvalidConfig
+	if c.PrimaryDSN == "" && c.DSNProvider == nil && c.Dialector == nil {
+		if !c.ReadOnly {
+			return fmt.Errorf("%w: PrimaryDSN is required", ErrInvalidConfig)
+		}
+		if n, _ := c.replicaCount(); n == 0 {
+			return fmt.Errorf("%w: ReadOnly without PrimaryDSN requires ReplicasDSN or ReplicaDialectors", ErrInvalidConfig)
+		}
+	}
+	if c.DSNProvider != nil && c.PrimaryDSN != "" {
+		return fmt.Errorf("%w: set either PrimaryDSN or DSNProvider, not both", ErrInvalidConfig)
+	}
+	if len(c.ReplicasDSN) > 0 && len(c.ReplicaDialectors) > 0 {
+		return fmt.Errorf("%w: set either ReplicasDSN or ReplicaDialectors, not both", ErrInvalidConfig)
+	}
+	if err := c.validateReplicaWeights(); err != nil {
+		return err
+	}
+	if err := c.validateReplicaPools(); err != nil {
+		return err
+	}
+	if i := c.replicaTargetingPrimary(); i >= 0 && c.RejectPrimaryAsReplica {
+		return fmt.Errorf("%w: ReplicasDSN[%d] points at the primary (same host, port and database as PrimaryDSN)", ErrInvalidConfig, i)
+	}
+	if err := c.validateAnalyticsRates(); err != nil {
+		return err
+	}
+	if c.ContextKey != nil && !reflect.TypeOf(c.ContextKey).Comparable() {
+		return fmt.Errorf("%w: ContextKey of type %T is not comparable", ErrInvalidConfig, c.ContextKey)
+	}
+	for i, target := range c.NonFatalErrors {
+		if target == nil {
+			return fmt.Errorf("%w: NonFatalErrors[%d] is nil", ErrInvalidConfig, i)
+		}
+	}
+	if c.MaxTransactionDuration < 0 {
+		return fmt.Errorf("%w: MaxTransactionDuration must not be negative (got %s)", ErrInvalidConfig, c.MaxTransactionDuration)
+	}
+	if c.MaxTransactionNesting < 0 {
+		return fmt.Errorf("%w: MaxTransactionNesting must not be negative (got %d)", ErrInvalidConfig, c.MaxTransactionNesting)
+	}
+	if c.DefaultQueryTimeout < 0 {
+		return fmt.Errorf("%w: DefaultQueryTimeout must not be negative (got %s)", ErrInvalidConfig, c.DefaultQueryTimeout)
+	}
+	if c.OnPrimaryChanged != nil && (c.Dialector != nil || c.PrimaryDSN == "" && c.DSNProvider == nil) {
+		return fmt.Errorf("%w: OnPrimaryChanged requires PrimaryDSN or DSNProvider, not Dialector", ErrInvalidConfig)
+	}
+	if c.PrimaryProbeInterval < 0 {
+		return fmt.Errorf("%w: PrimaryProbeInterval must not be negative (got %s)", ErrInvalidConfig, c.PrimaryProbeInterval)
+	}
+	if c.StatementTimeout < 0 {
+		return fmt.Errorf("%w: StatementTimeout must not be negative (got %s)", ErrInvalidConfig, c.StatementTimeout)
+	}
+	if c.MaxQueryParams < 0 {
+		return fmt.Errorf("%w: MaxQueryParams must not be negative (got %d)", ErrInvalidConfig, c.MaxQueryParams)
+	}
+	if c.CreateBatchSize < 0 {
+		return fmt.Errorf("%w: CreateBatchSize must not be negative (got %d)", ErrInvalidConfig, c.CreateBatchSize)
+	}
+	if c.SlowQueryThreshold < 0 {
+		return fmt.Errorf("%w: SlowQueryThreshold must not be negative (got %s)", ErrInvalidConfig, c.SlowQueryThreshold)
+	}
+	if c.OnSlowQuery != nil && c.SlowQueryThreshold == 0 {
+		return fmt.Errorf("%w: OnSlowQuery requires SlowQueryThreshold", ErrInvalidConfig)
+	}
+	if err := c.validatePoolMetrics(); err != nil {
+		return err
+	}
+	return c.validatePool()
+}
+
+func (c Config) validatePoolMetrics() error {
+	if c.PoolMetricsInterval < 0 {
+		return fmt.Errorf("%w: PoolMetricsInterval must not be negative (got %s)", ErrInvalidConfig, c.PoolMetricsInterval)
+	}
+	if c.PoolMetricsInterval > 0 && c.PoolMetricsClient == nil {
+		return fmt.Errorf("%w: PoolMetricsInterval requires PoolMetricsClient", ErrInvalidConfig)
+	}
+	return nil
+}
+
+func (c Config) validateReplicaWeights() error {
+	if len(c.ReplicaWeights) == 0 {
+		return nil
+	}
+	if n, field := c.replicaCount(); len(c.ReplicaWeights) != n {
+		return fmt.Errorf("%w: ReplicaWeights has %d entries but %s has %d", ErrInvalidConfig, len(c.ReplicaWeights), field, n)
+	}
+	total := 0
+	for i, w := range c.ReplicaWeights {
+		if w < 0 {
+			return fmt.Errorf("%w: ReplicaWeights[%d] must not be negative (got %d)", ErrInvalidConfig, i, w)
+		}
+		total += w
+	}
+	if total == 0 {
+		return fmt.Errorf("%w: ReplicaWeights must not all be zero", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// replicaTargetingPrimary returns the index of the first ReplicasDSN entry connecting to the same host, port
+// and database as PrimaryDSN, or -1. DSNs that do not parse are skipped; the driver reports them when connecting.
+func (c Config) replicaTargetingPrimary() int {
+	if c.PrimaryDSN == "" || len(c.ReplicasDSN) == 0 {
+		return -1
+	}
+	primary, err := pgconn.ParseConfig(c.PrimaryDSN)
+	if err != nil {
+		return -1
+	}
+	for i, dsn := range c.ReplicasDSN {
+		replica, err := pgconn.ParseConfig(dsn)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(replica.Host, primary.Host) && replica.Port == primary.Port && replica.Database == primary.Database {
+			return i
+		}
+	}
+	return -1
+}
+
+// primaryProbeInterval returns PrimaryProbeInterval, or its default when zero.
+func (c Config) primaryProbeInterval() time.Duration {
+	if c.PrimaryProbeInterval > 0 {
+		return c.PrimaryProbeInterval
+	}
+	return defaultPrimaryProbeInterval
+}
+
+// connInitSQL returns the statements run on each new connection: the statement_timeout of StatementTimeout
+// followed by ConnInitSQL.
+func (c Config) connInitSQL() []string {
+	if c.StatementTimeout <= 0 {
+		return c.ConnInitSQL
+	}
+	ms := (c.StatementTimeout + time.Millisecond - 1) / time.Millisecond
+	return append([]string{fmt.Sprintf("SET statement_timeout = %d", ms)}, c.ConnInitSQL...)
+}
+
+// tracingOptionsWithoutTracing returns the names of the tracing options set while EnableTracing is off, which
+// getConnection warns about: they have no effect until tracing is enabled (e.g. with UpdateTracing).
+func (c Config) tracingOptionsWithoutTracing() []string {
+	if c.EnableTracing {
+		return nil
+	}
+	var fields []string
+	for _, o := range []struct {
+		field string
+		set   bool
+	}{
+		{"TracingServiceName", c.TracingServiceName != ""},
+		{"TracingAnalyticsRate", c.TracingAnalyticsRate != nil},
+		{"TracingReadAnalyticsRate", c.TracingReadAnalyticsRate != nil},
+		{"TracingWriteAnalyticsRate", c.TracingWriteAnalyticsRate != nil},
+		{"TracingTransactionAnalyticsRate", c.TracingTransactionAnalyticsRate != nil},
+		{"TraceConnectionAcquire", c.TraceConnectionAcquire},
+		{"PoolMetricsInterval", c.PoolMetricsInterval > 0},
+		{"TracingResourceNamer", c.TracingResourceNamer != nil},
+		{"TracingErrorCheck", c.TracingErrorCheck != nil},
+	} {
+		if o.set {
+			fields = append(fields, o.field)
+		}
+	}
+	return fields
+}
+
+func (c Config) validateAnalyticsRates() error {
+	for _, r := range []struct {
+		field string
+		rate  *float64
+	}{
+		{"TracingAnalyticsRate", c.TracingAnalyticsRate},
+		{"TracingReadAnalyticsRate", c.TracingReadAnalyticsRate},
+		{"TracingWriteAnalyticsRate", c.TracingWriteAnalyticsRate},
+		{"TracingTransactionAnalyticsRate", c.TracingTransactionAnalyticsRate},
+	} {
+		if r.rate != nil && !(*r.rate >= 0 && *r.rate <= 1) {
+			return fmt.Errorf("%w: %s must be between 0.0 and 1.0 (got %v)", ErrInvalidConfig, r.field, *r.rate)
+		}
+	}
+	return nil
+}
+
+func (c Config) validatePool() error {
+	if err := c.primaryPool().validate(""); err != nil {
+		return err
+	}
+	if c.ConnMaxLifetimeJitter < 0 {
+		return fmt.Errorf("%w: ConnMaxLifetimeJitter must not be negative (got %s)", ErrInvalidConfig, c.ConnMaxLifetimeJitter)
+	}
+	if c.ConnMaxLifetimeJitter > 0 && (c.ConnMaxLifetime == nil || c.ConnMaxLifetimeJitter >= *c.ConnMaxLifetime) {
+		return fmt.Errorf("%w: ConnMaxLifetimeJitter (%s) requires a larger ConnMaxLifetime", ErrInvalidConfig, c.ConnMaxLifetimeJitter)
+	}
+	return nil
+}
+
+// primaryPool returns the pool settings of the primary.
+func (c Config) primaryPool() PoolConfig {
+	return PoolConfig{
+		MaxOpenConns:    c.MaxOpenConns,
+		MaxIdleConns:    c.MaxIdleConns,
+		ConnMaxLifetime: c.ConnMaxLifetime,
+		ConnMaxIdleTime: c.ConnMaxIdleTime,
+	}
+}
+
+func (c Config) validateReplicaPools() error {
+	if len(c.ReplicaPoolConfigs) == 0 {
+		return nil
+	}
+	if n, field := c.replicaCount(); len(c.ReplicaPoolConfigs) != n {
+		return fmt.Errorf("%w: ReplicaPoolConfigs has %d entries but %s has %d", ErrInvalidConfig, len(c.ReplicaPoolConfigs), field, n)
+	}
+	for i, p := range c.ReplicaPoolConfigs {
+		if err := p.validate(fmt.Sprintf("ReplicaPoolConfigs[%d].", i)); err != nil {
+			return err
+		}
+		if len(c.ReplicaDialectors) > 0 {
+			if _, ok := dialectorPool(c.ReplicaDialectors[i]); !ok {
+				return fmt.Errorf("%w: ReplicaPoolConfigs[%d] requires ReplicaDialectors[%d] to be opened on a *sql.DB", ErrInvalidConfig, i, i)
+			}
+		}
 	}
 	return nil
 }
@@ -0,0 +1,310 @@
+package dbgo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// primaryDialector returns the dialector used to open the primary, and the connector it wraps (nil when
+// the DSN is opened directly). Features that need to see individual connections (ConnMaxLifetimeJitter,
+// TraceConnectionAcquire, ConnInitSQL, StatementTimeout, OnPrimaryChanged) are implemented by a driver.Connector wrapping the pgx connector;
+// otherwise the DSN is handed to the postgres driver as-is. Config.Dialector, when set, is returned unchanged.
+// With Config.DSNProvider the primary is always opened through a connector, which fetches the DSN per connection.
+// For a replica-only Config (see Config.ReadOnly), the first replica is opened as the primary.
+func primaryDialector(config Config) (gorm.Dialector, *connector, error) {
+	if config.Dialector != nil {
+		return config.Dialector, nil, nil
+	}
+	dsn := config.PrimaryDSN
+	if config.replicaOnly() {
+		if len(config.ReplicaDialectors) > 0 {
+			return config.ReplicaDialectors[0], nil, nil
+		}
+		dsn = config.ReplicasDSN[0]
+	}
+	c := &connector{
+		traceAcquire: config.EnableTracing && config.TraceConnectionAcquire,
+		initSQL:      config.connInitSQL(),
+		probePrimary: config.OnPrimaryChanged != nil,
+	}
+	if config.ConnMaxLifetimeJitter > 0 && config.ConnMaxLifetime != nil {
+		c.setLifetime(*config.ConnMaxLifetime)
+		c.jitter = config.ConnMaxLifetimeJitter
+	}
+	if config.DSNProvider != nil {
+		c.Connector = providerConnector{provider: config.DSNProvider, simpleProtocol: config.PreferSimpleProtocol}
+		return postgres.New(postgres.Config{Conn: sql.OpenDB(c)}), c, nil
+	}
+	return newDialector(dsn, c, config.PreferSimpleProtocol)
+}
+
+// replicaDialector returns the dialector for the i-th replica DSN. Config's pool settings (and
+// ConnMaxLifetimeJitter) only apply to the primary, so replicas are wrapped only for TraceConnectionAcquire and
+// ConnInitSQL. With ReplicaPoolConfigs the replica's pool is opened here, rather than by dbresolver, to apply them.
+func replicaDialector(dsn string, i int, config Config) (gorm.Dialector, error) {
+	c := &connector{traceAcquire: config.EnableTracing && config.TraceConnectionAcquire, initSQL: config.connInitSQL()}
+	if len(config.ReplicaPoolConfigs) == 0 {
+		d, _, err := newDialector(dsn, c, config.PreferSimpleProtocol)
+		return d, err
+	}
+	base, err := openConnector(dsn, config.PreferSimpleProtocol)
+	if err != nil {
+		return nil, err
+	}
+	c.Connector = base
+	sqlDB := sql.OpenDB(c)
+	config.ReplicaPoolConfigs[i].apply(sqlDB)
+	return postgres.New(postgres.Config{DSN: dsn, Conn: sqlDB}), nil
+}
+
+// replicaDialectors returns the dialectors of config.ReplicasDSN (see replicaDialector). When a replica fails,
+// the pools already opened for the previous ones are closed.
+func replicaDialectors(config Config) ([]gorm.Dialector, error) {
+	replicas := make([]gorm.Dialector, len(config.ReplicasDSN))
+	for i, r := range config.ReplicasDSN {
+		d, err := replicaDialector(r, i, config)
+		if err != nil {
+			closeDialectorPools(replicas[:i])
+			return nil, err
+		}
+		replicas[i] = d
+	}
+	return replicas, nil
+}
+
+// closeDialectorPools closes the pools opened for dialectors (see dialectorPool); dialectors given a DSN only
+// have none yet.
+func closeDialectorPools(dialectors []gorm.Dialector) {
+	for _, d := range dialectors {
+		if sqlDB, ok := dialectorPool(d); ok {
+			_ = sqlDB.Close()
+		}
+	}
+}
+
+// dialectorPool returns the *sql.DB a postgres dialector was opened on (postgres.Config.Conn), if any.
+func dialectorPool(d gorm.Dialector) (*sql.DB, bool) {
+	pd, ok := d.(*postgres.Dialector)
+	if !ok || pd.Config == nil {
+		return nil, false
+	}
+	sqlDB, ok := pd.Conn.(*sql.DB)
+	return sqlDB, ok && sqlDB != nil
+}
+
+// newDialector opens dsn through c when c has anything to do, and directly otherwise; the returned
+// connector is c in the first case and nil in the second.
+// simpleProtocol selects pgx's simple protocol (Config.PreferSimpleProtocol) on either path.
+func newDialector(dsn string, c *connector, simpleProtocol bool) (gorm.Dialector, *connector, error) {
+	if c.jitter <= 0 && !c.traceAcquire && len(c.initSQL) == 0 && !c.probePrimary {
+		return postgres.New(postgres.Config{DSN: dsn, PreferSimpleProtocol: simpleProtocol}), nil, nil
+	}
+	base, err := openConnector(dsn, simpleProtocol)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.Connector = base
+	return postgres.New(postgres.Config{DSN: dsn, Conn: sql.OpenDB(c)}), c, nil
+}
+
+// openConnector returns the pgx driver.Connector for dsn.
+func openConnector(dsn string, simpleProtocol bool) (driver.Connector, error) {
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if simpleProtocol {
+		cfg.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	}
+	return stdlib.GetConnector(*cfg), nil
+}
+
+// providerConnector opens each connection with the DSN returned by Config.DSNProvider at that moment, so
+// rotated credentials are picked up by the connections opened after the rotation.
+type providerConnector struct {
+	provider       func(ctx context.Context) (string, error)
+	simpleProtocol bool
+}
+
+func (c providerConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	dsn, err := c.provider(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("dbgo: DSNProvider: %w", err)
+	}
+	base, err := openConnector(dsn, c.simpleProtocol)
+	if err != nil {
+		return nil, err
+	}
+	return base.Connect(ctx)
+}
+
+func (providerConnector) Driver() driver.Driver {
+	return stdlib.GetDefaultDriver()
+}
+
+// connector wraps a driver.Connector to customize the connections handed to database/sql: a randomized
+// lifetime per connection (lifetime/jitter), connection acquisition spans (traceAcquire), statements
+// run on each new connection (initSQL) and retiring every open connection at once (rotate).
+type connector struct {
+	driver.Connector
+	lifetime     atomic.Int64 // time.Duration; zero = connections do not expire on their own
+	jitter       time.Duration
+	traceAcquire bool
+	initSQL      []string
+	probePrimary bool         // Config.OnPrimaryChanged: watchPrimary probes through this connector
+	generation   atomic.Int64 // incremented by rotate
+}
+
+// rotate expires the connections opened so far: database/sql discards each one the next time it is returned
+// to or taken from the pool, and opens new ones instead (see watchPrimary).
+func (c *connector) rotate() {
+	c.generation.Add(1)
+}
+
+// setLifetime sets the base lifetime of connections opened from now on (see UpdatePoolConfig).
+func (c *connector) setLifetime(d time.Duration) {
+	c.lifetime.Store(int64(d))
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	dc, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.initConn(ctx, dc); err != nil {
+		_ = dc.Close()
+		return nil, err
+	}
+	if c.traceAcquire {
+		// A new connection is being handed to the statement that asked for it.
+		markAcquired(ctx)
+	}
+	wrapped := &driverConn{Conn: dc, traceAcquire: c.traceAcquire, generation: c.generation.Load(), current: &c.generation}
+	if lifetime := c.connLifetime(); lifetime > 0 {
+		wrapped.expiresAt = time.Now().Add(lifetime)
+	}
+	return wrapped, nil
+}
+
+// initConn runs initSQL on a new connection, before database/sql uses it for anything else.
+func (c *connector) initConn(ctx context.Context, dc driver.Conn) error {
+	if len(c.initSQL) == 0 {
+		return nil
+	}
+	execer, ok := dc.(driver.ExecerContext)
+	if !ok {
+		return fmt.Errorf("%w: ConnInitSQL requires a driver connection implementing driver.ExecerContext", ErrInvalidConfig)
+	}
+	for _, stmt := range c.initSQL {
+		if _, err := execer.ExecContext(ctx, stmt, nil); err != nil {
+			return fmt.Errorf("dbgo: ConnInitSQL %q: %w", stmt, err)
+		}
+	}
+	return nil
+}
+
+// connLifetime returns lifetime minus a random duration in [0, jitter), or zero for no expiry.
+// database/sql still enforces ConnMaxLifetime, so the jitter can only shorten a connection's life.
+func (c *connector) connLifetime() time.Duration {
+	lifetime := time.Duration(c.lifetime.Load())
+	if lifetime <= 0 || c.jitter <= 0 || c.jitter >= lifetime {
+		return lifetime
+	}
+	return lifetime - rand.N(c.jitter)
+}
+
+// driverConn wraps a driver connection so database/sql discards it once expiresAt has passed, or once its
+// connector has been rotated. It forwards the optional driver interfaces implemented by pgx; note that sql.Conn.Raw
+// exposes this wrapper rather than the underlying *stdlib.Conn.
+type driverConn struct {
+	driver.Conn
+	expiresAt    time.Time // zero = never expires
+	traceAcquire bool
+	generation   int64         // the connector's generation when the connection was opened
+	current      *atomic.Int64 // the connector's current generation; nil = never rotated
+}
+
+func (c *driverConn) expired() bool {
+	if c.current != nil && c.current.Load() != c.generation {
+		return true
+	}
+	return !c.expiresAt.IsZero() && time.Now().After(c.expiresAt)
+}
+
+// IsValid implements driver.Validator; database/sql calls it before returning a connection to the pool.
+func (c *driverConn) IsValid() bool {
+	if c.expired() {
+		return false
+	}
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// ResetSession implements driver.SessionResetter; database/sql calls it before reusing a pooled connection.
+// A pooled connection is being handed to the statement behind ctx, so it also ends the acquisition span.
+func (c *driverConn) ResetSession(ctx context.Context) error {
+	if c.expired() {
+		return driver.ErrBadConn
+	}
+	if c.traceAcquire {
+		markAcquired(ctx)
+	}
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *driverConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin() //nolint:staticcheck // fallback for drivers without ConnBeginTx
+}
+
+func (c *driverConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *driverConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *driverConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *driverConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *driverConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
@@ -0,0 +1,248 @@
+package dbgo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+)
+
+// fakeDriverConn is a minimal driver.Conn that records validation calls.
+type fakeDriverConn struct {
+	valid bool
+	reset int
+}
+
+func (c *fakeDriverConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c *fakeDriverConn) Close() error                        { return nil }
+func (c *fakeDriverConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+func (c *fakeDriverConn) IsValid() bool                       { return c.valid }
+func (c *fakeDriverConn) ResetSession(context.Context) error {
+	c.reset++
+	return nil
+}
+
+type fakeConnector struct{ conn driver.Conn }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return c.conn, nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+func TestConnector_LifetimeWithinJitter(t *testing.T) {
+	c := &connector{jitter: 10 * time.Minute}
+	c.setLifetime(time.Hour)
+	for range 100 {
+		got := c.connLifetime()
+		assert.LessOrEqual(t, got, time.Hour)
+		assert.Greater(t, got, 50*time.Minute)
+	}
+}
+
+func TestConnector_Connect_SetsExpiry(t *testing.T) {
+	c := &connector{Connector: fakeConnector{conn: &fakeDriverConn{valid: true}}, jitter: time.Minute}
+	c.setLifetime(time.Hour)
+
+	dc, err := c.Connect(context.Background())
+	assert.NoError(t, err)
+	wrapped, ok := dc.(*driverConn)
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), wrapped.expiresAt, time.Minute+time.Second)
+}
+
+// execDriverConn is a fakeDriverConn that records the statements executed on it.
+type execDriverConn struct {
+	fakeDriverConn
+	executed []string
+	fail     string
+	closed   bool
+}
+
+func (c *execDriverConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if query == c.fail {
+		return nil, errors.New("syntax error")
+	}
+	c.executed = append(c.executed, query)
+	return driver.RowsAffected(0), nil
+}
+
+func (c *execDriverConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestConnector_Connect_RunsInitSQL(t *testing.T) {
+	initSQL := []string{"SET timezone = 'UTC'", "SET search_path = app"}
+	dc := &execDriverConn{}
+	c := &connector{Connector: fakeConnector{conn: dc}, initSQL: initSQL}
+
+	_, err := c.Connect(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, initSQL, dc.executed)
+	assert.False(t, dc.closed)
+
+	failing := &execDriverConn{fail: "SET search_path = app"}
+	c = &connector{Connector: fakeConnector{conn: failing}, initSQL: initSQL}
+	_, err = c.Connect(context.Background())
+	assert.ErrorContains(t, err, "SET search_path = app")
+	assert.True(t, failing.closed, "a connection whose init fails is closed")
+
+	c = &connector{Connector: fakeConnector{conn: &fakeDriverConn{}}, initSQL: initSQL}
+	_, err = c.Connect(context.Background())
+	assert.Error(t, err, "init SQL needs driver.ExecerContext")
+}
+
+func TestDriverConn_Expired(t *testing.T) {
+	inner := &fakeDriverConn{valid: true}
+	live := &driverConn{Conn: inner, expiresAt: time.Now().Add(time.Hour)}
+	assert.True(t, live.IsValid())
+	assert.NoError(t, live.ResetSession(context.Background()))
+	assert.Equal(t, 1, inner.reset, "ResetSession is forwarded while the connection is live")
+
+	inner.valid = false
+	assert.False(t, live.IsValid(), "IsValid is forwarded while the connection is live")
+
+	expired := &driverConn{Conn: &fakeDriverConn{valid: true}, expiresAt: time.Now().Add(-time.Second)}
+	assert.False(t, expired.IsValid())
+	assert.ErrorIs(t, expired.ResetSession(context.Background()), driver.ErrBadConn)
+}
+
+func TestConnector_Rotate(t *testing.T) {
+	c := &connector{Connector: fakeConnector{conn: &fakeDriverConn{valid: true}}}
+	before, err := c.Connect(context.Background())
+	assert.NoError(t, err)
+	old := before.(*driverConn)
+	assert.True(t, old.IsValid())
+
+	c.rotate()
+	assert.False(t, old.IsValid(), "connections opened before rotate are retired")
+	assert.ErrorIs(t, old.ResetSession(context.Background()), driver.ErrBadConn)
+
+	after, err := c.Connect(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, after.(*driverConn).IsValid())
+}
+
+func TestPrimaryDialector(t *testing.T) {
+	lifetime := time.Hour
+	cfg := Config{PrimaryDSN: "host=localhost dbname=test"}
+
+	d, c, err := primaryDialector(cfg)
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+	assert.Nil(t, d.(*postgres.Dialector).Conn, "without jitter the DSN is opened by the driver")
+
+	cfg.ConnMaxLifetime = &lifetime
+	cfg.ConnMaxLifetimeJitter = time.Minute
+	d, c, err = primaryDialector(cfg)
+	assert.NoError(t, err)
+	assert.NotNil(t, c)
+	sqlDB, ok := d.(*postgres.Dialector).Conn.(*sql.DB)
+	assert.True(t, ok, "with jitter the dialector wraps a connector-backed *sql.DB")
+	assert.NoError(t, sqlDB.Close())
+
+	d, err = replicaDialector("host=replica dbname=test", 0, Config{ConnInitSQL: []string{"SET timezone = 'UTC'"}})
+	assert.NoError(t, err)
+	sqlDB, ok = d.(*postgres.Dialector).Conn.(*sql.DB)
+	assert.True(t, ok, "with ConnInitSQL replicas are opened through the connector too")
+	assert.NoError(t, sqlDB.Close())
+}
+
+func TestPrimaryDialector_StatementTimeout(t *testing.T) {
+	d, c, err := primaryDialector(Config{PrimaryDSN: "host=localhost dbname=test", StatementTimeout: 30 * time.Second})
+	assert.NoError(t, err)
+	if assert.NotNil(t, c, "statement_timeout is set by the connector") {
+		assert.Equal(t, []string{"SET statement_timeout = 30000"}, c.initSQL)
+		assert.NoError(t, d.(*postgres.Dialector).Conn.(*sql.DB).Close())
+	}
+}
+
+func TestPrimaryDialector_OnPrimaryChanged(t *testing.T) {
+	d, c, err := primaryDialector(Config{PrimaryDSN: "host=localhost dbname=test", OnPrimaryChanged: func(string) {}})
+	assert.NoError(t, err)
+	if assert.NotNil(t, c, "the primary's connections must be retirable on a primary change") {
+		assert.True(t, c.probePrimary)
+		assert.NoError(t, d.(*postgres.Dialector).Conn.(*sql.DB).Close())
+	}
+}
+
+func TestPrimaryDialector_PreferSimpleProtocol(t *testing.T) {
+	cfg := Config{PrimaryDSN: "host=localhost dbname=test", PreferSimpleProtocol: true}
+
+	d, _, err := primaryDialector(cfg)
+	assert.NoError(t, err)
+	assert.True(t, d.(*postgres.Dialector).PreferSimpleProtocol)
+
+	d, err = replicaDialector("host=replica dbname=test", 0, cfg)
+	assert.NoError(t, err)
+	assert.True(t, d.(*postgres.Dialector).PreferSimpleProtocol)
+}
+
+func TestReplicaDialector_PoolConfig(t *testing.T) {
+	maxOpen := 3
+	cfg := Config{ReplicasDSN: []string{"host=replica dbname=test"}, ReplicaPoolConfigs: []PoolConfig{{MaxOpenConns: &maxOpen}}}
+
+	d, err := replicaDialector(cfg.ReplicasDSN[0], 0, cfg)
+	assert.NoError(t, err)
+	sqlDB, ok := dialectorPool(d)
+	assert.True(t, ok, "replicas with a pool config are opened here")
+	assert.Equal(t, 3, sqlDB.Stats().MaxOpenConnections)
+	assert.NoError(t, sqlDB.Close())
+}
+
+func TestReplicaDialectors_ClosesOpenedPoolsOnError(t *testing.T) {
+	maxOpen := 3
+	cfg := Config{
+		ReplicasDSN:        []string{"host=replica1 dbname=test", "host=replica2 port=notaport"},
+		ReplicaPoolConfigs: []PoolConfig{{MaxOpenConns: &maxOpen}, {MaxOpenConns: &maxOpen}},
+	}
+	replicas, err := replicaDialectors(cfg)
+	assert.Error(t, err)
+	assert.Nil(t, replicas)
+
+	cfg.ReplicasDSN[1] = "host=replica2 dbname=test"
+	replicas, err = replicaDialectors(cfg)
+	assert.NoError(t, err)
+	assert.Len(t, replicas, 2)
+	closeDialectorPools(replicas)
+	for _, d := range replicas {
+		sqlDB, ok := dialectorPool(d)
+		if assert.True(t, ok) {
+			assert.ErrorContains(t, sqlDB.Ping(), "database is closed")
+		}
+	}
+}
+
+func TestPrimaryDialector_DSNProvider(t *testing.T) {
+	calls := 0
+	fetchErr := errors.New("vault sealed")
+	cfg := Config{DSNProvider: func(ctx context.Context) (string, error) {
+		calls++
+		if calls == 1 {
+			return "", fetchErr
+		}
+		return "postgres://bad host:port", nil
+	}}
+
+	d, c, err := primaryDialector(cfg)
+	assert.NoError(t, err)
+	assert.NotNil(t, c)
+	assert.Equal(t, 0, calls, "the DSN is fetched when a connection is opened")
+	sqlDB, ok := d.(*postgres.Dialector).Conn.(*sql.DB)
+	assert.True(t, ok, "with a DSNProvider the dialector wraps a connector-backed *sql.DB")
+	t.Cleanup(func() { sqlDB.Close() })
+
+	err = sqlDB.PingContext(context.Background())
+	assert.ErrorIs(t, err, fetchErr)
+	assert.Contains(t, err.Error(), "DSNProvider")
+	assert.Error(t, sqlDB.PingContext(context.Background()), "each new connection fetches the DSN again")
+	assert.Equal(t, 2, calls)
+}
+
+func TestOpenConnector_InvalidDSN(t *testing.T) {
+	_, err := openConnector("postgres://bad host:port", true)
+	assert.Error(t, err)
+}
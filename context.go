@@ -2,17 +2,36 @@ package dbgo
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/adnvilla/logger-go"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
 type contextKey struct{}
 
 var dbContextKey = contextKey{}
 
+// dbKey returns the key the context DB is stored under: Config.ContextKey of the active connection, or
+// dbContextKey.
+func dbKey() interface{} {
+	connMu.RLock()
+	key := activeConfig.ContextKey
+	connMu.RUnlock()
+	if key == nil {
+		return dbContextKey
+	}
+	return key
+}
+
 // GetFromContext returns the *gorm.DB from ctx, or the default singleton if not set.
 // It can return nil when neither the context nor the default connection has a DB (e.g. before Init or after ResetConnection).
+// With Config.StrictContext enabled it never falls back to the singleton and returns nil when ctx carries no DB.
+// With Config.StrictTransactionContext it returns nil instead of falling back when called from the goroutine
+// running a WithTransaction's fn, whose statements would otherwise silently run outside the transaction.
+// The returned DB runs its statements with ctx, so cancelling ctx (e.g. a client disconnecting from an HTTP
+// handler) cancels the running query on the server too, even when the DB was stored with a parent context.
 // Callers must check for nil before use; see WithTransaction for the recommended pattern:
 //
 //	dbInstance := dbgo.GetFromContext(ctx)
@@ -20,13 +39,23 @@ var dbContextKey = contextKey{}
 //	    return dbgo.ErrNoDatabase
 //	}
 func GetFromContext(ctx context.Context) *gorm.DB {
-	if db, ok := ctx.Value(dbContextKey).(*gorm.DB); ok {
-		return db
+	if db, ok := contextDB(ctx); ok {
+		return bindContext(db, ctx)
 	}
 
 	connMu.RLock()
 	instance := conn.Instance
+	strict := activeConfig.StrictContext
+	strictTx := activeConfig.StrictTransactionContext
 	connMu.RUnlock()
+	if strict {
+		logger.Warn(ctx, "No GORM DB instance found in context (strict context mode: not falling back to the default connection).")
+		return nil
+	}
+	if strictTx && inTxGoroutine() {
+		logger.Error(ctx, "GetFromContext called inside WithTransaction with a context without the transaction's DB (strict transaction context mode: not falling back to the default connection, which would run outside the transaction).")
+		return nil
+	}
 	if instance != nil {
 		if instance.Statement != nil {
 			return instance.WithContext(ctx)
@@ -49,8 +78,172 @@ func MustGetFromContext(ctx context.Context) *gorm.DB {
 	return db
 }
 
+// MustTxDB returns the transaction DB that WithTransaction stored in ctx, and panics when ctx is not inside a
+// transaction. Use it in repositories whose writes must only run as part of a unit of work: every repository
+// built from the same transaction context gets the same *gorm.DB, and a call outside WithTransaction fails loudly
+// instead of silently running its statements on their own connection. Unlike GetFromContext it never falls back
+// to the default connection.
+// Example:
+//
+//	func (r *orderRepository) Save(ctx context.Context, o *Order) error {
+//	    return dbgo.MustTxDB(ctx).Save(o).Error
+//	}
+func MustTxDB(ctx context.Context) *gorm.DB {
+	db, _ := contextDB(ctx)
+	if !isTransaction(db) {
+		panic("dbgo: MustTxDB called outside a transaction (use WithTransaction)")
+	}
+	return db
+}
+
 // SetFromContext stores the given *gorm.DB in ctx and returns the updated context.
 // Retrieve it later with GetFromContext or MustGetFromContext.
+// The DB is stored under Config.ContextKey when set.
 func SetFromContext(ctx context.Context, db *gorm.DB) context.Context {
-	return context.WithValue(ctx, dbContextKey, db)
+	return context.WithValue(ctx, dbKey(), db)
+}
+
+// WithContextKey sets the key the context DB is stored under (see Config.ContextKey), so modules embedding dbgo in
+// the same process don't collide on it.
+// Example:
+//
+//	type ordersDBKey struct{}
+//	config := dbgo.Config{PrimaryDSN: "..."}
+//	config = *dbgo.WithContextKey(ordersDBKey{})(&config)
+func WithContextKey(key interface{}) func(*Config) *Config {
+	return func(cfg *Config) *Config {
+		cfg.ContextKey = key
+		return cfg
+	}
+}
+
+// RouteByMethod returns a copy of ctx whose DB (see GetFromContext) routes statements by HTTP method:
+// GET and HEAD requests read from the replicas (dbresolver.Read), every other method uses the primary
+// (dbresolver.Write), so handlers of unsafe methods read their own writes. ctx is returned unchanged when it
+// has no DB or already carries a transaction, which stays on its connection. Call it in HTTP middleware.
+// Example:
+//
+//	func dbRouting(next http.Handler) http.Handler {
+//	    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//	        next.ServeHTTP(w, r.WithContext(dbgo.RouteByMethod(r.Context(), r.Method)))
+//	    })
+//	}
+func RouteByMethod(ctx context.Context, method string) context.Context {
+	op := dbresolver.Write
+	if method == http.MethodGet || method == http.MethodHead {
+		op = dbresolver.Read
+	}
+	return routeContext(ctx, op)
+}
+
+// RequirePrimary returns a copy of ctx whose DB (see GetFromContext) runs every statement, reads included, on
+// the primary, for read-after-write outside a transaction: reading back a row just created could otherwise hit
+// a lagging replica. Like RouteByMethod, ctx is returned unchanged when it has no DB or carries a transaction
+// (which already runs on the primary).
+// Example:
+//
+//	if err := dbgo.GetFromContext(ctx).Create(&order).Error; err != nil {
+//	    return err
+//	}
+//	ctx = dbgo.RequirePrimary(ctx)
+//	err := dbgo.GetFromContext(ctx).Preload("Items").First(&order, order.ID).Error
+func RequirePrimary(ctx context.Context) context.Context {
+	return routeContext(ctx, dbresolver.Write)
+}
+
+// WithReadReplica returns a copy of ctx whose DB (see GetFromContext) sends every read to the replicas, raw SQL
+// included: dbresolver otherwise guesses the target of raw SQL from its first word, sending a read that starts
+// with WITH (a CTE) to the primary. Create, Update and Delete still run on the primary, but raw writes (Exec)
+// are sent to a replica, which rejects them (see ErrReadOnlyConnection); switch to WithWrite for the write phase.
+// Like RouteByMethod, ctx is returned unchanged when it has no DB or carries a transaction.
+// Example:
+//
+//	readCtx := dbgo.WithReadReplica(ctx)
+//	err := dbgo.Raw(readCtx, &pending, "WITH due AS (...) SELECT ...")
+//	...
+//	writeCtx := dbgo.WithWrite(ctx)
+//	_, err = dbgo.Exec(writeCtx, "UPDATE invoices SET ...")
+func WithReadReplica(ctx context.Context) context.Context {
+	return routeContext(ctx, dbresolver.Read)
+}
+
+// WithWrite returns a copy of ctx whose DB (see GetFromContext) runs every statement on the primary. It is the
+// counterpart of WithReadReplica for a batch job's write phase, and is equivalent to RequirePrimary.
+func WithWrite(ctx context.Context) context.Context {
+	return routeContext(ctx, dbresolver.Write)
+}
+
+// routeContext stores in ctx the context DB pinned to op (dbresolver.Read or dbresolver.Write), unless ctx has no
+// DB or carries a transaction.
+func routeContext(ctx context.Context, op dbresolver.Operation) context.Context {
+	db := GetFromContext(ctx)
+	if !hasConnection(db) || isTransaction(db) {
+		return ctx
+	}
+	// Session makes the routed DB reusable across statements.
+	return SetFromContext(ctx, db.Clauses(op).Session(&gorm.Session{}))
+}
+
+// RequestContext returns a request-scoped context for handlers: it carries the default connection (see
+// SetFromContext), unless parent already has a DB such as a transaction, and a deadline of
+// Config.DefaultQueryTimeout from now when set. The deadline covers every statement run with the context.
+// Call the returned CancelFunc when the request ends. Without a connection, the context carries no DB.
+// Example:
+//
+//	ctx, cancel := dbgo.RequestContext(r.Context())
+//	defer cancel()
+//	err := dbgo.GetFromContext(ctx).Find(&users).Error
+func RequestContext(parent context.Context) (context.Context, context.CancelFunc) {
+	connMu.RLock()
+	instance := conn.Instance
+	timeout := activeConfig.DefaultQueryTimeout
+	connMu.RUnlock()
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	if _, ok := contextDB(ctx); !ok && hasConnection(instance) {
+		ctx = SetFromContext(ctx, instance.WithContext(ctx))
+	}
+	return ctx, cancel
+}
+
+// bindContext returns db bound to ctx when ctx is cancelled differently from the context db is bound to, e.g. a
+// request context derived with a deadline after SetFromContext. pgx sends a cancel request to the server when the
+// statement context is done, so the query is terminated rather than left running. A DB already bound to a context
+// sharing ctx's cancellation is returned as-is.
+func bindContext(db *gorm.DB, ctx context.Context) *gorm.DB {
+	if !hasConnection(db) {
+		return db
+	}
+	if bound := db.Statement.Context; bound != nil && bound.Done() == ctx.Done() {
+		return db
+	}
+	return db.WithContext(ctx)
+}
+
+// contextDB returns the *gorm.DB stored in ctx by SetFromContext, without falling back to the default connection.
+func contextDB(ctx context.Context) (*gorm.DB, bool) {
+	db, ok := ctx.Value(dbKey()).(*gorm.DB)
+	return db, ok
+}
+
+// dbFromContext resolves the DB like GetFromContext and binds ctx to it so statements are
+// cancelled and traced with the caller's context. Returns ErrNoDatabase when no DB is available.
+func dbFromContext(ctx context.Context) (*gorm.DB, error) {
+	db := GetFromContext(ctx)
+	if !hasConnection(db) {
+		return nil, ErrNoDatabase
+	}
+	return db.WithContext(ctx), nil
+}
+
+// hasConnection reports whether db is usable for statements. A nil or zero-value *gorm.DB
+// (not created by gorm.Open) is treated as no connection so callers can return ErrNoDatabase instead of panicking.
+func hasConnection(db *gorm.DB) bool {
+	return db != nil && db.Config != nil && db.Statement != nil
 }
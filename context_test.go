@@ -2,9 +2,13 @@ package dbgo
 
 import (
 	"context"
+	"net/http"
 	"testing"
+	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
@@ -125,3 +129,335 @@ func TestMustGetFromContext_WhenNoDB_Panics(t *testing.T) {
 		MustGetFromContext(context.Background())
 	})
 }
+
+func TestWithContextKey(t *testing.T) {
+	saveAndRestoreConn(t)
+	type moduleKey struct{}
+
+	db := &gorm.DB{}
+	connMu.Lock()
+	conn = DBConn{}
+	activeConfig = *WithContextKey(moduleKey{})(&Config{})
+	connMu.Unlock()
+
+	ctx := SetFromContext(context.Background(), db)
+	assert.Same(t, db, ctx.Value(moduleKey{}))
+	assert.Nil(t, ctx.Value(dbContextKey))
+	assert.Same(t, db, GetFromContext(ctx))
+
+	// A DB stored under dbgo's default key belongs to another module.
+	other := context.WithValue(context.Background(), dbContextKey, db)
+	assert.Nil(t, GetFromContext(other))
+}
+
+func TestGetFromContext_CancelsInFlightQuery(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT pg_sleep`).WillDelayFor(10 * time.Second).WillReturnRows(sqlmock.NewRows([]string{"pg_sleep"}))
+
+	// The DB is stored before the request context gets its deadline, as middleware typically does.
+	ctx := SetFromContext(context.Background(), db)
+	ctx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	var rows []map[string]interface{}
+	err := GetFromContext(ctx).Raw("SELECT pg_sleep(10)").Scan(&rows).Error
+	assert.Error(t, err, "the query is cancelled with the context")
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	bound := GetFromContext(ctx)
+	assert.Same(t, bound, GetFromContext(SetFromContext(ctx, bound)), "a DB bound to ctx is returned as-is")
+}
+
+func TestMustTxDB(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	assert.PanicsWithValue(t, "dbgo: MustTxDB called outside a transaction (use WithTransaction)", func() {
+		MustTxDB(context.Background())
+	}, "the default connection is not a transaction")
+	assert.Panics(t, func() { MustTxDB(SetFromContext(context.Background(), db)) })
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	err := WithTransaction(context.Background(), func(ctx context.Context) error {
+		assert.Same(t, GetFromContext(ctx), MustTxDB(ctx))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetFromContext_StrictContext_NoFallback(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	globalDB := &gorm.DB{}
+	connMu.Lock()
+	conn = DBConn{Instance: globalDB}
+	activeConfig = Config{StrictContext: true}
+	connMu.Unlock()
+
+	assert.Nil(t, GetFromContext(context.Background()), "strict mode must not fall back to the global connection")
+	assert.ErrorIs(t, WithTransaction(context.Background(), func(context.Context) error { return nil }), ErrNoDatabase)
+
+	contextDB := &gorm.DB{}
+	ctx := SetFromContext(context.Background(), contextDB)
+	assert.Same(t, contextDB, GetFromContext(ctx), "a DB in context is still returned in strict mode")
+}
+
+func TestWithTransaction_StrictTransactionContext(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{StrictTransactionContext: true}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	err := WithTransaction(SetFromContext(context.Background(), db), func(ctx context.Context) error {
+		// A context that lost the transaction must not start a second transaction on the global connection.
+		return WithTransaction(context.Background(), func(context.Context) error { return nil })
+	})
+
+	assert.ErrorIs(t, err, ErrNoDatabase)
+	assert.Contains(t, err.Error(), "StrictTransactionContext")
+	assert.NotNil(t, GetFromContext(context.Background()), "GetFromContext keeps its fallback")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetFromContext_StrictTransactionContextInsideTransaction(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{StrictTransactionContext: true}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	err := WithTransaction(SetFromContext(context.Background(), db), func(ctx context.Context) error {
+		assert.NotNil(t, GetFromContext(ctx), "the transaction's context still has its DB")
+
+		done := make(chan *gorm.DB)
+		go func() { done <- GetFromContext(context.Background()) }()
+		assert.NotNil(t, <-done, "other goroutines keep the fallback")
+
+		// A context that lost the transaction must not run statements on the default connection.
+		if GetFromContext(context.Background()) == nil {
+			return ErrNoDatabase
+		}
+		return nil
+	})
+
+	assert.ErrorIs(t, err, ErrNoDatabase)
+	assert.NotNil(t, GetFromContext(context.Background()), "the fallback is back once the transaction ended")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransaction_DebugKeepsFallback(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{Debug: true}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	// The fallback is logged, not refused.
+	assert.NoError(t, WithTransaction(context.Background(), func(context.Context) error { return nil }))
+	assert.NoError(t, WithTransaction(SetFromContext(context.Background(), db), func(context.Context) error { return nil }))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRouteByMethod(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	primaryDB, primary, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { primaryDB.Close() })
+	replicaDB, replica, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { replicaDB.Close() })
+
+	noPrepare := false
+	result := GetConnection(Config{
+		Dialector:         postgres.New(postgres.Config{Conn: primaryDB}),
+		ReplicaDialectors: []gorm.Dialector{postgres.New(postgres.Config{Conn: replicaDB})},
+		PrepareStmt:       &noPrepare,
+	})
+	assert.NoError(t, result.Error)
+
+	replica.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	replica.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	primary.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	primary.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	var count int64
+	get := RouteByMethod(context.Background(), http.MethodGet)
+	for range 2 { // the routed DB is reusable
+		assert.NoError(t, Raw(get, &count, "SELECT count(*) FROM users"))
+		assert.Equal(t, int64(1), count)
+	}
+	post := RouteByMethod(context.Background(), http.MethodPost)
+	for range 2 {
+		assert.NoError(t, Raw(post, &count, "SELECT count(*) FROM users"))
+		assert.Equal(t, int64(2), count, "unsafe methods read from the primary")
+	}
+	assert.NoError(t, primary.ExpectationsWereMet())
+	assert.NoError(t, replica.ExpectationsWereMet())
+}
+
+func TestRouteByMethod_KeepsTransaction(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	tx := db.Begin()
+	ctx := SetFromContext(context.Background(), tx)
+	assert.Equal(t, ctx, RouteByMethod(ctx, http.MethodGet))
+
+	empty := context.Background()
+	saveAndRestoreConn(t)
+	connMu.Lock()
+	conn = DBConn{}
+	connMu.Unlock()
+	assert.Equal(t, empty, RouteByMethod(empty, http.MethodGet))
+}
+
+func TestRequirePrimary(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	primaryDB, primary, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { primaryDB.Close() })
+	replicaDB, replica, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { replicaDB.Close() })
+
+	noPrepare := false
+	result := GetConnection(Config{
+		Dialector:         postgres.New(postgres.Config{Conn: primaryDB}),
+		ReplicaDialectors: []gorm.Dialector{postgres.New(postgres.Config{Conn: replicaDB})},
+		PrepareStmt:       &noPrepare,
+	})
+	assert.NoError(t, result.Error)
+
+	replica.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	primary.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	primary.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	var count int64
+	assert.NoError(t, Raw(context.Background(), &count, "SELECT count(*) FROM users"))
+	assert.Equal(t, int64(1), count, "reads go to the replica by default")
+	ctx := RequirePrimary(context.Background())
+	for range 2 {
+		assert.NoError(t, Raw(ctx, &count, "SELECT count(*) FROM users"))
+		assert.Equal(t, int64(2), count)
+	}
+	assert.NoError(t, primary.ExpectationsWereMet())
+	assert.NoError(t, replica.ExpectationsWereMet())
+
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	txCtx := SetFromContext(context.Background(), db.Begin())
+	assert.Equal(t, txCtx, RequirePrimary(txCtx), "a transaction is kept")
+}
+
+func TestWithReadReplica_WithWrite(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	primaryDB, primary, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { primaryDB.Close() })
+	replicaDB, replica, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { replicaDB.Close() })
+
+	noPrepare := false
+	result := GetConnection(Config{
+		Dialector:         postgres.New(postgres.Config{Conn: primaryDB}),
+		ReplicaDialectors: []gorm.Dialector{postgres.New(postgres.Config{Conn: replicaDB})},
+		PrepareStmt:       &noPrepare,
+	})
+	assert.NoError(t, result.Error)
+
+	const cte = "WITH due AS (SELECT id FROM invoices) SELECT count(*) FROM due"
+	primary.ExpectQuery(`WITH due`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	replica.ExpectQuery(`WITH due`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	replica.ExpectQuery(`WITH due`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	primary.ExpectExec(`UPDATE invoices`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var count int64
+	assert.NoError(t, Raw(context.Background(), &count, cte))
+	assert.Equal(t, int64(2), count, "dbresolver sends CTEs to the primary by default")
+	readCtx := WithReadReplica(context.Background())
+	for range 2 {
+		assert.NoError(t, Raw(readCtx, &count, cte))
+		assert.Equal(t, int64(1), count)
+	}
+	_, err = Exec(WithWrite(readCtx), "UPDATE invoices SET paid = true")
+	assert.NoError(t, err)
+	assert.NoError(t, primary.ExpectationsWereMet())
+	assert.NoError(t, replica.ExpectationsWereMet())
+
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	txCtx := SetFromContext(context.Background(), db.Begin())
+	assert.Equal(t, txCtx, WithReadReplica(txCtx), "a transaction is kept")
+}
+
+func TestRequestContext(t *testing.T) {
+	saveAndRestoreConn(t)
+	db, _ := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{DefaultQueryTimeout: time.Minute}
+	connMu.Unlock()
+
+	ctx, cancel := RequestContext(context.Background())
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+	stored, ok := contextDB(ctx)
+	if assert.True(t, ok) {
+		stmtDeadline, _ := stored.Statement.Context.Deadline()
+		assert.Equal(t, deadline, stmtDeadline, "the stored DB runs statements with the request deadline")
+	}
+	cancel()
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+
+	tx := db.Session(&gorm.Session{})
+	parent := SetFromContext(context.Background(), tx)
+	ctx, cancel = RequestContext(parent)
+	defer cancel()
+	stored, _ = contextDB(ctx)
+	assert.Same(t, tx, stored, "a DB already in the context is kept")
+
+	connMu.Lock()
+	conn = DBConn{}
+	activeConfig = Config{}
+	connMu.Unlock()
+	ctx, cancel = RequestContext(context.Background())
+	defer cancel()
+	_, hasDeadline := ctx.Deadline()
+	assert.False(t, hasDeadline)
+	_, ok = contextDB(ctx)
+	assert.False(t, ok)
+}
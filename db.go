@@ -2,17 +2,18 @@ package dbgo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"gorm.io/driver/postgres"
+	logger "github.com/adnvilla/logger-go"
 	"gorm.io/gorm"
 	"gorm.io/plugin/dbresolver"
 )
 
-// ErrInvalidConfig is returned when Config fails validation (e.g. empty PrimaryDSN).
-var ErrInvalidConfig = errors.New("dbgo: invalid config: PrimaryDSN is required")
-
 // DBConn wraps a GORM database connection and any error from initialization.
 type DBConn struct {
 	Instance *gorm.DB
@@ -23,11 +24,19 @@ type DBConn struct {
 // It is assigned to a package-level variable so it can be overridden in tests (e.g. with a mock);
 // production code should use it as-is. Restore the default with UseDefaultConnection() after tests.
 var (
-	conn          DBConn
-	activeConfig  Config
-	dbConnOnce    sync.Once
-	connMu        sync.RWMutex
-	GetConnection = getConnection
+	conn           DBConn
+	activeConfig   Config
+	primaryConn    *connector   // connector wrapping the primary pool, nil when the DSN is opened directly
+	stopWatchers   []func()     // stop the goroutines watching the pool (metrics, saturation events, primary probes)
+	stopSaturation func()       // stops the pool saturation watcher, started once ConnectionEvents has a subscriber
+	connTracing    *liveTracing // settings of the connection's tracing callbacks, changed by UpdateTracing
+	dbConnOnce     sync.Once
+	testMode       atomic.Bool // see EnableTestMode
+	connMu         sync.RWMutex
+	GetConnection  = getConnection
+
+	shutdownMu    sync.Mutex
+	shutdownHooks []func(context.Context) error
 )
 
 // GetActiveConfig returns the Config used to establish the current connection.
@@ -39,6 +48,77 @@ func GetActiveConfig() Config {
 	return cfg
 }
 
+// IsConnected reports whether the singleton connection has been established successfully, without
+// triggering it like GetConnection would. It is false before the first GetConnection call, when opening
+// the connection failed, and after ResetConnection or Shutdown. It does not check that the database is
+// reachable; use DBConn.Healthy or Ping for that.
+func IsConnected() bool {
+	connMu.RLock()
+	defer connMu.RUnlock()
+	return conn.Instance != nil && conn.Error == nil
+}
+
+// SQLDB returns the *sql.DB of the singleton connection's primary pool, for libraries that need a database/sql
+// handle (e.g. golang-migrate, sql.DBStats exporters). It returns ErrNoDatabase before the connection is established
+// and the connection error when opening it failed. Closing the handle closes the connection for the whole package;
+// use ResetConnection or Shutdown instead.
+func SQLDB() (*sql.DB, error) {
+	connMu.RLock()
+	c := conn
+	connMu.RUnlock()
+	if c.Error != nil {
+		return nil, c.Error
+	}
+	if !hasConnection(c.Instance) {
+		return nil, ErrNoDatabase
+	}
+	return c.Instance.DB()
+}
+
+// Resolver returns the *dbresolver.DBResolver that GetConnection registered for the read replicas, for the
+// low-level control dbgo does not wrap: running Call on every source, tuning the replica pools with
+// SetMaxOpenConns, or registering sources for specific tables with Register (before serving traffic; the
+// resolver is not safe to change while statements run). Changes made through it apply to the whole package's
+// connection and are not reflected in GetActiveConfig. It returns ErrNoDatabase before the connection is established, the
+// connection error when opening it failed, and ErrNoResolver when the connection has no replicas.
+func Resolver() (*dbresolver.DBResolver, error) {
+	connMu.RLock()
+	c := conn
+	connMu.RUnlock()
+	if c.Error != nil {
+		return nil, c.Error
+	}
+	if !hasConnection(c.Instance) {
+		return nil, ErrNoDatabase
+	}
+	resolver, ok := c.Instance.Config.Plugins[(&dbresolver.DBResolver{}).Name()].(*dbresolver.DBResolver)
+	if !ok {
+		return nil, ErrNoResolver
+	}
+	return resolver, nil
+}
+
+// EnableTestMode makes every later GetConnection call open a new, isolated connection instead of the singleton,
+// so tests (parallel ones included) each get their own DB without the ResetConnection dance. The connection is not
+// stored in the package: pass it with SetFromContext, because package functions given a context without a DB
+// fall back to the singleton, and close it when the test ends. Package-level settings are not per connection
+// either: options read when statements or transactions run (e.g. WrapErrors, SkipEmptyCommit) still come from
+// GetActiveConfig, and UpdateTracing or UpdatePoolConfig only apply to the singleton. Pool watchers
+// (EventPoolSaturated, pool metrics) are not started for isolated connections. Call it once, e.g. in TestMain;
+// the returned function restores the previous mode, e.g. t.Cleanup(dbgo.EnableTestMode()) in a single test.
+// Example:
+//
+//	func newTestDB(t *testing.T) context.Context {
+//	    dbConn := dbgo.GetConnection(dbgo.Config{PrimaryDSN: testDSN})
+//	    require.NoError(t, dbConn.Error)
+//	    t.Cleanup(func() { sqlDB, _ := dbConn.Instance.DB(); sqlDB.Close() })
+//	    return dbgo.SetFromContext(context.Background(), dbConn.Instance)
+//	}
+func EnableTestMode() (restore func()) {
+	previous := testMode.Swap(true)
+	return func() { testMode.Store(previous) }
+}
+
 // UseDefaultConnection restores GetConnection to the default implementation.
 func UseDefaultConnection() {
 	GetConnection = getConnection
@@ -52,74 +132,93 @@ func applyPoolConfig(db *gorm.DB, config Config) error {
 	if sqlDB == nil {
 		return nil
 	}
-	if config.MaxOpenConns != nil {
-		sqlDB.SetMaxOpenConns(*config.MaxOpenConns)
+	config.primaryPool().apply(sqlDB)
+	return nil
+}
+
+// UpdatePoolConfig applies new pool settings to the live primary connection without reconnecting, e.g. to
+// raise MaxOpenConns during an incident. Arguments follow database/sql: zero means unlimited for maxOpen,
+// maxLifetime and maxIdleTime. The values are validated like Config (including ConnMaxLifetimeJitter, which
+// keeps applying to connections opened from now on) and recorded in GetActiveConfig.
+// Open connections are not closed: they are retired as they exceed the new limits.
+// Returns ErrNoDatabase when no connection has been established, or an error wrapping ErrInvalidConfig.
+func UpdatePoolConfig(maxOpen, maxIdle int, maxLifetime, maxIdleTime time.Duration) error {
+	connMu.Lock()
+	defer connMu.Unlock()
+	if !hasConnection(conn.Instance) {
+		return ErrNoDatabase
 	}
-	if config.MaxIdleConns != nil {
-		sqlDB.SetMaxIdleConns(*config.MaxIdleConns)
+	cfg := activeConfig
+	cfg.MaxOpenConns, cfg.MaxIdleConns = &maxOpen, &maxIdle
+	cfg.ConnMaxLifetime, cfg.ConnMaxIdleTime = &maxLifetime, &maxIdleTime
+	if err := cfg.validatePool(); err != nil {
+		return err
 	}
-	if config.ConnMaxLifetime != nil {
-		sqlDB.SetConnMaxLifetime(*config.ConnMaxLifetime)
+	if err := applyPoolConfig(conn.Instance, cfg); err != nil {
+		return err
 	}
-	if config.ConnMaxIdleTime != nil {
-		sqlDB.SetConnMaxIdleTime(*config.ConnMaxIdleTime)
+	if primaryConn != nil && cfg.ConnMaxLifetimeJitter > 0 {
+		primaryConn.setLifetime(maxLifetime)
 	}
+	activeConfig = cfg
 	return nil
 }
 
+// gormConfig builds the gorm.Config used to open the primary connection.
+func gormConfig(config Config) *gorm.Config {
+	primaryPrepare, replicaPrepare := config.prepareStmt()
+	replicas, _ := config.replicaCount()
+	splitPrepare := replicas > 0 && primaryPrepare != replicaPrepare
+	cfg := &gorm.Config{
+		// With split settings, prepared statements are applied per source by preparedStmtPlugin instead.
+		PrepareStmt:            primaryPrepare && !splitPrepare,
+		SkipDefaultTransaction: config.SkipDefaultTransaction,
+		CreateBatchSize:        config.CreateBatchSize,
+		NowFunc:                config.nowFunc(),
+	}
+	if l := newQueryLogger(config); l != nil {
+		cfg.Logger = l
+	}
+	return cfg
+}
+
 func getConnection(config Config) *DBConn {
 	if err := config.Validate(); err != nil {
 		return &DBConn{Error: err}
 	}
+	if testMode.Load() {
+		opened := openConnection(config)
+		return &opened.conn
+	}
 	dbConnOnce.Do(func() {
 		connMu.Lock()
 		activeConfig = config
 		connMu.Unlock()
 
-		db, err := gorm.Open(postgres.Open(config.PrimaryDSN), &gorm.Config{PrepareStmt: true})
-		if err != nil {
-			connMu.Lock()
-			conn.Instance, conn.Error = db, err
-			connMu.Unlock()
-			return
-		}
-
-		if err := applyPoolConfig(db, config); err != nil {
-			connMu.Lock()
-			conn.Instance, conn.Error = db, err
-			connMu.Unlock()
-			return
-		}
-
-		if len(config.ReplicasDSN) > 0 {
-			replicas := make([]gorm.Dialector, len(config.ReplicasDSN))
-			for i, r := range config.ReplicasDSN {
-				replicas[i] = postgres.Open(r)
+		opened := openConnection(config)
+		var watchers []func()
+		if opened.conn.Error == nil {
+			if sqlDB, dbErr := opened.conn.Instance.DB(); dbErr == nil {
+				if config.EnableTracing && config.PoolMetricsInterval > 0 {
+					tags := []string{"service:" + tracingServiceName(config)}
+					watchers = append(watchers, startPoolMetrics(sqlDB, config.PoolMetricsClient, config.PoolMetricsInterval, tags))
+				}
 			}
-			if err = db.Use(dbresolver.Register(dbresolver.Config{
-				Replicas: replicas,
-				Policy:   dbresolver.RandomPolicy{},
-			})); err != nil {
-				connMu.Lock()
-				conn.Instance, conn.Error = db, err
-				connMu.Unlock()
-				return
-			}
-		}
-
-		if config.EnableTracing {
-			db, err = EnableTracing(db, config)
-			if err != nil {
-				connMu.Lock()
-				conn.Instance, conn.Error = db, err
-				connMu.Unlock()
-				return
+			if config.OnPrimaryChanged != nil && opened.primary != nil {
+				watchers = append(watchers, watchPrimary(opened.primary, config.primaryProbeInterval(), config.OnPrimaryChanged))
 			}
 		}
 
 		connMu.Lock()
-		conn.Instance, conn.Error = db, err
+		conn = opened.conn
+		primaryConn = opened.primary
+		stopWatchers = watchers
+		connTracing = opened.tracing
+		watchSaturation()
 		connMu.Unlock()
+		if opened.conn.Error == nil {
+			emitConnEvent(ConnEvent{Type: EventConnected})
+		}
 	})
 	connMu.RLock()
 	result := conn
@@ -127,12 +226,126 @@ func getConnection(config Config) *DBConn {
 	return &result
 }
 
+// openedConnection is a connection opened by openConnection, with the state the singleton keeps about it.
+type openedConnection struct {
+	conn    DBConn
+	primary *connector   // see primaryConn
+	tracing *liveTracing // see connTracing; nil when opening failed
+}
+
+// openConnection opens a connection for config and installs dbgo's plugins on it, without touching the
+// singleton. When it fails, conn.Error is set and conn.Instance holds the DB opened so far, if any.
+func openConnection(config Config) openedConnection {
+	if i := config.replicaTargetingPrimary(); i >= 0 {
+		logger.Warn(context.Background(), "dbgo: ReplicasDSN[%d] points at the primary (same host, port and database as PrimaryDSN); reads sent to it load the primary.", i)
+	}
+	if fields := config.tracingOptionsWithoutTracing(); len(fields) > 0 {
+		logger.Warn(context.Background(), "dbgo: %s set without EnableTracing; no spans are produced until tracing is enabled (WithTracing or UpdateTracing).", strings.Join(fields, ", "))
+	}
+
+	dialector, c, err := primaryDialector(config)
+	if err != nil {
+		return openedConnection{conn: DBConn{Error: err}}
+	}
+
+	db, err := gorm.Open(dialector, gormConfig(config))
+	if err != nil {
+		return openedConnection{conn: DBConn{Instance: db, Error: err}, primary: c}
+	}
+
+	if err = applyPoolConfig(db, config); err != nil {
+		return openedConnection{conn: DBConn{Instance: db, Error: err}, primary: c}
+	}
+
+	// A replica-only connection to a single replica needs no resolver: the primary pool is that replica.
+	if n, _ := config.replicaCount(); n > 1 || (n == 1 && !config.replicaOnly()) {
+		replicas := config.ReplicaDialectors
+		if len(replicas) == 0 {
+			if replicas, err = replicaDialectors(config); err != nil {
+				emitConnEvent(ConnEvent{Type: EventReplicaFailed, Err: err})
+				return openedConnection{conn: DBConn{Instance: db, Error: err}, primary: c}
+			}
+		} else {
+			for i, p := range config.ReplicaPoolConfigs {
+				sqlDB, _ := dialectorPool(replicas[i]) // Validate checked it is set
+				p.apply(sqlDB)
+			}
+		}
+		var policy dbresolver.Policy = dbresolver.RandomPolicy{}
+		if len(config.ReplicaWeights) > 0 {
+			policy = NewWeightedPolicy(config.ReplicaWeights)
+		}
+		if err = db.Use(dbresolver.Register(dbresolver.Config{
+			Replicas: replicas,
+			Policy:   policy,
+		})); err != nil {
+			if len(config.ReplicaDialectors) == 0 {
+				closeDialectorPools(replicas)
+			}
+			emitConnEvent(ConnEvent{Type: EventReplicaFailed, Err: err})
+			return openedConnection{conn: DBConn{Instance: db, Error: err}, primary: c}
+		}
+
+		if primaryPrepare, replicaPrepare := config.prepareStmt(); primaryPrepare != replicaPrepare {
+			if err = db.Use(newPreparedStmtPlugin(primaryPrepare)); err != nil {
+				return openedConnection{conn: DBConn{Instance: db, Error: err}, primary: c}
+			}
+		}
+	}
+
+	if err = db.Use(callbacksPlugin{}); err != nil {
+		return openedConnection{conn: DBConn{Instance: db, Error: err}, primary: c}
+	}
+
+	if config.Debug {
+		if err = db.Use(implicitTxPlugin{}); err != nil {
+			return openedConnection{conn: DBConn{Instance: db, Error: err}, primary: c}
+		}
+		if err = db.Use(concurrentTxPlugin{}); err != nil {
+			return openedConnection{conn: DBConn{Instance: db, Error: err}, primary: c}
+		}
+	}
+
+	if config.ForceUTC {
+		if err = db.Use(utcPlugin{}); err != nil {
+			return openedConnection{conn: DBConn{Instance: db, Error: err}, primary: c}
+		}
+	}
+
+	// Installed before the cache, whose gorm:query wrapper must see the statement's own pool.
+	if config.MaxQueryParams > 0 {
+		if err = db.Use(paramGuardPlugin{max: config.MaxQueryParams}); err != nil {
+			return openedConnection{conn: DBConn{Instance: db, Error: err}, primary: c}
+		}
+	}
+
+	if config.QueryCache != nil {
+		if err = db.Use(cachePlugin{backend: config.QueryCache}); err != nil {
+			return openedConnection{conn: DBConn{Instance: db, Error: err}, primary: c}
+		}
+	}
+
+	if config.OnSlowQuery != nil {
+		if err = db.Use(slowQueryPlugin{threshold: config.SlowQueryThreshold, fn: config.OnSlowQuery}); err != nil {
+			return openedConnection{conn: DBConn{Instance: db, Error: err}, primary: c}
+		}
+	}
+
+	// The tracing callbacks are installed even with tracing disabled, so UpdateTracing can enable it.
+	tracing := newLiveTracing(config)
+	if err = installTracing(db, config, tracing); err != nil {
+		return openedConnection{conn: DBConn{Instance: db, Error: err}, primary: c}
+	}
+
+	return openedConnection{conn: DBConn{Instance: db}, primary: c, tracing: tracing}
+}
+
 // Ping verifies that the database connection is alive, using the DB from ctx (or the default singleton).
 // It is intended for health checks (e.g. Kubernetes readiness/liveness probes).
 // Returns ErrNoDatabase when no connection is available, or the error from the underlying PingContext.
 func Ping(ctx context.Context) error {
 	db := GetFromContext(ctx)
-	if db == nil {
+	if !hasConnection(db) {
 		return ErrNoDatabase
 	}
 	sqlDB, err := db.DB()
@@ -145,20 +358,139 @@ func Ping(ctx context.Context) error {
 	return sqlDB.PingContext(ctx)
 }
 
+// healthyPingTimeout bounds the ping made by DBConn.Healthy, so a hung connection reads as unhealthy quickly.
+const healthyPingTimeout = 2 * time.Second
+
+// Healthy reports whether c holds an opened connection that currently answers a ping. Unlike Error, which
+// only reflects the initial open, it checks the live pool, so it suits readiness probes and load balancer
+// checks. The ping is bounded by ctx and by a short timeout.
+// Example:
+//
+//	if !dbgo.GetConnection(config).Healthy(r.Context()) {
+//	    w.WriteHeader(http.StatusServiceUnavailable)
+//	}
+func (c *DBConn) Healthy(ctx context.Context) bool {
+	if c == nil || c.Error != nil || !hasConnection(c.Instance) {
+		return false
+	}
+	sqlDB, err := c.Instance.DB()
+	if err != nil || sqlDB == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthyPingTimeout)
+	defer cancel()
+	return sqlDB.PingContext(ctx) == nil
+}
+
 // ResetConnection closes the underlying database connection and resets the singleton,
 // allowing a new connection to be established on the next call to GetConnection.
+// EventReset is only sent when a connection was open.
 func ResetConnection() {
+	if wasOpen, _ := resetConnection(); wasOpen {
+		emitConnEvent(ConnEvent{Type: EventReset})
+	}
+}
+
+// SnapshotConnection captures the singleton connection (with its Config) and the GetConnection function, and
+// returns a function restoring them, for tests that replace the connection or override GetConnection. A
+// connection opened after the snapshot is closed by the restore function; a snapshotted connection closed in the
+// meantime (e.g. by ResetConnection) is not reopened.
+// Example:
+//
+//	restore := dbgo.SnapshotConnection()
+//	t.Cleanup(restore)
+//	dbgo.ResetConnection()
+//	dbgo.GetConnection(dbgo.Config{Dialector: postgres.New(postgres.Config{Conn: mockDB})})
+func SnapshotConnection() func() {
+	connMu.RLock()
+	savedConn, savedConfig, savedPrimary, savedTracing := conn, activeConfig, primaryConn, connTracing
+	connMu.RUnlock()
+	savedGetConnection := GetConnection
+
+	return func() {
+		GetConnection = savedGetConnection
+		connMu.Lock()
+		defer connMu.Unlock()
+		if conn.Instance != savedConn.Instance {
+			_ = closeConnection()
+		}
+		conn, activeConfig, primaryConn, connTracing = savedConn, savedConfig, savedPrimary, savedTracing
+		dbConnOnce = sync.Once{}
+		if savedConn.Instance != nil || savedConn.Error != nil {
+			dbConnOnce.Do(func() {}) // the snapshotted connection was already opened
+		}
+	}
+}
+
+// resetConnection implements ResetConnection and returns whether a connection was open and the error from
+// closing the pool.
+func resetConnection() (wasOpen bool, err error) {
 	connMu.Lock()
 	defer connMu.Unlock()
+	wasOpen = conn.Instance != nil
+	err = closeConnection()
+	conn = DBConn{}
+	activeConfig = Config{}
+	primaryConn = nil
+	connTracing = nil
+	dbConnOnce = sync.Once{}
+	return wasOpen, err
+}
+
+// closeConnection stops the pool watchers and closes the singleton's pool; connMu must be held.
+func closeConnection() (err error) {
+	for _, stop := range stopWatchers {
+		stop()
+	}
+	stopWatchers = nil
+	if stopSaturation != nil {
+		stopSaturation()
+		stopSaturation = nil
+	}
 	if conn.Instance != nil {
 		func() {
 			defer func() { recover() }()
-			if sqlDB, err := conn.Instance.DB(); err == nil && sqlDB != nil {
-				sqlDB.Close()
+			if sqlDB, dbErr := conn.Instance.DB(); dbErr == nil && sqlDB != nil {
+				err = sqlDB.Close()
 			}
 		}()
 	}
-	conn = DBConn{}
-	activeConfig = Config{}
-	dbConnOnce = sync.Once{}
+	return err
+}
+
+// OnShutdown registers fn to be called by Shutdown before the connection pool is closed.
+// Hooks run in LIFO order (the last registered runs first), so teardown mirrors setup order.
+// Use it to flush caches or close LISTEN connections that depend on the database.
+func OnShutdown(fn func(context.Context) error) {
+	if fn == nil {
+		return
+	}
+	shutdownMu.Lock()
+	shutdownHooks = append(shutdownHooks, fn)
+	shutdownMu.Unlock()
+}
+
+// Shutdown runs the hooks registered with OnShutdown in LIFO order, then closes the database connection
+// and resets the singleton like ResetConnection. Every hook runs even if an earlier one fails; the returned
+// error joins the hook errors and the error from closing the pool. Hooks are cleared once they have run.
+func Shutdown(ctx context.Context) error {
+	shutdownMu.Lock()
+	hooks := shutdownHooks
+	shutdownHooks = nil
+	shutdownMu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i](ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	wasOpen, err := resetConnection()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if wasOpen {
+		emitConnEvent(ConnEvent{Type: EventClosed})
+	}
+	return errors.Join(errs...)
 }
@@ -324,3 +324,519 @@ func TestPing_WithDB_Success(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestPublicAPI_NoConnection_ReturnsErrNoDatabase(t *testing.T) {
+	saveAndRestoreConn(t)
+	connMu.Lock()
+	conn = DBConn{}
+	connMu.Unlock()
+
+	contexts := map[string]context.Context{
+		"empty context":         context.Background(),
+		"nil DB in context":     SetFromContext(context.Background(), nil),
+		"zero-value DB context": SetFromContext(context.Background(), &gorm.DB{}),
+	}
+
+	entryPoints := map[string]func(ctx context.Context) error{
+		"Ping": Ping,
+		"WithTransaction": func(ctx context.Context) error {
+			return WithTransaction(ctx, func(context.Context) error { return nil })
+		},
+		"Exec": func(ctx context.Context) error {
+			_, err := Exec(ctx, "SELECT 1")
+			return err
+		},
+		"Raw": func(ctx context.Context) error {
+			var n int
+			return Raw(ctx, &n, "SELECT 1")
+		},
+	}
+
+	for ctxName, ctx := range contexts {
+		for name, call := range entryPoints {
+			t.Run(name+"/"+ctxName, func(t *testing.T) {
+				assert.NotPanics(t, func() {
+					assert.ErrorIs(t, call(ctx), ErrNoDatabase)
+				})
+			})
+		}
+	}
+
+	t.Run("EnableTracing", func(t *testing.T) {
+		for _, db := range []*gorm.DB{nil, {}} {
+			assert.NotPanics(t, func() {
+				_, err := EnableTracing(db, Config{EnableTracing: true})
+				assert.ErrorIs(t, err, ErrNoDatabase)
+			})
+		}
+	})
+
+	t.Run("WithContext", func(t *testing.T) {
+		assert.NotPanics(t, func() {
+			ctx, db := WithContext(context.Background(), nil)
+			assert.NotNil(t, ctx)
+			assert.Nil(t, db)
+		})
+	})
+}
+
+func TestShutdown_RunsHooksInLIFOOrderThenCloses(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	var order []string
+	hookErr := errors.New("flush failed")
+	OnShutdown(func(ctx context.Context) error {
+		order = append(order, "first")
+		return nil
+	})
+	OnShutdown(func(ctx context.Context) error {
+		order = append(order, "second")
+		return hookErr
+	})
+	OnShutdown(func(ctx context.Context) error {
+		// The pool must still be open while hooks run.
+		assert.NotNil(t, GetFromContext(ctx))
+		order = append(order, "third")
+		return nil
+	})
+
+	mock.ExpectClose()
+	err = Shutdown(context.Background())
+
+	assert.ErrorIs(t, err, hookErr)
+	assert.Equal(t, []string{"third", "second", "first"}, order)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Nil(t, GetFromContext(context.Background()))
+
+	// Hooks are cleared after running.
+	assert.NoError(t, Shutdown(context.Background()))
+	assert.Len(t, order, 3)
+}
+
+func TestGormConfig_NowFunc_StampsCreatedAt(t *testing.T) {
+	type stampedUser struct {
+		ID        uint
+		Name      string
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+	frozen := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	noPrepare := false
+
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), gormConfig(Config{
+		PrepareStmt: &noPrepare,
+		NowFunc:     func() time.Time { return frozen },
+	}))
+	assert.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "stamped_users"`).
+		WithArgs("alice", frozen, frozen).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	user := stampedUser{Name: "alice"}
+	assert.NoError(t, db.Create(&user).Error)
+	assert.Equal(t, frozen, user.CreatedAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGormConfig_SkipDefaultTransaction(t *testing.T) {
+	noPrepare := false
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), gormConfig(Config{
+		PrepareStmt:            &noPrepare,
+		SkipDefaultTransaction: true,
+	}))
+	assert.NoError(t, err)
+
+	// No BEGIN/COMMIT around the single write.
+	mock.ExpectExec(`UPDATE "users" SET "active"`).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	type user struct {
+		ID     uint
+		Active bool
+	}
+	assert.NoError(t, db.Model(&user{}).Where("id > ?", 0).Update("active", true).Error)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGormConfig_CreateBatchSize(t *testing.T) {
+	noPrepare := false
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), gormConfig(Config{
+		PrepareStmt:     &noPrepare,
+		CreateBatchSize: 2,
+	}))
+	assert.NoError(t, err)
+
+	type event struct {
+		ID   uint
+		Name string
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "events" \("name"\) VALUES \(\$1\),\(\$2\) RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectQuery(`INSERT INTO "events" \("name"\) VALUES \(\$1\) RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectCommit()
+
+	events := []event{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	assert.NoError(t, db.Create(&events).Error)
+	assert.Equal(t, uint(3), events[2].ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetConnection_Dialectors(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	primaryDB, primary, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { primaryDB.Close() })
+	replicaDB, replica, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { replicaDB.Close() })
+
+	noPrepare := false
+	result := GetConnection(Config{
+		Dialector:         postgres.New(postgres.Config{Conn: primaryDB}),
+		ReplicaDialectors: []gorm.Dialector{postgres.New(postgres.Config{Conn: replicaDB})},
+		PrepareStmt:       &noPrepare,
+	})
+	assert.NoError(t, result.Error)
+
+	primary.ExpectExec(`UPDATE users`).WillReturnResult(sqlmock.NewResult(0, 1))
+	replica.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	assert.NoError(t, result.Instance.Exec("UPDATE users SET active = true").Error)
+	var count int64
+	assert.NoError(t, result.Instance.Raw("SELECT count(*) FROM users").Scan(&count).Error)
+	assert.Equal(t, int64(2), count)
+	assert.NoError(t, primary.ExpectationsWereMet())
+	assert.NoError(t, replica.ExpectationsWereMet())
+}
+
+func TestGetConnection_ReplicaPoolConfigs(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	primaryDB, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { primaryDB.Close() })
+	smallDB, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { smallDB.Close() })
+	largeDB, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { largeDB.Close() })
+
+	noPrepare := false
+	primaryMax, smallMax, largeMax := 50, 5, 40
+	result := GetConnection(Config{
+		Dialector: postgres.New(postgres.Config{Conn: primaryDB}),
+		ReplicaDialectors: []gorm.Dialector{
+			postgres.New(postgres.Config{Conn: smallDB}),
+			postgres.New(postgres.Config{Conn: largeDB}),
+		},
+		MaxOpenConns:       &primaryMax,
+		ReplicaPoolConfigs: []PoolConfig{{MaxOpenConns: &smallMax}, {MaxOpenConns: &largeMax}},
+		PrepareStmt:        &noPrepare,
+	})
+	assert.NoError(t, result.Error)
+
+	assert.Equal(t, 50, primaryDB.Stats().MaxOpenConnections)
+	assert.Equal(t, 5, smallDB.Stats().MaxOpenConnections)
+	assert.Equal(t, 40, largeDB.Stats().MaxOpenConnections)
+}
+
+func TestGetConnection_ReadOnlyReplicas(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	firstDB, first, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { firstDB.Close() })
+	noPrepare := false
+
+	// A single replica is the primary pool itself.
+	result := GetConnection(Config{
+		ReadOnly:          true,
+		ReplicaDialectors: []gorm.Dialector{postgres.New(postgres.Config{Conn: firstDB})},
+		PrepareStmt:       &noPrepare,
+	})
+	assert.NoError(t, result.Error)
+	first.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	var count int64
+	assert.NoError(t, result.Instance.Raw("SELECT count(*) FROM users").Scan(&count).Error)
+	assert.Equal(t, int64(3), count)
+	assert.NoError(t, first.ExpectationsWereMet())
+}
+
+func TestGetConnection_ReadOnlyReplicas_FirstServesTransactions(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	firstDB, first, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { firstDB.Close() })
+	secondDB, second, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { secondDB.Close() })
+
+	noPrepare := false
+	result := GetConnection(Config{
+		ReadOnly: true,
+		ReplicaDialectors: []gorm.Dialector{
+			postgres.New(postgres.Config{Conn: firstDB}),
+			postgres.New(postgres.Config{Conn: secondDB}),
+		},
+		ReplicaWeights: []int{0, 1},
+		PrepareStmt:    &noPrepare,
+	})
+	assert.NoError(t, result.Error)
+
+	second.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	first.ExpectBegin()
+	first.ExpectQuery(`SELECT 1`).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+	first.ExpectCommit()
+
+	var count int64
+	assert.NoError(t, result.Instance.Raw("SELECT count(*) FROM users").Scan(&count).Error)
+	assert.NoError(t, WithTransaction(context.Background(), func(ctx context.Context) error {
+		var n int
+		return GetFromContext(ctx).Raw("SELECT 1").Scan(&n).Error
+	}))
+	assert.NoError(t, first.ExpectationsWereMet())
+	assert.NoError(t, second.ExpectationsWereMet())
+}
+
+func TestUpdatePoolConfig(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	assert.ErrorIs(t, UpdatePoolConfig(10, 5, time.Hour, time.Minute), ErrNoDatabase)
+
+	mockDB, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+	result := GetConnection(Config{Dialector: postgres.New(postgres.Config{Conn: mockDB})})
+	assert.NoError(t, result.Error)
+
+	assert.NoError(t, UpdatePoolConfig(50, 10, time.Hour, time.Minute))
+	assert.Equal(t, 50, mockDB.Stats().MaxOpenConnections)
+	cfg := GetActiveConfig()
+	assert.Equal(t, 50, *cfg.MaxOpenConns)
+	assert.Equal(t, 10, *cfg.MaxIdleConns)
+	assert.Equal(t, time.Hour, *cfg.ConnMaxLifetime)
+	assert.Equal(t, time.Minute, *cfg.ConnMaxIdleTime)
+
+	err = UpdatePoolConfig(5, 10, time.Hour, time.Minute)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Equal(t, 50, mockDB.Stats().MaxOpenConnections, "an invalid update must not be applied")
+	assert.Equal(t, 50, *GetActiveConfig().MaxOpenConns)
+}
+
+func TestUpdatePoolConfig_UpdatesJitteredLifetime(t *testing.T) {
+	saveAndRestoreConn(t)
+	c := &connector{jitter: time.Minute}
+	c.setLifetime(time.Hour)
+	db, _ := newMockDB(t)
+	lifetime := time.Hour
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{ConnMaxLifetime: &lifetime, ConnMaxLifetimeJitter: time.Minute}
+	primaryConn = c
+	connMu.Unlock()
+
+	assert.ErrorIs(t, UpdatePoolConfig(0, 2, 30*time.Second, 0), ErrInvalidConfig, "jitter must stay below the lifetime")
+	assert.NoError(t, UpdatePoolConfig(0, 2, 10*time.Minute, 0))
+	assert.Equal(t, 10*time.Minute, time.Duration(c.lifetime.Load()))
+}
+
+func TestDBConn_Healthy(t *testing.T) {
+	var nilConn *DBConn
+	assert.False(t, nilConn.Healthy(context.Background()))
+	assert.False(t, (&DBConn{}).Healthy(context.Background()))
+
+	mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = mockDB.Close() })
+	mock.ExpectPing() // gorm.Open pings the connection
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	assert.False(t, (&DBConn{Instance: db, Error: errors.New("open failed")}).Healthy(context.Background()))
+
+	mock.ExpectPing()
+	mock.ExpectPing().WillReturnError(errors.New("connection reset"))
+	c := &DBConn{Instance: db}
+	assert.True(t, c.Healthy(context.Background()))
+	assert.False(t, c.Healthy(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIsConnected(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+	assert.False(t, IsConnected())
+
+	db, _ := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db, Error: errors.New("plugin failed")}
+	connMu.Unlock()
+	assert.False(t, IsConnected(), "a failed open is not connected")
+
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+	assert.True(t, IsConnected())
+}
+
+func TestSQLDB(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+	_, err := SQLDB()
+	assert.ErrorIs(t, err, ErrNoDatabase)
+
+	db, _ := newMockDB(t)
+	openErr := errors.New("plugin failed")
+	connMu.Lock()
+	conn = DBConn{Instance: db, Error: openErr}
+	connMu.Unlock()
+	_, err = SQLDB()
+	assert.ErrorIs(t, err, openErr)
+
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+	sqlDB, err := SQLDB()
+	assert.NoError(t, err)
+	want, _ := db.DB()
+	assert.Same(t, want, sqlDB)
+}
+
+func TestResolver(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+	_, err := Resolver()
+	assert.ErrorIs(t, err, ErrNoDatabase)
+
+	db, _ := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+	_, err = Resolver()
+	assert.ErrorIs(t, err, ErrNoResolver)
+
+	ResetConnection()
+	primaryDB, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { primaryDB.Close() })
+	replicaDB, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { replicaDB.Close() })
+	noPrepare := false
+	result := GetConnection(Config{
+		Dialector:         postgres.New(postgres.Config{Conn: primaryDB}),
+		ReplicaDialectors: []gorm.Dialector{postgres.New(postgres.Config{Conn: replicaDB})},
+		PrepareStmt:       &noPrepare,
+	})
+	assert.NoError(t, result.Error)
+	resolver, err := Resolver()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Same(t, result.Instance.Config.Plugins["gorm:db_resolver"], resolver)
+}
+
+func TestEnableTestMode(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+	restore := EnableTestMode()
+	t.Cleanup(restore)
+
+	noPrepare := false
+	open := func() *DBConn {
+		mockDB, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		t.Cleanup(func() { mockDB.Close() })
+		mock.ExpectExec("SET timezone").WillReturnResult(sqlmock.NewResult(0, 0))
+		t.Cleanup(func() { assert.NoError(t, mock.ExpectationsWereMet()) })
+		result := GetConnection(Config{Dialector: postgres.New(postgres.Config{Conn: mockDB}), PrepareStmt: &noPrepare})
+		assert.NoError(t, result.Error)
+		assert.NoError(t, result.Instance.Exec("SET timezone = 'UTC'").Error, "statements run on the connection's own pool")
+		return result
+	}
+
+	first, second := open(), open()
+	assert.NotSame(t, first.Instance, second.Instance)
+	assert.True(t, hasCallbacksPlugin(first.Instance), "dbgo's plugins are installed")
+	assert.False(t, IsConnected(), "the singleton is left alone")
+	assert.Equal(t, Config{}, GetActiveConfig())
+
+	restore()
+	assert.False(t, testMode.Load(), "restore turns test mode off")
+}
+
+func TestSnapshotConnection(t *testing.T) {
+	saveAndRestoreConn(t)
+	t.Cleanup(UseDefaultConnection)
+
+	original, _ := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: original}
+	activeConfig = Config{PrimaryDSN: "original"}
+	dbConnOnce = sync.Once{}
+	dbConnOnce.Do(func() {})
+	connMu.Unlock()
+
+	restore := SnapshotConnection()
+
+	ResetConnection()
+	replacementDB, replacement, err := sqlmock.New()
+	assert.NoError(t, err)
+	replacement.ExpectClose()
+	noPrepare := false
+	result := GetConnection(Config{Dialector: postgres.New(postgres.Config{Conn: replacementDB}), PrepareStmt: &noPrepare})
+	assert.NoError(t, result.Error)
+	GetConnection = func(Config) *DBConn { return &DBConn{Error: errors.New("overridden")} }
+
+	restore()
+
+	assert.NoError(t, replacement.ExpectationsWereMet(), "the connection opened after the snapshot is closed")
+	restored := GetConnection(Config{PrimaryDSN: "other"})
+	assert.NoError(t, restored.Error)
+	assert.Same(t, original, restored.Instance)
+	assert.Equal(t, "original", GetActiveConfig().PrimaryDSN)
+}
+
+func TestSnapshotConnection_NoConnection(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	restore := SnapshotConnection()
+	db, _ := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+	restore()
+
+	assert.False(t, IsConnected())
+}
@@ -0,0 +1,147 @@
+package dbgotest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	dbgo "github.com/adnvilla/db-go"
+	"gorm.io/gorm"
+)
+
+var (
+	countersMu sync.Mutex
+	counters   = map[*int]struct{}{}
+	// installs counts, per connection (identified by its shared *gorm.Config), the tests using the counter
+	// callbacks; the last one to end removes them.
+	installs = map[*gorm.Config]int{}
+)
+
+// CountQueries runs fn and returns the number of SQL statements executed meanwhile on the DB from ctx
+// (or the default connection), so tests can assert that a repository method issues exactly one query and
+// catch N+1 regressions. Statements from concurrent goroutines on the same connection are counted too,
+// so do not use it in parallel tests sharing a DB. Returns 0 when no DB is available.
+// The counting callbacks are installed on the connection on first use and removed when t ends (see t.Cleanup);
+// t fails when they cannot be installed.
+// Example:
+//
+//	n := dbgotest.CountQueries(t, ctx, func() { _, _ = repo.ListOrdersWithItems(ctx) })
+//	assert.Equal(t, 2, n) // orders + preloaded items
+func CountQueries(t testing.TB, ctx context.Context, fn func()) int {
+	t.Helper()
+
+	db := dbgo.GetFromContext(ctx)
+	if db == nil || db.Config == nil {
+		fn()
+		return 0
+	}
+	if err := installCounter(t, db); err != nil {
+		t.Fatalf("dbgotest: installing the query counter: %v", err)
+		return 0
+	}
+
+	n := new(int)
+	countersMu.Lock()
+	counters[n] = struct{}{}
+	countersMu.Unlock()
+	defer func() {
+		countersMu.Lock()
+		delete(counters, n)
+		countersMu.Unlock()
+	}()
+
+	fn()
+
+	countersMu.Lock()
+	defer countersMu.Unlock()
+	return *n
+}
+
+// installCounter installs countPlugin on db unless another test already did, and registers the t.Cleanup
+// removing it once no test uses it anymore.
+func installCounter(t testing.TB, db *gorm.DB) error {
+	countersMu.Lock()
+	defer countersMu.Unlock()
+	if installs[db.Config] == 0 {
+		if err := db.Use(countPlugin{}); err != nil && !errors.Is(err, gorm.ErrRegistered) {
+			return err
+		}
+	}
+	installs[db.Config]++
+	t.Cleanup(func() {
+		countersMu.Lock()
+		defer countersMu.Unlock()
+		if installs[db.Config]--; installs[db.Config] > 0 {
+			return
+		}
+		delete(installs, db.Config)
+		if err := removeCounter(db); err != nil {
+			t.Errorf("dbgotest: removing the query counter: %v", err)
+		}
+	})
+	return nil
+}
+
+// countPlugin registers the callbacks that feed the active CountQueries counters. It is installed on first use
+// and removed by removeCounter when the tests using it end; it only counts while a CountQueries call is running.
+type countPlugin struct{}
+
+// countCallbackName is the name of the callbacks registered by countPlugin.
+const countCallbackName = "dbgotest:count_queries"
+
+func (countPlugin) Name() string {
+	return countCallbackName
+}
+
+func (countPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().After("gorm:create").Register(countCallbackName, countStatement); err != nil {
+		return err
+	}
+	if err := cb.Query().After("gorm:query").Register(countCallbackName, countStatement); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register(countCallbackName, countStatement); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:delete").Register(countCallbackName, countStatement); err != nil {
+		return err
+	}
+	if err := cb.Row().After("gorm:row").Register(countCallbackName, countStatement); err != nil {
+		return err
+	}
+	return cb.Raw().After("gorm:raw").Register(countCallbackName, countStatement)
+}
+
+// removeCounter removes the callbacks registered by countPlugin from db, and the plugin itself so that a later
+// CountQueries installs it again.
+func removeCounter(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Remove(countCallbackName),
+		cb.Query().Remove(countCallbackName),
+		cb.Update().Remove(countCallbackName),
+		cb.Delete().Remove(countCallbackName),
+		cb.Row().Remove(countCallbackName),
+		cb.Raw().Remove(countCallbackName),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	delete(db.Config.Plugins, countPlugin{}.Name())
+	return nil
+}
+
+// countStatement counts statements that were actually built and sent (not dry runs).
+func countStatement(db *gorm.DB) {
+	if db.DryRun || db.Statement.SQL.Len() == 0 {
+		return
+	}
+	countersMu.Lock()
+	for n := range counters {
+		*n++
+	}
+	countersMu.Unlock()
+}
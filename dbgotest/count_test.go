@@ -0,0 +1,89 @@
+package dbgotest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	dbgo "github.com/adnvilla/db-go"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type countedUser struct {
+	ID   uint
+	Name string
+}
+
+func TestCountQueries(t *testing.T) {
+	db, mock := useMockConnection(t)
+	ctx := dbgo.SetFromContext(context.Background(), db)
+
+	mock.ExpectQuery(`SELECT \* FROM "counted_users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "a").AddRow(2, "b"))
+	mock.ExpectExec(`UPDATE counted_users`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	n := CountQueries(t, ctx, func() {
+		var users []countedUser
+		assert.NoError(t, db.Find(&users).Error)
+		assert.NoError(t, db.Exec("UPDATE counted_users SET name = ?", "x").Error)
+		var count int64
+		assert.NoError(t, db.Raw("SELECT count(*) FROM counted_users").Scan(&count).Error)
+	})
+
+	assert.Equal(t, 3, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Zero(t, CountQueries(t, ctx, func() {}), "a second call reuses the installed counter")
+}
+
+func TestCountQueries_DryRunNotCounted(t *testing.T) {
+	db, _ := useMockConnection(t)
+	ctx := dbgo.SetFromContext(context.Background(), db)
+
+	n := CountQueries(t, ctx, func() {
+		var users []countedUser
+		db.Session(&gorm.Session{DryRun: true}).Find(&users)
+	})
+	assert.Zero(t, n)
+}
+
+func TestCountQueries_RemovedWhenTheTestEnds(t *testing.T) {
+	db, mock := useMockConnection(t)
+	ctx := dbgo.SetFromContext(context.Background(), db)
+
+	t.Run("counting", func(t *testing.T) {
+		CountQueries(t, ctx, func() {})
+		assert.NotNil(t, db.Callback().Query().Get(countCallbackName))
+	})
+	assert.Nil(t, db.Callback().Query().Get(countCallbackName), "the callbacks are removed by t.Cleanup")
+	assert.NotContains(t, db.Config.Plugins, countPlugin{}.Name())
+
+	mock.ExpectQuery(`SELECT 1`).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+	n := CountQueries(t, ctx, func() {
+		var one int
+		assert.NoError(t, db.Raw("SELECT 1").Scan(&one).Error)
+	})
+	assert.Equal(t, 1, n, "a later call installs the counter again")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCountQueries_InstallFailureFailsTheTest(t *testing.T) {
+	db, _ := useMockConnection(t)
+	ctx := dbgo.SetFromContext(context.Background(), db)
+	// Registered before gorm:create, it conflicts with the counter's registration after it.
+	assert.NoError(t, db.Callback().Create().Before("gorm:create").Register(countCallbackName, func(*gorm.DB) {}))
+
+	rec := &recordingTB{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		CountQueries(rec, ctx, func() {})
+	}()
+	<-done
+	assert.True(t, rec.fatal)
+	if assert.Len(t, rec.errors, 1) {
+		assert.Contains(t, rec.errors[0], "installing the query counter")
+	}
+}
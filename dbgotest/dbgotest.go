@@ -0,0 +1,31 @@
+// Package dbgotest provides test helpers for code built on github.com/adnvilla/db-go.
+package dbgotest
+
+import (
+	"testing"
+
+	dbgo "github.com/adnvilla/db-go"
+)
+
+// AssertNoOpenTransactions fails t when the dbgo connection still has connections checked out of its pool
+// (sql.DBStats.InUse > 0). A transaction (and any savepoint inside it) pins its connection until it is committed
+// or rolled back, so call it at the end of a test, after every WithTransaction has returned, to catch leaked
+// transactions and unclosed rows before they exhaust the pool in production.
+// The connection is the one returned by dbgo.GetConnection for the active config; t fails if none is available.
+func AssertNoOpenTransactions(t testing.TB) {
+	t.Helper()
+
+	conn := dbgo.GetConnection(dbgo.GetActiveConfig())
+	if conn == nil || conn.Error != nil || conn.Instance == nil {
+		t.Errorf("dbgotest: no database connection available")
+		return
+	}
+	sqlDB, err := conn.Instance.DB()
+	if err != nil {
+		t.Errorf("dbgotest: %v", err)
+		return
+	}
+	if inUse := sqlDB.Stats().InUse; inUse > 0 {
+		t.Errorf("dbgotest: %d connection(s) still in use; a transaction or *sql.Rows was not closed", inUse)
+	}
+}
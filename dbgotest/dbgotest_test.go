@@ -0,0 +1,82 @@
+package dbgotest
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	dbgo "github.com/adnvilla/db-go"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// recordingTB captures failures instead of failing the surrounding test.
+type recordingTB struct {
+	testing.TB
+	errors []string
+	fatal  bool
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+// Fatalf records the failure and stops the calling goroutine, like testing.T's.
+func (r *recordingTB) Fatalf(format string, args ...any) {
+	r.Errorf(format, args...)
+	r.fatal = true
+	runtime.Goexit()
+}
+
+func useMockConnection(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	dbgo.GetConnection = func(dbgo.Config) *dbgo.DBConn {
+		return &dbgo.DBConn{Instance: db}
+	}
+	t.Cleanup(func() {
+		dbgo.UseDefaultConnection()
+		mockDB.Close()
+	})
+	return db, mock
+}
+
+func TestAssertNoOpenTransactions(t *testing.T) {
+	db, mock := useMockConnection(t)
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	rec := &recordingTB{TB: t}
+	AssertNoOpenTransactions(rec)
+	assert.Empty(t, rec.errors)
+
+	tx := db.Begin()
+	assert.NoError(t, tx.Error)
+	AssertNoOpenTransactions(rec)
+	assert.Len(t, rec.errors, 1)
+	assert.Contains(t, rec.errors[0], "1 connection(s) still in use")
+
+	assert.NoError(t, tx.Rollback().Error)
+	rec.errors = nil
+	AssertNoOpenTransactions(rec)
+	assert.Empty(t, rec.errors)
+}
+
+func TestAssertNoOpenTransactions_NoConnection(t *testing.T) {
+	dbgo.GetConnection = func(dbgo.Config) *dbgo.DBConn {
+		return &dbgo.DBConn{Error: dbgo.ErrNoDatabase}
+	}
+	t.Cleanup(dbgo.UseDefaultConnection)
+
+	rec := &recordingTB{TB: t}
+	AssertNoOpenTransactions(rec)
+	assert.Equal(t, []string{"dbgotest: no database connection available"}, rec.errors)
+}
@@ -0,0 +1,97 @@
+package dbgotest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dbgo "github.com/adnvilla/db-go"
+	"github.com/jackc/pgx/v5"
+)
+
+// PostgresImage is the image StartPostgres runs. Override it (e.g. in TestMain) to match the server version
+// used in production.
+var PostgresImage = "postgres:15"
+
+// postgresStartTimeout bounds how long StartPostgres waits for the server to accept connections.
+const postgresStartTimeout = time.Minute
+
+// StartPostgres starts a disposable PostgreSQL container (PostgresImage, with the docker CLI) and returns a
+// Config whose PrimaryDSN points at its empty "test" database, plus a function removing the container. The
+// container is also removed when t finishes, so calling the function is only needed to stop it earlier.
+// The test is skipped under -short and when docker is not installed, and fails when the container does not
+// start or accept connections within a minute. Each call starts its own container, so share one per package
+// (e.g. from TestMain) when startup time matters.
+// Example:
+//
+//	cfg, _ := dbgotest.StartPostgres(t)
+//	conn := dbgo.GetConnection(cfg)
+//	t.Cleanup(dbgo.ResetConnection)
+func StartPostgres(t testing.TB) (dbgo.Config, func()) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("dbgotest: skipping PostgreSQL container in -short mode")
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skipf("dbgotest: docker is required to start PostgreSQL: %v", err)
+	}
+
+	out, err := exec.Command("docker", "run", "-d", "--rm",
+		"-e", "POSTGRES_USER=postgres",
+		"-e", "POSTGRES_PASSWORD=postgres",
+		"-e", "POSTGRES_DB=test",
+		"-p", "127.0.0.1::5432",
+		PostgresImage,
+	).CombinedOutput()
+	if err != nil {
+		t.Fatalf("dbgotest: starting %s: %v: %s", PostgresImage, err, out)
+	}
+	id := strings.TrimSpace(string(out))
+	var once sync.Once
+	stop := func() {
+		once.Do(func() { _ = exec.Command("docker", "rm", "-f", id).Run() })
+	}
+	t.Cleanup(stop)
+
+	out, err = exec.Command("docker", "port", id, "5432/tcp").Output()
+	if err != nil {
+		t.Fatalf("dbgotest: reading the PostgreSQL port: %v", err)
+	}
+	// One line per published address, e.g. "127.0.0.1:55012".
+	_, port, err := net.SplitHostPort(strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0]))
+	if err != nil {
+		t.Fatalf("dbgotest: parsing the PostgreSQL port %q: %v", out, err)
+	}
+	dsn := fmt.Sprintf("host=127.0.0.1 port=%s user=postgres password=postgres dbname=test sslmode=disable", port)
+	if err := waitForPostgres(dsn, postgresStartTimeout); err != nil {
+		t.Fatalf("dbgotest: PostgreSQL did not become ready: %v", err)
+	}
+	return dbgo.Config{PrimaryDSN: dsn}, stop
+}
+
+// waitForPostgres polls dsn until the server answers a ping. The image's entrypoint only listens on TCP once
+// initialization is complete, so the first successful ping means the final server is up.
+func waitForPostgres(dsn string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for {
+		conn, err := pgx.Connect(ctx, dsn)
+		if err == nil {
+			err = conn.Ping(ctx)
+			_ = conn.Close(ctx)
+			if err == nil {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
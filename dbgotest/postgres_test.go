@@ -0,0 +1,20 @@
+package dbgotest
+
+import (
+	"context"
+	"testing"
+
+	dbgo "github.com/adnvilla/db-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartPostgres(t *testing.T) {
+	cfg, stop := StartPostgres(t)
+	defer stop()
+
+	conn := dbgo.GetConnection(cfg)
+	t.Cleanup(dbgo.ResetConnection)
+	if assert.NoError(t, conn.Error) {
+		assert.NoError(t, dbgo.Ping(context.Background()))
+	}
+}
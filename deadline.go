@@ -0,0 +1,126 @@
+package dbgo
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type queryDeadlineKey struct{}
+
+// queryDeadlineState is the statement setting holding the deadline of the running statement (a
+// queryDeadlineScope).
+const queryDeadlineState = "dbgo:query_deadline"
+
+// queryDeadlineScope is the deadline startQueryDeadline gave a statement: the statement context it replaced
+// (restored once the statement has run, so the next statement of a reused chain starts from it) and the
+// deadline's CancelFunc.
+type queryDeadlineScope struct {
+	parent context.Context
+	cancel context.CancelFunc
+}
+
+// WithQueryDeadline returns a copy of ctx under which each statement (run through the context DB, see
+// GetFromContext) must finish within d: dbgo's callbacks give every statement its own context deadline d after
+// it starts, instead of one deadline shared by all the statements of a request. A nested call replaces the outer
+// budget, so a middleware can keep queries tight while a report handler grants its query more time. A statement
+// still cannot outlive a deadline ctx already carries (e.g. from RequestContext with DefaultQueryTimeout).
+// Deadlines are applied by dbgo's GORM callbacks, so they only apply to connections from GetConnection.
+// Example:
+//
+//	ctx = dbgo.WithQueryDeadline(ctx, 2*time.Second) // middleware
+//	...
+//	ctx = dbgo.WithQueryDeadline(ctx, 30*time.Second) // quarterly report handler
+//	err := dbgo.Raw(ctx, &rows, reportSQL)
+func WithQueryDeadline(ctx context.Context, d time.Duration) context.Context {
+	ctx = context.WithValue(ctx, queryDeadlineKey{}, d)
+	// The context DB is bound to the context it was stored with; rebind it so its statements see the deadline.
+	if db, ok := contextDB(ctx); ok && hasConnection(db) {
+		ctx = SetFromContext(ctx, db.WithContext(ctx))
+	}
+	return ctx
+}
+
+func queryDeadline(ctx context.Context) (time.Duration, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	d, ok := ctx.Value(queryDeadlineKey{}).(time.Duration)
+	return d, ok && d > 0
+}
+
+// registerQueryDeadlines registers the callbacks applying WithQueryDeadline: the deadline is set before the
+// statement runs and released once its errors have been mapped to the context's (see registerContextErrors).
+func registerQueryDeadlines(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("dbgo:query_deadline", startQueryDeadline); err != nil {
+		return err
+	}
+	if err := cb.Create().After("dbgo:context_error").Register("dbgo:query_deadline_end", endQueryDeadline); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("dbgo:query_deadline", startQueryDeadline); err != nil {
+		return err
+	}
+	if err := cb.Query().After("dbgo:context_error").Register("dbgo:query_deadline_end", endQueryDeadline); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("dbgo:query_deadline", startQueryDeadline); err != nil {
+		return err
+	}
+	if err := cb.Update().After("dbgo:context_error").Register("dbgo:query_deadline_end", endQueryDeadline); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("dbgo:query_deadline", startQueryDeadline); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("dbgo:context_error").Register("dbgo:query_deadline_end", endQueryDeadline); err != nil {
+		return err
+	}
+	if err := cb.Raw().Before("gorm:raw").Register("dbgo:query_deadline", startQueryDeadline); err != nil {
+		return err
+	}
+	if err := cb.Raw().After("dbgo:context_error").Register("dbgo:query_deadline_end", endQueryDeadline); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("dbgo:query_deadline", startQueryDeadline); err != nil {
+		return err
+	}
+	// Row and Rows are read after the callbacks return, so their deadline is left to expire on its own.
+	return cb.Row().After("gorm:row").Register("dbgo:query_deadline_end", restoreQueryDeadline)
+}
+
+func startQueryDeadline(db *gorm.DB) {
+	d, ok := queryDeadline(db.Statement.Context)
+	if !ok {
+		return
+	}
+	parent := db.Statement.Context
+	ctx, cancel := context.WithTimeout(parent, d)
+	db.Statement.Context = ctx
+	db.Statement.Settings.Store(queryDeadlineState, queryDeadlineScope{parent: parent, cancel: cancel})
+}
+
+// endQueryDeadline releases the statement's deadline and restores the context it replaced.
+func endQueryDeadline(db *gorm.DB) {
+	if scope, ok := restoreDeadlineContext(db); ok {
+		scope.cancel()
+	}
+}
+
+// restoreQueryDeadline restores the context the statement's deadline replaced, leaving the deadline running for
+// the rows still to be read.
+func restoreQueryDeadline(db *gorm.DB) {
+	restoreDeadlineContext(db)
+}
+
+func restoreDeadlineContext(db *gorm.DB) (queryDeadlineScope, bool) {
+	v, ok := db.Statement.Settings.LoadAndDelete(queryDeadlineState)
+	if !ok {
+		return queryDeadlineScope{}, false
+	}
+	scope := v.(queryDeadlineScope)
+	db.Statement.Context = scope.parent
+	return scope, true
+}
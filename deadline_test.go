@@ -0,0 +1,75 @@
+package dbgo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+)
+
+func TestWithQueryDeadline(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	noPrepare := false
+	result := GetConnection(Config{Dialector: postgres.New(postgres.Config{Conn: mockDB}), PrepareStmt: &noPrepare})
+	require.NoError(t, result.Error)
+
+	mock.ExpectQuery(`SELECT count`).WillDelayFor(time.Second).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT count`).WillDelayFor(100 * time.Millisecond).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	ctx, cancel := RequestContext(context.Background())
+	defer cancel()
+	ctx = WithQueryDeadline(ctx, 50*time.Millisecond)
+
+	var count int64
+	err = GetFromContext(ctx).Raw("SELECT count(*) FROM users").Scan(&count).Error
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// A longer budget for a slow report; every statement gets its own deadline.
+	ctx = WithQueryDeadline(ctx, time.Second)
+	assert.NoError(t, GetFromContext(ctx).Raw("SELECT count(*) FROM reports").Scan(&count).Error)
+	assert.Equal(t, int64(2), count)
+	assert.NoError(t, ctx.Err(), "the request context is not cancelled by statement deadlines")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+type deadlineUser struct {
+	ID   uint
+	Name string
+}
+
+func TestWithQueryDeadline_ReusedChain(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	noPrepare := false
+	result := GetConnection(Config{Dialector: postgres.New(postgres.Config{Conn: mockDB}), PrepareStmt: &noPrepare})
+	require.NoError(t, result.Error)
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "deadline_users"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT \* FROM "deadline_users"`).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "ana"))
+
+	ctx := WithQueryDeadline(context.Background(), time.Second)
+	// The pagination pattern: the same chain runs Count, then Find.
+	q := result.Instance.WithContext(ctx).Model(&deadlineUser{}).Where("name <> ?", "")
+	var n int64
+	require.NoError(t, q.Count(&n).Error)
+	var users []deadlineUser
+	require.NoError(t, q.Find(&users).Error, "the second statement does not inherit the first one's cancelled deadline")
+	assert.Equal(t, int64(1), n)
+	assert.Len(t, users, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
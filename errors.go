@@ -0,0 +1,171 @@
+package dbgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrorKind classifies the errors dbgo itself returns (see Error), e.g. to map them to HTTP status codes.
+type ErrorKind int
+
+const (
+	// KindConnection: no usable database connection for the operation.
+	KindConnection ErrorKind = iota + 1
+	// KindConfig: an invalid Config or argument, i.e. a programming error rather than a runtime condition.
+	KindConfig
+	// KindTransaction: a transaction could not run or complete.
+	KindTransaction
+	// KindQuery: a statement was rejected by dbgo before reaching the database.
+	KindQuery
+)
+
+// Error is the type of dbgo's sentinel errors. Each sentinel belongs to an ErrorKind and matches, with
+// errors.Is, both itself and the category sentinel of its kind (ErrConnection, ErrConfig, ErrTransaction,
+// ErrQuery). dbgo wraps sentinels with fmt.Errorf's %w, together with the underlying cause when there is one
+// (such as the driver error of ErrReadOnlyConnection), so errors.Is and errors.As reach both. Errors reported
+// by the database or the driver (e.g. *pgconn.PgError) and gorm errors are returned without a dbgo sentinel,
+// except where documented.
+// Example:
+//
+//	var dbErr *dbgo.Error
+//	switch {
+//	case errors.Is(err, dbgo.ErrConnection):
+//	    return http.StatusServiceUnavailable
+//	case errors.As(err, &dbErr) && dbErr.Kind == dbgo.KindQuery:
+//	    return http.StatusBadRequest
+//	}
+type Error struct {
+	Kind ErrorKind
+	msg  string
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+// Is reports whether target is the category sentinel of e's kind.
+func (e *Error) Is(target error) bool {
+	return target == kindErrors[e.Kind]
+}
+
+func newError(kind ErrorKind, msg string) error {
+	return &Error{Kind: kind, msg: msg}
+}
+
+// Category sentinels, matched with errors.Is by every sentinel of their kind.
+var (
+	// ErrConnection is matched by the connection errors: ErrNoDatabase, ErrNoResolver and ErrReadOnlyConnection.
+	ErrConnection = newError(KindConnection, "dbgo: connection error")
+	// ErrConfig is matched by the configuration and argument errors: ErrInvalidConfig, ErrInvalidArgument and
+	// ErrNilUnitOfWork.
+	ErrConfig = newError(KindConfig, "dbgo: configuration error")
+	// ErrTransaction is matched by the transaction errors: ErrTransactionTimeout and ErrMaxNestingExceeded.
+	ErrTransaction = newError(KindTransaction, "dbgo: transaction error")
+	// ErrQuery is matched by the statement errors: ErrTooManyParameters.
+	ErrQuery = newError(KindQuery, "dbgo: query error")
+
+	kindErrors = map[ErrorKind]error{
+		KindConnection:  ErrConnection,
+		KindConfig:      ErrConfig,
+		KindTransaction: ErrTransaction,
+		KindQuery:       ErrQuery,
+	}
+)
+
+// Connection errors (KindConnection).
+var (
+	// ErrNoDatabase is returned when no database connection is available.
+	ErrNoDatabase = newError(KindConnection, "dbgo: no database connection available")
+
+	// ErrNoResolver is returned by Resolver when the connection was opened without a dbresolver (no replicas, or
+	// a ReadOnly connection to a single replica).
+	ErrNoResolver = newError(KindConnection, "dbgo: connection has no dbresolver")
+
+	// ErrReadOnlyConnection is returned by WithTransaction when PostgreSQL rejects a write because the
+	// connection is read-only (SQLSTATE 25006), which usually means the DSN points at a replica or a standby.
+	// The driver error is wrapped alongside it.
+	ErrReadOnlyConnection = newError(KindConnection, "dbgo: connection is read-only")
+)
+
+// Configuration errors (KindConfig).
+var (
+	// ErrInvalidConfig is returned when Config fails validation (e.g. empty PrimaryDSN).
+	// Validate wraps it with a description of the specific problem; check it with errors.Is.
+	ErrInvalidConfig = newError(KindConfig, "dbgo: invalid config")
+
+	// ErrInvalidArgument is wrapped by the errors of functions called with invalid arguments (e.g. an empty
+	// WithRole role or ExecIdempotent key), with a description of the problem.
+	ErrInvalidArgument = newError(KindConfig, "dbgo: invalid argument")
+
+	// ErrNilUnitOfWork is returned by WithTransaction and its variants when fn is nil, before a transaction is
+	// begun.
+	ErrNilUnitOfWork = newError(KindConfig, "dbgo: nil UnitOfWork passed to WithTransaction")
+)
+
+// Transaction errors (KindTransaction).
+var (
+	// ErrTransactionTimeout is returned by WithTransaction when the transaction ran longer than
+	// Config.MaxTransactionDuration and was rolled back.
+	ErrTransactionTimeout = newError(KindTransaction, "dbgo: transaction exceeded MaxTransactionDuration")
+
+	// ErrMaxNestingExceeded is returned by a nested WithTransaction call that would exceed
+	// Config.MaxTransactionNesting; fn is not called.
+	ErrMaxNestingExceeded = newError(KindTransaction, "dbgo: transaction nesting exceeds MaxTransactionNesting")
+
+	// ErrConcurrentTransactionUse is returned, with Config.Debug, by a statement started through the DB of a
+	// WithTransaction or MaybeTransaction call while another statement of the same transaction was running (or
+	// had rows left open), e.g. from a goroutine started in fn. The statement is not sent: a transaction has a single connection, which concurrent
+	// statements corrupt.
+	ErrConcurrentTransactionUse = newError(KindTransaction, "dbgo: transaction used concurrently")
+)
+
+// Statement errors (KindQuery).
+var (
+	// ErrTooManyParameters is returned for a statement with more bind parameters than Config.MaxQueryParams,
+	// before it is sent to the database. PostgreSQL rejects statements with more than 65535 parameters; large IN
+	// lists and bulk inserts are the usual culprits.
+	ErrTooManyParameters = newError(KindQuery, "dbgo: too many query parameters")
+)
+
+// wrapError adds the operation name and elapsed time to err when cfg.WrapErrors is set. When db is the
+// connection the statement ran on, it also records whether that was a transaction. The original error stays
+// in the chain for errors.Is/errors.As.
+func wrapError(cfg Config, op string, start time.Time, db *gorm.DB, err error) error {
+	if err == nil || !cfg.WrapErrors {
+		return err
+	}
+	elapsed := time.Since(start).Round(time.Microsecond)
+	switch {
+	case db == nil:
+		return fmt.Errorf("dbgo: %s failed after %s: %w", op, elapsed, err)
+	case isTransaction(db):
+		return fmt.Errorf("dbgo: %s failed after %s (in a transaction): %w", op, elapsed, err)
+	default:
+		return fmt.Errorf("dbgo: %s failed after %s (outside a transaction): %w", op, elapsed, err)
+	}
+}
+
+// contextError wraps err with ctx.Err() when ctx is done, so a statement that failed because its context was
+// cancelled or timed out matches context.Canceled or context.DeadlineExceeded with errors.Is, whatever the
+// driver reported (e.g. "canceling statement due to user request" or a broken connection).
+func contextError(ctx context.Context, err error) error {
+	if err == nil || ctx == nil {
+		return err
+	}
+	ctxErr := ctx.Err()
+	if ctxErr == nil || errors.Is(err, ctxErr) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ctxErr, err)
+}
+
+// mapContextError is a GORM callback applying contextError to the statement's error.
+func mapContextError(db *gorm.DB) {
+	if db.Error != nil {
+		db.Error = contextError(db.Statement.Context, db.Error)
+	}
+}
@@ -0,0 +1,134 @@
+package dbgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapError(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	assert.Same(t, errBoom, wrapError(Config{}, "Exec", time.Now(), nil, errBoom), "disabled by default")
+	assert.NoError(t, wrapError(Config{WrapErrors: true}, "Exec", time.Now(), nil, nil))
+
+	err := wrapError(Config{WrapErrors: true}, "WithTransaction", time.Now(), nil, errBoom)
+	assert.ErrorIs(t, err, errBoom)
+	assert.Regexp(t, `^dbgo: WithTransaction failed after \S+: boom$`, err.Error())
+
+	db, _ := newMockDB(t)
+	err = wrapError(Config{WrapErrors: true}, "Exec", time.Now(), db, errBoom)
+	assert.Regexp(t, `^dbgo: Exec failed after \S+ \(outside a transaction\): boom$`, err.Error())
+}
+
+func TestWrapErrors_ExecInsideTransaction(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{WrapErrors: true}
+	connMu.Unlock()
+
+	errDup := errors.New(`ERROR: duplicate key value violates unique constraint "users_pkey"`)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO users`).WillReturnError(errDup)
+	mock.ExpectRollback()
+
+	var execErr error
+	err := WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, execErr = Exec(ctx, "INSERT INTO users (id) VALUES (1)")
+		return execErr
+	})
+
+	assert.Regexp(t, `^dbgo: Exec failed after \S+ \(in a transaction\): ERROR: duplicate key`, execErr.Error())
+	assert.Regexp(t, `^dbgo: WithTransaction failed after \S+: dbgo: Exec failed`, err.Error())
+	assert.ErrorIs(t, err, errDup)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestContextError(t *testing.T) {
+	errDriver := errors.New("ERROR: canceling statement due to user request (SQLSTATE 57014)")
+	assert.Same(t, errDriver, contextError(context.Background(), errDriver), "live context: unchanged")
+	assert.NoError(t, contextError(context.Background(), nil))
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := contextError(cancelled, errDriver)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, err, errDriver)
+	assert.NotErrorIs(t, err, context.DeadlineExceeded)
+	assert.Same(t, err, contextError(cancelled, err), "already matching: not wrapped twice")
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	err = contextError(expired, errDriver)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, err, context.Canceled)
+}
+
+func TestCallbacksPlugin_MapsCancelledStatementErrors(t *testing.T) {
+	db, mock := newMockDB(t)
+	assert.NoError(t, db.Use(callbacksPlugin{}))
+
+	// sqlmock reports the cancellation with its own error ("canceling query due to user request"),
+	// like a server-side cancel that does not mention the context.
+	mock.ExpectExec("UPDATE users").WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 1))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := db.WithContext(ctx).Exec("UPDATE users SET active = true").Error
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, err, sqlmock.ErrCancelled)
+}
+
+func TestErrorTaxonomy(t *testing.T) {
+	for _, tt := range []struct {
+		err      error
+		kind     ErrorKind
+		category error
+	}{
+		{ErrNoDatabase, KindConnection, ErrConnection},
+		{ErrNoResolver, KindConnection, ErrConnection},
+		{ErrReadOnlyConnection, KindConnection, ErrConnection},
+		{ErrInvalidConfig, KindConfig, ErrConfig},
+		{ErrInvalidArgument, KindConfig, ErrConfig},
+		{ErrNilUnitOfWork, KindConfig, ErrConfig},
+		{ErrTransactionTimeout, KindTransaction, ErrTransaction},
+		{ErrMaxNestingExceeded, KindTransaction, ErrTransaction},
+		{ErrConcurrentTransactionUse, KindTransaction, ErrTransaction},
+		{ErrTooManyParameters, KindQuery, ErrQuery},
+	} {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			wrapped := wrapError(Config{WrapErrors: true}, "Exec", time.Now(), nil, tt.err)
+			assert.ErrorIs(t, wrapped, tt.err)
+			assert.ErrorIs(t, wrapped, tt.category)
+			var dbErr *Error
+			if assert.ErrorAs(t, wrapped, &dbErr) {
+				assert.Equal(t, tt.kind, dbErr.Kind)
+			}
+			for _, other := range []error{ErrConnection, ErrConfig, ErrTransaction, ErrQuery} {
+				if other != tt.category {
+					assert.NotErrorIs(t, tt.err, other)
+				}
+			}
+		})
+	}
+	assert.NotErrorIs(t, ErrNoDatabase, ErrNoResolver, "sentinels of a kind stay distinct")
+}
+
+func TestErrorTaxonomy_KeepsCause(t *testing.T) {
+	errDriver := errors.New("read-only transaction")
+	err := fmt.Errorf("%w: %w", ErrReadOnlyConnection, errDriver)
+	assert.ErrorIs(t, err, ErrConnection)
+	assert.ErrorIs(t, err, errDriver)
+
+	err = ExecIdempotent(context.Background(), "", func(context.Context) error { return nil })
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	assert.ErrorIs(t, err, ErrConfig)
+}
@@ -0,0 +1,152 @@
+package dbgo
+
+import (
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnEventType identifies a connection lifecycle event (see ConnectionEvents).
+type ConnEventType int
+
+const (
+	// EventConnected is sent when GetConnection has opened the connection.
+	EventConnected ConnEventType = iota + 1
+	// EventReplicaFailed is sent when the replicas could not be opened; ConnEvent.Err holds the error.
+	EventReplicaFailed
+	// EventReset is sent by ResetConnection, after the pool is closed; not when no connection was open.
+	EventReset
+	// EventClosed is sent by Shutdown, after the hooks have run and the pool is closed; not when no connection
+	// was open.
+	EventClosed
+	// EventPoolSaturated is sent when statements had to wait for a free connection of the primary pool since the
+	// previous check; ConnEvent.Stats holds the pool statistics.
+	EventPoolSaturated
+	// EventPrimaryChanged is sent when a probe found a different server behind the primary DSN (see
+	// Config.OnPrimaryChanged); ConnEvent.Endpoint holds the new one.
+	EventPrimaryChanged
+)
+
+func (t ConnEventType) String() string {
+	switch t {
+	case EventConnected:
+		return "connected"
+	case EventReplicaFailed:
+		return "replica_failed"
+	case EventReset:
+		return "reset"
+	case EventClosed:
+		return "closed"
+	case EventPoolSaturated:
+		return "pool_saturated"
+	case EventPrimaryChanged:
+		return "primary_changed"
+	default:
+		return "unknown"
+	}
+}
+
+// ConnEvent is a connection lifecycle event.
+type ConnEvent struct {
+	Type     ConnEventType
+	Time     time.Time
+	Err      error       // EventReplicaFailed
+	Stats    sql.DBStats // EventPoolSaturated
+	Endpoint string      // EventPrimaryChanged
+}
+
+// connEventsBuffer is the number of events kept for a slow consumer before new ones are dropped.
+const connEventsBuffer = 64
+
+// poolSaturationInterval is how often the primary pool is checked for statements waiting on a connection.
+const poolSaturationInterval = time.Second
+
+var (
+	connEventsOnce sync.Once
+	connEvents     chan ConnEvent
+	// connEventsSubscribed is set by the first ConnectionEvents call: until then no events are kept, so a late
+	// subscriber does not receive stale ones, and the pool saturation watcher does not run.
+	connEventsSubscribed atomic.Bool
+)
+
+// ConnectionEvents returns the channel receiving the connection lifecycle events (connected, replica failure,
+// reset, shutdown, pool saturation, primary change), for observability or orchestration code that reacts to
+// them instead of scraping logs. Every call returns the same channel, so events are split between concurrent receivers. Sending
+// never blocks: while the channel's buffer is full, new events are dropped. Events are only sent from the first
+// call on (earlier ones are not kept), which also starts the pool saturation checks.
+// Example:
+//
+//	go func() {
+//	    for ev := range dbgo.ConnectionEvents() {
+//	        if ev.Type == dbgo.EventPoolSaturated {
+//	            poolWaits.Add(float64(ev.Stats.WaitCount))
+//	        }
+//	    }
+//	}()
+func ConnectionEvents() <-chan ConnEvent {
+	if connEventsSubscribed.CompareAndSwap(false, true) {
+		connMu.Lock()
+		watchSaturation()
+		connMu.Unlock()
+	}
+	return eventsChannel()
+}
+
+func eventsChannel() chan ConnEvent {
+	connEventsOnce.Do(func() { connEvents = make(chan ConnEvent, connEventsBuffer) })
+	return connEvents
+}
+
+// emitConnEvent sends ev without blocking, dropping it when the buffer is full or nobody called
+// ConnectionEvents yet.
+func emitConnEvent(ev ConnEvent) {
+	if !connEventsSubscribed.Load() {
+		return
+	}
+	ev.Time = time.Now()
+	select {
+	case eventsChannel() <- ev:
+	default:
+	}
+}
+
+// watchSaturation starts the pool saturation watcher of the singleton connection, if it is open, ConnectionEvents
+// has a subscriber and the watcher is not running yet; connMu must be held.
+func watchSaturation() {
+	if stopSaturation != nil || !connEventsSubscribed.Load() || conn.Error != nil || !hasConnection(conn.Instance) {
+		return
+	}
+	if sqlDB, err := conn.Instance.DB(); err == nil && sqlDB != nil {
+		stopSaturation = watchPoolSaturation(sqlDB, poolSaturationInterval)
+	}
+}
+
+// watchPoolSaturation emits EventPoolSaturated every interval in which statements waited for a connection of
+// sqlDB, until the returned function is called; it returns once the goroutine has stopped.
+func watchPoolSaturation(sqlDB *sql.DB, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	waits := sqlDB.Stats().WaitCount
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				stats := sqlDB.Stats()
+				if stats.WaitCount > waits {
+					emitConnEvent(ConnEvent{Type: EventPoolSaturated, Stats: stats})
+				}
+				waits = stats.WaitCount
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
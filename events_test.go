@@ -0,0 +1,188 @@
+package dbgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// drainConnEvents discards the buffered events and returns their types.
+func drainConnEvents() []ConnEventType {
+	var types []ConnEventType
+	for {
+		select {
+		case ev := <-ConnectionEvents():
+			types = append(types, ev.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func TestConnectionEvents_ConnectAndReset(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+	drainConnEvents()
+
+	mockDB, _, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	noPrepare := false
+	result := GetConnection(Config{Dialector: postgres.New(postgres.Config{Conn: mockDB}), PrepareStmt: &noPrepare})
+	require.NoError(t, result.Error)
+	ResetConnection()
+
+	assert.Equal(t, []ConnEventType{EventConnected, EventReset}, drainConnEvents())
+}
+
+func TestConnectionEvents_NoResetWithoutConnection(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+	drainConnEvents()
+
+	ResetConnection()
+	assert.Empty(t, drainConnEvents())
+}
+
+func TestConnectionEvents_OnlyFromTheFirstSubscription(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+	drainConnEvents()
+	connEventsSubscribed.Store(false)
+	t.Cleanup(func() { drainConnEvents() })
+
+	mockDB, _, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+	noPrepare := false
+	require.NoError(t, GetConnection(Config{Dialector: postgres.New(postgres.Config{Conn: mockDB}), PrepareStmt: &noPrepare}).Error)
+	connMu.RLock()
+	watching := stopSaturation != nil
+	connMu.RUnlock()
+	assert.False(t, watching, "no saturation watcher without a subscriber")
+
+	assert.Empty(t, drainConnEvents(), "a late subscriber gets no stale events")
+	connMu.RLock()
+	watching = stopSaturation != nil
+	connMu.RUnlock()
+	assert.True(t, watching, "the first subscription starts the saturation watcher")
+
+	ResetConnection()
+	assert.Equal(t, []ConnEventType{EventReset}, drainConnEvents())
+	connMu.RLock()
+	watching = stopSaturation != nil
+	connMu.RUnlock()
+	assert.False(t, watching, "the watcher stops with the connection")
+}
+
+func TestConnectionEvents_ReplicaFailed(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+	drainConnEvents()
+
+	primaryDB, _, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { primaryDB.Close() })
+	replicaDB, replica, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	t.Cleanup(func() { replicaDB.Close() })
+	errUnreachable := errors.New("replica unreachable")
+	replica.ExpectPing().WillReturnError(errUnreachable)
+
+	noPrepare := false
+	result := GetConnection(Config{
+		Dialector:         postgres.New(postgres.Config{Conn: primaryDB}),
+		ReplicaDialectors: []gorm.Dialector{postgres.New(postgres.Config{Conn: replicaDB})},
+		PrepareStmt:       &noPrepare,
+	})
+	require.ErrorIs(t, result.Error, errUnreachable)
+
+	select {
+	case ev := <-ConnectionEvents():
+		assert.Equal(t, EventReplicaFailed, ev.Type)
+		assert.ErrorIs(t, ev.Err, errUnreachable)
+		assert.False(t, ev.Time.IsZero())
+	default:
+		t.Fatal("no event sent")
+	}
+}
+
+func TestConnectionEvents_Shutdown(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+	drainConnEvents()
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+	mock.ExpectClose()
+	noPrepare := false
+	require.NoError(t, GetConnection(Config{Dialector: postgres.New(postgres.Config{Conn: mockDB}), PrepareStmt: &noPrepare}).Error)
+
+	assert.NoError(t, Shutdown(context.Background()))
+	assert.Equal(t, []ConnEventType{EventConnected, EventClosed}, drainConnEvents())
+}
+
+func TestConnectionEvents_NoClosedWithoutConnection(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+	drainConnEvents()
+
+	assert.NoError(t, Shutdown(context.Background()))
+	assert.Empty(t, drainConnEvents())
+}
+
+func TestConnectionEvents_DroppedWhenFull(t *testing.T) {
+	drainConnEvents()
+	t.Cleanup(func() { drainConnEvents() })
+
+	for i := 0; i < connEventsBuffer+10; i++ {
+		emitConnEvent(ConnEvent{Type: EventReset})
+	}
+	assert.Len(t, drainConnEvents(), connEventsBuffer)
+}
+
+func TestWatchPoolSaturation(t *testing.T) {
+	drainConnEvents()
+	t.Cleanup(func() { drainConnEvents() })
+
+	mockDB, _, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+	mockDB.SetMaxOpenConns(1)
+
+	held, err := mockDB.Conn(context.Background())
+	require.NoError(t, err)
+	stop := watchPoolSaturation(mockDB, 10*time.Millisecond)
+	defer stop()
+
+	// A second checkout waits for the held connection until its context expires.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = mockDB.Conn(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NoError(t, held.Close())
+
+	select {
+	case ev := <-ConnectionEvents():
+		assert.Equal(t, EventPoolSaturated, ev.Type)
+		assert.Equal(t, int64(1), ev.Stats.WaitCount)
+	case <-time.After(time.Second):
+		t.Fatal("no saturation event sent")
+	}
+}
+
+func TestConnEventType_String(t *testing.T) {
+	assert.Equal(t, "connected", EventConnected.String())
+	assert.Equal(t, "pool_saturated", EventPoolSaturated.String())
+	assert.Equal(t, "primary_changed", EventPrimaryChanged.String())
+	assert.Equal(t, "unknown", ConnEventType(0).String())
+}
@@ -109,6 +109,14 @@ func main() {
 	}
 
 	fmt.Printf("Retrieved user: %v\n", retrievedUser)
+
+	// Rename the user in a transaction with its own span, tagged with the outcome
+	err = dbgo.TracedTransaction(ctx, "rename-user", func(txCtx context.Context) error {
+		return dbgo.GetFromContext(txCtx).Model(&retrievedUser).Update("name", "Jane Doe").Error
+	})
+	if err != nil {
+		log.Fatalf("Failed to rename user: %v", err)
+	}
 }
 
 // getEnv gets an environment variable or returns a default value
@@ -55,8 +55,9 @@ func NewUserRepository() UserRepository {
 	return &gormUserRepository{}
 }
 
+// Save only runs inside a unit of work: MustTxDB panics when ctx carries no transaction.
 func (r *gormUserRepository) Save(ctx context.Context, user *User) error {
-	db := dbgo.GetFromContext(ctx)
+	db := dbgo.MustTxDB(ctx)
 	return db.Save(user).Error
 }
 
@@ -0,0 +1,91 @@
+package dbgo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	logger "github.com/adnvilla/logger-go"
+)
+
+// defaultPrimaryProbeInterval is how often the primary is probed when Config.PrimaryProbeInterval is zero.
+const defaultPrimaryProbeInterval = 10 * time.Second
+
+// primaryProbeSQL returns the address of the server a fresh connection reached, and whether it accepts writes.
+// inet_server_addr is NULL over a Unix socket, which leaves only the port to tell servers apart.
+const primaryProbeSQL = `SELECT coalesce(host(inet_server_addr()), '') || ':' || current_setting('port'), NOT pg_is_in_recovery()`
+
+// primaryWatcher detects a change of the server behind the primary DSN (Config.OnPrimaryChanged): after a
+// failover, the DSN's host resolves to the promoted replica, but the pool keeps its connections to the old
+// primary, now gone or demoted to a read-only replica.
+type primaryWatcher struct {
+	probe    func(ctx context.Context) (endpoint string, writable bool, err error)
+	conn     *connector // its connections are retired when the primary changes
+	onChange func(newEndpoint string)
+	endpoint string // last writable endpoint seen; empty until the first successful probe
+}
+
+// check probes the primary once. Failed probes and read-only servers (a DSN still resolving to the demoted
+// primary during the failover) are ignored until a writable server answers.
+func (w *primaryWatcher) check(ctx context.Context) {
+	endpoint, writable, err := w.probe(ctx)
+	if err != nil || !writable || endpoint == w.endpoint {
+		return
+	}
+	previous := w.endpoint
+	w.endpoint = endpoint
+	if previous == "" {
+		return
+	}
+	logger.Warn(ctx, "dbgo: primary changed from %s to %s; reconnecting the primary pool.", previous, endpoint)
+	w.conn.rotate()
+	emitConnEvent(ConnEvent{Type: EventPrimaryChanged, Endpoint: endpoint})
+	if w.onChange != nil {
+		// Not on the watcher goroutine: a callback calling ResetConnection would wait for it to stop.
+		go w.onChange(endpoint)
+	}
+}
+
+// watchPrimary probes the primary through c every interval (see primaryWatcher) until the returned function is
+// called; it returns once the goroutine has stopped. Probes use a pool of their own without idle connections,
+// so each one dials (and resolves the DSN's host) again.
+func watchPrimary(c *connector, interval time.Duration, onChange func(string)) (stop func()) {
+	probeDB := sql.OpenDB(c.Connector)
+	probeDB.SetMaxIdleConns(0)
+	w := &primaryWatcher{
+		probe: func(ctx context.Context) (endpoint string, writable bool, err error) {
+			err = probeDB.QueryRowContext(ctx, primaryProbeSQL).Scan(&endpoint, &writable)
+			return endpoint, writable, err
+		},
+		conn:     c,
+		onChange: onChange,
+	}
+	return w.watch(interval, func() { _ = probeDB.Close() })
+}
+
+// watch runs check now and then every interval, bounding each probe by the interval. Stopping cancels the
+// probe in progress.
+func (w *primaryWatcher) watch(interval time.Duration, cleanup func()) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer cleanup()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			probeCtx, cancelProbe := context.WithTimeout(ctx, interval)
+			w.check(probeCtx)
+			cancelProbe()
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-stopped
+	}
+}
@@ -0,0 +1,116 @@
+package dbgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrimaryWatcher_Check(t *testing.T) {
+	drainConnEvents()
+	t.Cleanup(func() { drainConnEvents() })
+
+	type probeResult struct {
+		endpoint string
+		writable bool
+		err      error
+	}
+	results := []probeResult{
+		{endpoint: "10.0.0.1:5432", writable: true},
+		{err: errors.New("connection refused")},      // the old primary is going away
+		{endpoint: "10.0.0.1:5432", writable: false}, // demoted, DNS not updated yet
+		{endpoint: "10.0.0.2:5432", writable: true},
+		{endpoint: "10.0.0.2:5432", writable: true},
+	}
+	changes := make(chan string, 10)
+	c := &connector{}
+	w := &primaryWatcher{
+		probe: func(context.Context) (string, bool, error) {
+			r := results[0]
+			results = results[1:]
+			return r.endpoint, r.writable, r.err
+		},
+		conn:     c,
+		onChange: func(endpoint string) { changes <- endpoint },
+	}
+
+	for range 3 {
+		w.check(context.Background())
+	}
+	assert.Equal(t, int64(0), c.generation.Load(), "the first probe sets the baseline; failed and read-only probes are ignored")
+
+	w.check(context.Background())
+	select {
+	case endpoint := <-changes:
+		assert.Equal(t, "10.0.0.2:5432", endpoint)
+	case <-time.After(time.Second):
+		t.Fatal("OnPrimaryChanged not called")
+	}
+	assert.Equal(t, int64(1), c.generation.Load(), "the primary's connections are retired")
+	select {
+	case ev := <-ConnectionEvents():
+		assert.Equal(t, EventPrimaryChanged, ev.Type)
+		assert.Equal(t, "10.0.0.2:5432", ev.Endpoint)
+	default:
+		t.Fatal("no primary change event sent")
+	}
+
+	w.check(context.Background())
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, changes, "no change, no call")
+}
+
+func TestPrimaryWatcher_OnChangeCanStopTheWatcher(t *testing.T) {
+	drainConnEvents()
+	t.Cleanup(func() { drainConnEvents() })
+
+	endpoints := []string{"10.0.0.1:5432", "10.0.0.2:5432"}
+	stops := make(chan func(), 1)
+	stopped := make(chan struct{})
+	w := &primaryWatcher{
+		probe: func(context.Context) (string, bool, error) {
+			endpoint := endpoints[0]
+			if len(endpoints) > 1 {
+				endpoints = endpoints[1:]
+			}
+			return endpoint, true, nil
+		},
+		conn: &connector{},
+		onChange: func(string) {
+			(<-stops)() // what ResetConnection does through stopWatchers
+			close(stopped)
+		},
+	}
+	stops <- w.watch(time.Millisecond, func() {})
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("OnPrimaryChanged stopping the watcher deadlocked")
+	}
+}
+
+func TestPrimaryWatcher_Watch(t *testing.T) {
+	probes := make(chan struct{}, 10)
+	w := &primaryWatcher{
+		probe: func(ctx context.Context) (string, bool, error) {
+			probes <- struct{}{}
+			<-ctx.Done() // a hanging probe is cancelled by stop
+			return "", false, ctx.Err()
+		},
+		conn: &connector{},
+	}
+	cleaned := false
+	stop := w.watch(time.Hour, func() { cleaned = true })
+
+	select {
+	case <-probes:
+	case <-time.After(time.Second):
+		t.Fatal("the primary is probed when the watcher starts")
+	}
+	stop()
+	assert.True(t, cleaned)
+}
@@ -0,0 +1,53 @@
+package dbgo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// IdempotencyKey is a row of the table in which ExecIdempotent records processed keys. Create the table with
+// AutoMigrate or MigrateWithAdvisoryLock:
+//
+//	err := dbConn.MigrateWithAdvisoryLock(ctx, &dbgo.IdempotencyKey{})
+type IdempotencyKey struct {
+	Key         string    `gorm:"primaryKey"`
+	ProcessedAt time.Time `gorm:"not null"`
+}
+
+// TableName returns "dbgo_idempotency_keys".
+func (IdempotencyKey) TableName() string {
+	return "dbgo_idempotency_keys"
+}
+
+// ExecIdempotent runs fn in a transaction (see WithTransaction) at most once per key, for at-least-once consumers
+// that may see the same message several times. The key is inserted into the IdempotencyKey table in the same
+// transaction as fn's writes: when it was already recorded, fn is skipped and nil is returned; when fn fails, the
+// rollback removes the key so a retry runs fn again. Concurrent calls with the same key are serialized by the
+// table's primary key: the second one waits for the first to commit (and then skips fn) or to roll back (and then
+// runs it). In a nested call the key is recorded when the outer transaction commits.
+// Example:
+//
+//	err := dbgo.ExecIdempotent(ctx, "payment:"+msg.ID, func(ctx context.Context) error {
+//	    return dbgo.GetFromContext(ctx).Create(&payment).Error
+//	})
+func ExecIdempotent(ctx context.Context, key string, fn UnitOfWork) error {
+	if fn == nil {
+		return ErrNilUnitOfWork
+	}
+	if key == "" {
+		return fmt.Errorf("%w: ExecIdempotent requires a non-empty key", ErrInvalidArgument)
+	}
+	return WithTransaction(ctx, func(ctx context.Context) error {
+		result := GetFromContext(ctx).Exec(
+			"INSERT INTO dbgo_idempotency_keys (key, processed_at) VALUES (?, ?) ON CONFLICT (key) DO NOTHING",
+			key, time.Now())
+		if result.Error != nil {
+			return fmt.Errorf("dbgo: recording idempotency key %q: %w", key, result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil // already processed
+		}
+		return fn(ctx)
+	})
+}
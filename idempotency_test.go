@@ -0,0 +1,83 @@
+package dbgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecIdempotent(t *testing.T) {
+	tests := []struct {
+		name       string
+		inserted   int64
+		fnErr      error
+		wantCalled bool
+		wantCommit bool
+	}{
+		{"new key runs fn", 1, nil, true, true},
+		{"processed key skips fn", 0, nil, false, true},
+		{"fn error rolls back the key", 1, errors.New("boom"), true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saveAndRestoreConn(t)
+
+			db, mock := newMockDB(t)
+			connMu.Lock()
+			conn = DBConn{Instance: db}
+			connMu.Unlock()
+
+			mock.ExpectBegin()
+			mock.ExpectExec(`INSERT INTO dbgo_idempotency_keys \(key, processed_at\) VALUES \(\$1, \$2\) ON CONFLICT \(key\) DO NOTHING`).
+				WithArgs("msg-1", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, tt.inserted))
+			if tt.wantCommit {
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			called := false
+			err := ExecIdempotent(context.Background(), "msg-1", func(ctx context.Context) error {
+				called = true
+				return tt.fnErr
+			})
+			assert.ErrorIs(t, err, tt.fnErr)
+			if tt.fnErr == nil {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantCalled, called)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestExecIdempotent_InsertFailure(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	errMissing := errors.New(`relation "dbgo_idempotency_keys" does not exist`)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO dbgo_idempotency_keys`).WillReturnError(errMissing)
+	mock.ExpectRollback()
+
+	err := ExecIdempotent(context.Background(), "msg-1", func(ctx context.Context) error {
+		t.Fatal("fn must not run")
+		return nil
+	})
+	assert.ErrorIs(t, err, errMissing)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExecIdempotent_InvalidArguments(t *testing.T) {
+	assert.ErrorIs(t, ExecIdempotent(context.Background(), "msg-1", nil), ErrNilUnitOfWork)
+	assert.Error(t, ExecIdempotent(context.Background(), "", func(ctx context.Context) error { return nil }))
+}
@@ -0,0 +1,65 @@
+package dbgo
+
+import (
+	"context"
+
+	logger "github.com/adnvilla/logger-go"
+	"gorm.io/gorm"
+)
+
+// implicitTxPlugin logs the writes that run outside WithTransaction (Config.Debug), to audit which writes rely on
+// GORM's implicit per-statement transaction before setting SkipDefaultTransaction. It is installed by
+// getConnection when Debug is set.
+type implicitTxPlugin struct {
+	log func(ctx context.Context, msg string, args ...interface{}) // logger.Info when nil
+}
+
+func (implicitTxPlugin) Name() string {
+	return "dbgo:implicit_tx"
+}
+
+func (p implicitTxPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().After("gorm:create").Register("dbgo:implicit_tx", p.logWrite); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register("dbgo:implicit_tx", p.logWrite); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:delete").Register("dbgo:implicit_tx", p.logWrite); err != nil {
+		return err
+	}
+	return cb.Raw().After("gorm:raw").Register("dbgo:implicit_tx", p.logRawWrite)
+}
+
+// logWrite logs a write executed outside WithTransaction, telling whether GORM wrapped it in its implicit
+// transaction. Writes in a transaction begun without WithTransaction (e.g. db.Transaction) are not logged.
+func (p implicitTxPlugin) logWrite(db *gorm.DB) {
+	sql := db.Statement.SQL.String()
+	if db.DryRun || sql == "" || txStateFrom(db.Statement.Context) != nil {
+		return
+	}
+	if _, implicit := db.InstanceGet("gorm:started_transaction"); implicit {
+		p.logf(db.Statement.Context, "Write outside WithTransaction ran in an implicit transaction: %s", sql)
+		return
+	}
+	if isTransaction(db) {
+		return
+	}
+	p.logf(db.Statement.Context, "Write outside WithTransaction ran without a transaction: %s", sql)
+}
+
+// logRawWrite logs raw statements (db.Exec) that are not plain reads. GORM never wraps them in a transaction.
+func (p implicitTxPlugin) logRawWrite(db *gorm.DB) {
+	if !isReadOnlySQL(db.Statement.SQL.String()) {
+		p.logWrite(db)
+	}
+}
+
+func (p implicitTxPlugin) logf(ctx context.Context, msg string, args ...interface{}) {
+	if p.log != nil {
+		p.log(ctx, msg, args...)
+		return
+	}
+	logger.Info(ctx, msg, args...)
+}
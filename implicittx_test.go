@@ -0,0 +1,80 @@
+package dbgo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type implicitTxUser struct {
+	ID   uint
+	Name string
+}
+
+func TestImplicitTxPlugin_LogsWritesOutsideWithTransaction(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	var logged []string
+	db, mock := newMockDB(t)
+	require.NoError(t, db.Use(callbacksPlugin{}))
+	require.NoError(t, db.Use(implicitTxPlugin{log: func(_ context.Context, msg string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(msg, args...))
+	}}))
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{}
+	connMu.Unlock()
+
+	// GORM wraps a single write in its implicit transaction.
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "implicit_tx_users"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, db.Model(&implicitTxUser{ID: 1}).Update("name", "a").Error)
+	require.Len(t, logged, 1)
+	assert.Contains(t, logged[0], "implicit transaction")
+	assert.Contains(t, logged[0], `UPDATE "implicit_tx_users"`)
+
+	// Raw writes and SkipDefaultTransaction run without one; raw reads are not logged.
+	mock.ExpectExec(`DELETE FROM implicit_tx_users`).WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, db.Exec("DELETE FROM implicit_tx_users").Error)
+	mock.ExpectExec(`UPDATE "implicit_tx_users"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, db.Session(&gorm.Session{SkipDefaultTransaction: true}).Model(&implicitTxUser{ID: 1}).Update("name", "b").Error)
+	mock.ExpectExec(`SET statement_timeout`).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, db.Exec("SET statement_timeout = 0").Error)
+	require.Len(t, logged, 3)
+	assert.Contains(t, logged[1], "without a transaction: DELETE FROM implicit_tx_users")
+	assert.Contains(t, logged[2], "without a transaction")
+
+	// Writes inside WithTransaction, or in a transaction begun directly, are not logged.
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "implicit_tx_users"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, WithTransaction(context.Background(), func(ctx context.Context) error {
+		return GetFromContext(ctx).Model(&implicitTxUser{ID: 1}).Update("name", "c").Error
+	}))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM implicit_tx_users`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		return tx.Exec("DELETE FROM implicit_tx_users").Error
+	}))
+	assert.Len(t, logged, 3)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetConnection_DebugInstallsImplicitTxPlugin(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	db, _ := newMockDB(t)
+	noPrepare := false
+	result := GetConnection(Config{Dialector: db.Dialector, PrepareStmt: &noPrepare, Debug: true})
+	require.NoError(t, result.Error)
+	_, ok := result.Instance.Config.Plugins["dbgo:implicit_tx"]
+	assert.True(t, ok)
+}
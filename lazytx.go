@@ -0,0 +1,237 @@
+package dbgo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logger "github.com/adnvilla/logger-go"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+type lazyTxKey struct{}
+
+// errLazyTxDone is returned by writes through the context of a MaybeTransaction that has returned.
+var errLazyTxDone = fmt.Errorf("dbgo: write through the context of a finished MaybeTransaction: %w", sql.ErrTxDone)
+
+// lazyTx is the transaction of a MaybeTransaction call, begun by the first write of its unit of work (see
+// registerLazyTransactions).
+type lazyTx struct {
+	ctx context.Context // MaybeTransaction's: the transaction lives as long as it
+	db  *gorm.DB        // the DB the transaction is begun on
+	cfg Config
+
+	mu      sync.Mutex
+	tx      *gorm.DB // nil until the first write
+	untrack func()   // removes tx from ActiveTransactions
+	done    bool     // MaybeTransaction has returned
+
+	failedSQL atomic.Pointer[string] // SQL of the last statement that failed in tx (tracked by callbacksPlugin)
+	use       txUse                  // the statement holding the transaction's connection (tracked by concurrentTxPlugin)
+}
+
+func lazyTxFrom(ctx context.Context) *lazyTx {
+	if ctx == nil {
+		return nil
+	}
+	lazy, _ := ctx.Value(lazyTxKey{}).(*lazyTx)
+	return lazy
+}
+
+// begin returns the transaction, beginning it on the primary if this is the first write. A failed begin is
+// returned to the statement that triggered it; the next write tries again.
+func (l *lazyTx) begin() (*gorm.DB, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return nil, errLazyTxDone
+	}
+	if l.tx != nil {
+		return l.tx, nil
+	}
+	session := l.db.Session(&gorm.Session{Context: l.ctx}).Clauses(dbresolver.Write)
+	prepareConnPool(session)
+	tx := session.Begin(beginOptions(l.cfg, false)...)
+	if tx.Error != nil {
+		return nil, readOnlyError(contextError(l.ctx, tx.Error))
+	}
+	if err := l.setUp(tx); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	l.tx = tx
+	l.untrack = trackTransaction()
+	return tx, nil
+}
+
+// setUp applies WithRole and Config.OnBeginTx to the transaction just begun, like WithTransaction.
+func (l *lazyTx) setUp(tx *gorm.DB) error {
+	if role, ok := roleFrom(l.ctx); ok {
+		if err := setLocalRole(tx, role); err != nil {
+			return err
+		}
+	}
+	if l.cfg.OnBeginTx != nil {
+		return l.cfg.OnBeginTx(SetFromContext(l.ctx, tx), tx)
+	}
+	return nil
+}
+
+// current returns the transaction once begun, or nil.
+func (l *lazyTx) current() (*gorm.DB, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return nil, nil
+	}
+	return l.tx, nil
+}
+
+// finish returns the transaction, if any, and makes later writes through the context fail.
+func (l *lazyTx) finish() *gorm.DB {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.done = true
+	return l.tx
+}
+
+// MaybeTransaction is like WithTransaction, but begins the transaction only when fn executes its first write
+// statement (INSERT/UPDATE/DELETE, or raw SQL other than SELECT/SHOW/SET/RESET) through the context DB, so a
+// unit of work that takes a read-only path runs without BEGIN/COMMIT. Statements before the first write run
+// outside the transaction, reads possibly on a replica; every statement after it, reads included, runs in the
+// transaction. Once begun, the transaction is WithTransaction's: on the primary, with Config.DefaultIsolation,
+// WithRole and Config.OnBeginTx, rolled back when fn fails (unless the error matches Config.NonFatalErrors) or
+// panics, and committed otherwise, with Config.LogRollbackSQL and Config.OnTransactionEnd. WithTransaction inside fn begins it and joins it; a MaybeTransaction inside a
+// transaction joins that transaction. It has no transaction span or MaxTransactionDuration. Writes are detected
+// by dbgo's GORM callbacks, so it only applies to connections from GetConnection.
+// Example:
+//
+//	err := dbgo.MaybeTransaction(ctx, func(ctx context.Context) error {
+//	    db := dbgo.GetFromContext(ctx)
+//	    if err := db.First(&order, id).Error; err != nil || order.Paid {
+//	        return err // no transaction was begun
+//	    }
+//	    return db.Model(&order).Update("paid", true).Error // begins the transaction
+//	})
+func MaybeTransaction(ctx context.Context, fn UnitOfWork) (err error) {
+	start := time.Now()
+	cfg := GetActiveConfig()
+	if fn == nil {
+		return ErrNilUnitOfWork
+	}
+	db, err := transactionDB(ctx, cfg)
+	if err != nil {
+		return wrapError(cfg, "MaybeTransaction", start, nil, err)
+	}
+	if isTransaction(db) {
+		return WithTransaction(ctx, fn)
+	}
+	if lazyTxFrom(ctx) != nil {
+		return fn(ctx)
+	}
+
+	callerCtx := ctx
+	lazy := &lazyTx{db: db, cfg: cfg}
+	ctx = context.WithValue(ctx, lazyTxKey{}, lazy)
+	// Bound to ctx: GetFromContext keeps a DB's context when it has the same Done channel as the caller's.
+	ctx = SetFromContext(ctx, db.WithContext(ctx))
+	lazy.ctx = ctx
+	defer func() {
+		tx := lazy.finish()
+		if tx == nil {
+			err = wrapError(cfg, "MaybeTransaction", start, nil, err)
+			return
+		}
+		defer lazy.untrack()
+		committed := false
+		if p := recover(); p != nil {
+			if rbErr := tx.Rollback().Error; rbErr != nil {
+				logger.Error(ctx, "failed to rollback transaction: %v", rbErr)
+			}
+			recordPanic(ctx, nil, p)
+			if cfg.OnTransactionEnd != nil {
+				cfg.OnTransactionEnd(callerCtx, false, time.Since(start), fmt.Errorf("panic in transaction: %v", p))
+			}
+			panic(p)
+		} else if err != nil && !nonFatalError(cfg, err) {
+			if cfg.LogRollbackSQL {
+				logRollback(ctx, lazy.failedSQL.Load(), err)
+			}
+			if rbErr := tx.Rollback().Error; rbErr != nil {
+				logger.Error(ctx, "failed to rollback transaction: %v", rbErr)
+			}
+		} else if commitErr := tx.Commit().Error; commitErr != nil {
+			err = commitErr
+		} else {
+			committed = true
+		}
+		err = wrapError(cfg, "MaybeTransaction", start, tx, readOnlyError(contextError(ctx, err)))
+		if cfg.OnTransactionEnd != nil {
+			cfg.OnTransactionEnd(callerCtx, committed, time.Since(start), err)
+		}
+	}()
+	return fn(ctx)
+}
+
+// registerLazyTransactions registers the callbacks that run statements in MaybeTransaction's transaction:
+// writes begin it, and every statement joins it once begun. They run before GORM begins its implicit
+// transaction for a write, and before dbresolver picks a source, which it leaves alone inside a transaction.
+func registerLazyTransactions(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:begin_transaction").Register("dbgo:lazy_tx", joinLazyTransaction(isWrite)); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:begin_transaction").Register("dbgo:lazy_tx", joinLazyTransaction(isWrite)); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:begin_transaction").Register("dbgo:lazy_tx", joinLazyTransaction(isWrite)); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("dbgo:lazy_tx", joinLazyTransaction(isRawWrite)); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("dbgo:lazy_tx", joinLazyTransaction(isRawWrite)); err != nil {
+		return err
+	}
+	return cb.Raw().Before("gorm:raw").Register("dbgo:lazy_tx", joinLazyTransaction(isRawWrite))
+}
+
+func isWrite(*gorm.DB) bool {
+	return true
+}
+
+// isRawWrite reports whether the statement runs SQL given by the caller (db.Exec, db.Raw) that is not a plain read.
+func isRawWrite(db *gorm.DB) bool {
+	sql := db.Statement.SQL.String()
+	return sql != "" && !isReadOnlySQL(sql)
+}
+
+// joinLazyTransaction returns a callback moving the statement into the transaction of the MaybeTransaction
+// carried by its context: write statements (as told by write) begin it when needed, other statements only join
+// it once begun.
+func joinLazyTransaction(write func(db *gorm.DB) bool) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		lazy := lazyTxFrom(db.Statement.Context)
+		if lazy == nil || db.Error != nil || db.DryRun || isTransaction(db) {
+			return
+		}
+		var tx *gorm.DB
+		var err error
+		if write(db) {
+			tx, err = lazy.begin()
+		} else {
+			tx, err = lazy.current()
+		}
+		if err != nil {
+			_ = db.AddError(err)
+			return
+		}
+		if tx != nil {
+			db.Statement.ConnPool = tx.Statement.ConnPool
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package dbgo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type lazyTxOrder struct {
+	ID   uint
+	Paid bool
+}
+
+// setupLazyTxDB installs a mock DB with dbgo's callbacks as the default connection.
+func setupLazyTxDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	saveAndRestoreConn(t)
+	db, mock := newMockDB(t)
+	require.NoError(t, db.Use(callbacksPlugin{}))
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{}
+	connMu.Unlock()
+	return mock
+}
+
+func TestMaybeTransaction_ReadOnlyPathDoesNotBegin(t *testing.T) {
+	mock := setupLazyTxDB(t)
+
+	mock.ExpectQuery(`SELECT \* FROM "lazy_tx_orders"`).WillReturnRows(sqlmock.NewRows([]string{"id", "paid"}).AddRow(1, true))
+	mock.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	err := MaybeTransaction(context.Background(), func(ctx context.Context) error {
+		db := GetFromContext(ctx)
+		var order lazyTxOrder
+		if err := db.First(&order, 1).Error; err != nil {
+			return err
+		}
+		var n int64
+		return db.Raw("SELECT count(*) FROM lazy_tx_orders").Scan(&n).Error
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMaybeTransaction_BeginsOnFirstWrite(t *testing.T) {
+	mock := setupLazyTxDB(t)
+
+	mock.ExpectQuery(`SELECT \* FROM "lazy_tx_orders"`).WillReturnRows(sqlmock.NewRows([]string{"id", "paid"}).AddRow(1, false))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "lazy_tx_orders"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO audit`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT \* FROM "lazy_tx_orders"`).WillReturnRows(sqlmock.NewRows([]string{"id", "paid"}).AddRow(1, true))
+	mock.ExpectCommit()
+
+	err := MaybeTransaction(context.Background(), func(ctx context.Context) error {
+		db := GetFromContext(ctx)
+		var order lazyTxOrder
+		if err := db.First(&order, 1).Error; err != nil {
+			return err
+		}
+		// GORM's implicit transaction is not begun inside the lazy one.
+		if err := db.Model(&order).Update("paid", true).Error; err != nil {
+			return err
+		}
+		if err := WithTransaction(ctx, func(ctx context.Context) error {
+			return GetFromContext(ctx).Exec("INSERT INTO audit VALUES (1)").Error
+		}); err != nil {
+			return err
+		}
+		return db.First(&order, 1).Error
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMaybeTransaction_RollsBackOnError(t *testing.T) {
+	mock := setupLazyTxDB(t)
+	fnErr := errors.New("out of stock")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM reservations`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	var leaked context.Context
+	err := MaybeTransaction(context.Background(), func(ctx context.Context) error {
+		leaked = ctx
+		if err := GetFromContext(ctx).Exec("DELETE FROM reservations").Error; err != nil {
+			return err
+		}
+		return fnErr
+	})
+	assert.ErrorIs(t, err, fnErr)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	err = GetFromContext(leaked).Exec("DELETE FROM reservations").Error
+	assert.ErrorIs(t, err, sql.ErrTxDone, "writes through the context of a finished MaybeTransaction fail")
+}
+
+func TestMaybeTransaction_JoinsOuterTransaction(t *testing.T) {
+	mock := setupLazyTxDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "lazy_tx_orders"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	err := WithTransaction(context.Background(), func(ctx context.Context) error {
+		return MaybeTransaction(ctx, func(ctx context.Context) error {
+			return GetFromContext(ctx).Session(&gorm.Session{SkipDefaultTransaction: true}).
+				Model(&lazyTxOrder{ID: 1}).Update("paid", true).Error
+		})
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.ErrorIs(t, MaybeTransaction(context.Background(), nil), ErrNilUnitOfWork)
+}
+
+func TestMaybeTransaction_OnTransactionEndAndFailedSQL(t *testing.T) {
+	mock := setupLazyTxDB(t)
+	var committed []bool
+	connMu.Lock()
+	activeConfig = Config{OnTransactionEnd: func(ctx context.Context, ok bool, d time.Duration, err error) {
+		committed = append(committed, ok)
+	}}
+	connMu.Unlock()
+	errDuplicate := errors.New(`duplicate key value violates unique constraint "orders_pkey"`)
+
+	mock.ExpectQuery(`SELECT \* FROM "lazy_tx_orders"`).WillReturnRows(sqlmock.NewRows([]string{"id", "paid"}).AddRow(1, true))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE orders`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO orders`).WillReturnError(errDuplicate)
+	mock.ExpectRollback()
+
+	assert.NoError(t, MaybeTransaction(context.Background(), func(ctx context.Context) error {
+		var order lazyTxOrder
+		return GetFromContext(ctx).First(&order, 1).Error // never begun: not reported
+	}))
+	assert.NoError(t, MaybeTransaction(context.Background(), func(ctx context.Context) error {
+		return GetFromContext(ctx).Exec("UPDATE orders SET paid = true").Error
+	}))
+	var lazy *lazyTx
+	err := MaybeTransaction(context.Background(), func(ctx context.Context) error {
+		lazy = lazyTxFrom(ctx)
+		return GetFromContext(ctx).Exec("INSERT INTO orders (id) VALUES (?)", 1).Error
+	})
+	assert.ErrorIs(t, err, errDuplicate)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, []bool{true, false}, committed)
+	if sql := lazy.failedSQL.Load(); assert.NotNil(t, sql, "recorded for Config.LogRollbackSQL") {
+		assert.Equal(t, "INSERT INTO orders (id) VALUES ($1)", *sql)
+	}
+}
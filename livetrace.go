@@ -0,0 +1,115 @@
+package dbgo
+
+import (
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+// tracingSettings are the tracing options the callbacks installed by installTracing read for each statement.
+type tracingSettings struct {
+	enabled     bool
+	rate        *float64
+	read, write *float64
+	errCheck    func(error) bool
+}
+
+// liveTracing holds the settings of a connection's tracing callbacks. UpdateTracing swaps them, so the
+// callbacks (which GORM does not allow to change safely while statements run) stay installed.
+type liveTracing struct {
+	settings atomic.Pointer[tracingSettings]
+}
+
+func newLiveTracing(cfg Config) *liveTracing {
+	t := &liveTracing{}
+	t.update(cfg)
+	return t
+}
+
+func (t *liveTracing) update(cfg Config) {
+	t.settings.Store(&tracingSettings{
+		enabled:  cfg.EnableTracing,
+		rate:     cfg.TracingAnalyticsRate,
+		read:     cfg.TracingReadAnalyticsRate,
+		write:    cfg.TracingWriteAnalyticsRate,
+		errCheck: cfg.TracingErrorCheck,
+	})
+}
+
+func (t *liveTracing) load() *tracingSettings {
+	return t.settings.Load()
+}
+
+// tracedKey is the statement setting holding the tracing decision taken by the before callback (see decide).
+const tracedKey = "dbgo:traced"
+
+// active reports whether the statement is traced: the decision taken by decide when the tracing before callback
+// ran, otherwise whether tracing is enabled and the statement is not under WithoutTracing.
+func (t *liveTracing) active(db *gorm.DB) bool {
+	if db.Statement == nil {
+		return t.load().enabled
+	}
+	if traced, ok := db.InstanceGet(tracedKey); ok && traced != nil {
+		return traced.(bool)
+	}
+	return t.load().enabled && !tracingDisabled(db.Statement.Context)
+}
+
+// decide takes the tracing decision of the statement once, in the tracing before callback, so that an
+// UpdateTracing running concurrently cannot make the after callback disagree with it (finishing a span that was
+// never started, or leaving one open).
+func (t *liveTracing) decide(db *gorm.DB) bool {
+	db.InstanceSet(tracedKey, nil)
+	traced := t.active(db)
+	db.InstanceSet(tracedKey, traced)
+	return traced
+}
+
+// consume returns the decision taken by decide and clears it, so a statement reused by a chained DB decides again.
+func (t *liveTracing) consume(db *gorm.DB) bool {
+	traced := t.active(db)
+	if db.Statement != nil {
+		db.InstanceSet(tracedKey, nil)
+	}
+	return traced
+}
+
+// reportError is the error check of the tracing callbacks: Config.TracingErrorCheck, or every error when unset.
+func (t *liveTracing) reportError(err error) bool {
+	check := t.load().errCheck
+	return check == nil || check(err)
+}
+
+// UpdateTracing changes the tracing of the connection opened by GetConnection without reconnecting, e.g. to
+// raise the analytics rate to 1.0 while investigating an incident and lower it afterwards. It applies
+// cfg.EnableTracing, the analytics rates (TracingAnalyticsRate, TracingReadAnalyticsRate,
+// TracingWriteAnalyticsRate, TracingTransactionAnalyticsRate) and TracingErrorCheck to the statements and
+// transactions started after it returns; the other tracing options (service name, resource namer, connection
+// acquisition spans, pool metrics) keep the values the connection was opened with. The new values are recorded
+// in GetActiveConfig.
+// Returns ErrNoDatabase when no connection has been established, or an error wrapping ErrInvalidConfig.
+// Example:
+//
+//	cfg := dbgo.GetActiveConfig()
+//	cfg = *dbgo.WithTracingAnalyticsRate(1.0)(&cfg)
+//	err := dbgo.UpdateTracing(cfg)
+func UpdateTracing(cfg Config) error {
+	connMu.Lock()
+	defer connMu.Unlock()
+	if !hasConnection(conn.Instance) || connTracing == nil {
+		return ErrNoDatabase
+	}
+	updated := activeConfig
+	updated.EnableTracing = cfg.EnableTracing
+	updated.TracingAnalyticsRate = cfg.TracingAnalyticsRate
+	updated.TracingReadAnalyticsRate = cfg.TracingReadAnalyticsRate
+	updated.TracingWriteAnalyticsRate = cfg.TracingWriteAnalyticsRate
+	updated.TracingTransactionAnalyticsRate = cfg.TracingTransactionAnalyticsRate
+	updated.TracingErrorCheck = cfg.TracingErrorCheck
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+	activeConfig = updated
+	connTracing.update(activeConfig)
+	return nil
+}
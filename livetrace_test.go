@@ -0,0 +1,113 @@
+package dbgo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/ext"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/mocktracer"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/tracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestUpdateTracing(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	noPrepare := false
+	result := GetConnection(Config{Dialector: postgres.New(postgres.Config{Conn: mockDB}), PrepareStmt: &noPrepare})
+	require.NoError(t, result.Error)
+
+	var one int
+	selectOne := func() {
+		mock.ExpectQuery(`SELECT 1`).WillReturnRows(sqlmock.NewRows([]string{"one"}).AddRow(1))
+		require.NoError(t, Raw(context.Background(), &one, "SELECT 1"))
+	}
+
+	selectOne()
+	assert.Empty(t, mt.FinishedSpans(), "tracing disabled at connect time")
+
+	cfg := GetActiveConfig()
+	cfg = *WithTracingAnalyticsRate(1.0)(WithTracing(&cfg))
+	require.NoError(t, UpdateTracing(cfg))
+	assert.True(t, GetActiveConfig().EnableTracing)
+	selectOne()
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, 1.0, spans[0].Tag(ext.EventSampleRate))
+
+	cfg = *WithTracingOperationAnalyticsRates(0.2, 1.0, 1.0)(&cfg)
+	require.NoError(t, UpdateTracing(cfg))
+	mt.Reset()
+	selectOne()
+	spans = mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, 0.2, spans[0].Tag(ext.EventSampleRate))
+
+	cfg.EnableTracing = false
+	require.NoError(t, UpdateTracing(cfg))
+	mt.Reset()
+	selectOne()
+	assert.Empty(t, mt.FinishedSpans())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateTracing_Errors(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	assert.ErrorIs(t, UpdateTracing(Config{EnableTracing: true}), ErrNoDatabase)
+
+	mockDB, _, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+	require.NoError(t, GetConnection(Config{Dialector: postgres.New(postgres.Config{Conn: mockDB})}).Error)
+
+	rate := 2.0
+	assert.ErrorIs(t, UpdateTracing(Config{EnableTracing: true, TracingAnalyticsRate: &rate}), ErrInvalidConfig)
+	assert.ErrorIs(t, UpdateTracing(Config{EnableTracing: true, TracingReadAnalyticsRate: &rate}), ErrInvalidConfig)
+	assert.False(t, GetActiveConfig().EnableTracing, "a rejected update leaves the configuration unchanged")
+}
+
+func TestLiveTracing_DecisionTakenOnceByTheBeforeCallback(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			db, mock := newMockDB(t)
+			live := newLiveTracing(Config{EnableTracing: enabled})
+			require.NoError(t, installTracing(db, Config{EnableTracing: true}, live))
+			// Flip the setting while the statement runs, as a concurrent UpdateTracing would.
+			require.NoError(t, db.Callback().Row().Before("dd-trace-go:after_row_query").Register("test:flip", func(*gorm.DB) {
+				live.update(Config{EnableTracing: !enabled})
+			}))
+
+			parent, ctx := tracer.StartSpanFromContext(context.Background(), "http.request")
+			mock.ExpectQuery(`SELECT 1`).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+			var n int
+			require.NoError(t, db.WithContext(ctx).Raw("SELECT 1").Scan(&n).Error)
+
+			spans := mt.FinishedSpans()
+			if enabled {
+				require.Len(t, spans, 1, "the statement span started by the before callback is finished")
+				assert.Equal(t, "gorm.row_query", spans[0].OperationName())
+			} else {
+				assert.Empty(t, spans, "the caller's span is not finished by the after callback")
+			}
+			parent.Finish()
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
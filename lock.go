@@ -0,0 +1,29 @@
+package dbgo
+
+import (
+	"context"
+	"fmt"
+)
+
+// WithAdvisoryLock runs fn in a transaction (see WithTransaction) holding the PostgreSQL advisory lock key,
+// taken with pg_advisory_xact_lock: processes calling it with the same key run fn one at a time, which
+// serializes cross-process critical sections such as singleton jobs. The lock is released when the transaction
+// commits or rolls back, so it cannot leak even if the process dies. WithAdvisoryLock waits for the lock as long
+// as ctx allows. In a nested call the lock is held until the outer transaction ends.
+// Example:
+//
+//	const reportJobLock = 42
+//	err := dbgo.WithAdvisoryLock(ctx, reportJobLock, func(ctx context.Context) error {
+//	    return generateDailyReport(ctx)
+//	})
+func WithAdvisoryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) error {
+	if fn == nil {
+		return ErrNilUnitOfWork
+	}
+	return WithTransaction(ctx, func(ctx context.Context) error {
+		if err := GetFromContext(ctx).Exec("SELECT pg_advisory_xact_lock(?)", key).Error; err != nil {
+			return fmt.Errorf("dbgo: acquiring advisory lock %d: %w", key, err)
+		}
+		return fn(ctx)
+	})
+}
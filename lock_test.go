@@ -0,0 +1,57 @@
+package dbgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithAdvisoryLock(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock\(\$1\)`).WithArgs(int64(42)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE jobs`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := WithAdvisoryLock(context.Background(), 42, func(ctx context.Context) error {
+		_, err := Exec(ctx, "UPDATE jobs SET last_run = now()")
+		return err
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithAdvisoryLock_LockFailureSkipsFn(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	errTimeout := errors.New("canceling statement due to lock timeout")
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WillReturnError(errTimeout)
+	mock.ExpectRollback()
+
+	called := false
+	err := WithAdvisoryLock(context.Background(), 7, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	assert.ErrorIs(t, err, errTimeout)
+	assert.ErrorContains(t, err, "advisory lock 7")
+	assert.False(t, called)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.ErrorIs(t, WithAdvisoryLock(context.Background(), 7, nil), ErrNilUnitOfWork)
+}
@@ -0,0 +1,89 @@
+package dbgo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	logger "github.com/adnvilla/logger-go"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// queryLogger is a GORM logger.Interface that writes through logger-go using the statement context,
+// so query logs carry the request's logger fields (trace id, user id, ...). It is installed by
+// getConnection when Config.LogQueries or Config.SlowQueryThreshold is set.
+type queryLogger struct {
+	level         gormlogger.LogLevel
+	slowThreshold time.Duration
+	// log emits one entry; it is logAt outside of tests.
+	log func(ctx context.Context, level gormlogger.LogLevel, msg string, args ...interface{})
+}
+
+// newQueryLogger returns the GORM logger for config, or nil to keep GORM's default logger.
+func newQueryLogger(config Config) gormlogger.Interface {
+	level := gormlogger.Warn
+	switch {
+	case config.LogQueries:
+		level = gormlogger.Info
+	case config.SlowQueryThreshold <= 0:
+		return nil
+	}
+	return &queryLogger{level: level, slowThreshold: config.SlowQueryThreshold, log: logAt}
+}
+
+// logAt writes msg through logger-go at the given GORM level.
+func logAt(ctx context.Context, level gormlogger.LogLevel, msg string, args ...interface{}) {
+	switch level {
+	case gormlogger.Error:
+		logger.Error(ctx, msg, args...)
+	case gormlogger.Warn:
+		logger.Warn(ctx, msg, args...)
+	default:
+		logger.Info(ctx, msg, args...)
+	}
+}
+
+func (l *queryLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	clone := *l
+	clone.level = level
+	return &clone
+}
+
+func (l *queryLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Info {
+		l.log(ctx, gormlogger.Info, msg, args...)
+	}
+}
+
+func (l *queryLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Warn {
+		l.log(ctx, gormlogger.Warn, msg, args...)
+	}
+}
+
+func (l *queryLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Error {
+		l.log(ctx, gormlogger.Error, msg, args...)
+	}
+}
+
+// Trace logs a finished statement: failures at error level, statements slower than slowThreshold at warn
+// level, and everything else at info level. gorm.ErrRecordNotFound is not treated as a failure.
+func (l *queryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= gormlogger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && l.level >= gormlogger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		l.log(ctx, gormlogger.Error, "query failed: %v [%s] [rows:%d] %s", err, elapsed, rows, sql)
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold && l.level >= gormlogger.Warn:
+		sql, rows := fc()
+		l.log(ctx, gormlogger.Warn, "slow query (>%s) [%s] [rows:%d] %s", l.slowThreshold, elapsed, rows, sql)
+	case l.level >= gormlogger.Info:
+		sql, rows := fc()
+		l.log(ctx, gormlogger.Info, "[%s] [rows:%d] %s", elapsed, rows, sql)
+	}
+}
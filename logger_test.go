@@ -0,0 +1,94 @@
+package dbgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+type logEntry struct {
+	ctx   context.Context
+	level gormlogger.LogLevel
+	msg   string
+}
+
+// capturingQueryLogger returns a queryLogger that records entries instead of writing them.
+func capturingQueryLogger(level gormlogger.LogLevel, slow time.Duration) (*queryLogger, *[]logEntry) {
+	var entries []logEntry
+	l := &queryLogger{level: level, slowThreshold: slow}
+	l.log = func(ctx context.Context, level gormlogger.LogLevel, msg string, args ...interface{}) {
+		entries = append(entries, logEntry{ctx: ctx, level: level, msg: fmt.Sprintf(msg, args...)})
+	}
+	return l, &entries
+}
+
+func TestNewQueryLogger(t *testing.T) {
+	assert.Nil(t, newQueryLogger(Config{}), "GORM's default logger is kept unless configured")
+
+	l, ok := newQueryLogger(Config{LogQueries: true}).(*queryLogger)
+	assert.True(t, ok)
+	assert.Equal(t, gormlogger.Info, l.level)
+
+	l, ok = newQueryLogger(Config{SlowQueryThreshold: time.Second}).(*queryLogger)
+	assert.True(t, ok)
+	assert.Equal(t, gormlogger.Warn, l.level)
+	assert.Equal(t, time.Second, l.slowThreshold)
+}
+
+func TestQueryLogger_Trace(t *testing.T) {
+	sql := func() (string, int64) { return "SELECT 1", 1 }
+
+	tests := []struct {
+		name      string
+		level     gormlogger.LogLevel
+		slow      time.Duration
+		elapsed   time.Duration
+		err       error
+		wantLevel gormlogger.LogLevel // 0 = nothing logged
+		wantMsg   string
+	}{
+		{"info logs every query", gormlogger.Info, 0, 0, nil, gormlogger.Info, "[rows:1] SELECT 1"},
+		{"warn skips fast queries", gormlogger.Warn, time.Second, 0, nil, 0, ""},
+		{"slow query", gormlogger.Warn, time.Millisecond, time.Second, nil, gormlogger.Warn, "slow query (>1ms)"},
+		{"failed query", gormlogger.Warn, 0, 0, errors.New("boom"), gormlogger.Error, "query failed: boom"},
+		{"record not found is not a failure", gormlogger.Warn, 0, 0, gorm.ErrRecordNotFound, 0, ""},
+		{"silent", gormlogger.Silent, time.Millisecond, time.Second, errors.New("boom"), 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, entries := capturingQueryLogger(tt.level, tt.slow)
+			l.Trace(context.Background(), time.Now().Add(-tt.elapsed), sql, tt.err)
+			if tt.wantLevel == 0 {
+				assert.Empty(t, *entries)
+				return
+			}
+			if assert.Len(t, *entries, 1) {
+				assert.Equal(t, tt.wantLevel, (*entries)[0].level)
+				assert.Contains(t, (*entries)[0].msg, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestQueryLogger_UsesStatementContext(t *testing.T) {
+	db, mock := newMockDB(t)
+	l, entries := capturingQueryLogger(gormlogger.Info, 0)
+	db.Logger = l
+
+	type requestKey struct{}
+	ctx := context.WithValue(context.Background(), requestKey{}, "req-42")
+	mock.ExpectExec(`UPDATE users`).WillReturnResult(sqlmock.NewResult(0, 3))
+
+	assert.NoError(t, db.WithContext(ctx).Exec("UPDATE users SET active = true").Error)
+	if assert.Len(t, *entries, 1) {
+		assert.Equal(t, "req-42", (*entries)[0].ctx.Value(requestKey{}))
+		assert.Contains(t, (*entries)[0].msg, "[rows:3] UPDATE users SET active = true")
+	}
+}
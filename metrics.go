@@ -0,0 +1,70 @@
+package dbgo
+
+import (
+	"database/sql"
+	"time"
+)
+
+// MetricsClient is the subset of the DogStatsD client (github.com/DataDog/datadog-go/v5/statsd) used to report
+// pool metrics; a *statsd.Client satisfies it.
+type MetricsClient interface {
+	Gauge(name string, value float64, tags []string, rate float64) error
+}
+
+// Pool metric names reported every Config.PoolMetricsInterval, from sql.DBStats of the primary pool.
+const (
+	MetricPoolMaxOpen           = "dbgo.pool.max_open"
+	MetricPoolOpen              = "dbgo.pool.open"
+	MetricPoolInUse             = "dbgo.pool.in_use"
+	MetricPoolIdle              = "dbgo.pool.idle"
+	MetricPoolWaitCount         = "dbgo.pool.wait_count"           // cumulative
+	MetricPoolWaitDuration      = "dbgo.pool.wait_duration"        // cumulative, in seconds
+	MetricPoolMaxIdleClosed     = "dbgo.pool.max_idle_closed"      // cumulative
+	MetricPoolMaxIdleTimeClosed = "dbgo.pool.max_idle_time_closed" // cumulative
+	MetricPoolMaxLifetimeClosed = "dbgo.pool.max_lifetime_closed"  // cumulative
+)
+
+// startPoolMetrics reports sqlDB's pool statistics to client every interval until the returned function is
+// called; it returns once the reporting goroutine has stopped.
+func startPoolMetrics(sqlDB *sql.DB, client MetricsClient, interval time.Duration, tags []string) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				reportPoolStats(client, sqlDB.Stats(), tags)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// reportPoolStats sends one gauge per pool statistic. Errors are ignored: DogStatsD is fire-and-forget, and
+// a missed sample is replaced by the next one.
+func reportPoolStats(client MetricsClient, stats sql.DBStats, tags []string) {
+	for _, m := range []struct {
+		name  string
+		value float64
+	}{
+		{MetricPoolMaxOpen, float64(stats.MaxOpenConnections)},
+		{MetricPoolOpen, float64(stats.OpenConnections)},
+		{MetricPoolInUse, float64(stats.InUse)},
+		{MetricPoolIdle, float64(stats.Idle)},
+		{MetricPoolWaitCount, float64(stats.WaitCount)},
+		{MetricPoolWaitDuration, stats.WaitDuration.Seconds()},
+		{MetricPoolMaxIdleClosed, float64(stats.MaxIdleClosed)},
+		{MetricPoolMaxIdleTimeClosed, float64(stats.MaxIdleTimeClosed)},
+		{MetricPoolMaxLifetimeClosed, float64(stats.MaxLifetimeClosed)},
+	} {
+		_ = client.Gauge(m.name, m.value, tags, 1)
+	}
+}
@@ -0,0 +1,97 @@
+package dbgo
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+)
+
+// gaugeRecorder is a MetricsClient recording the last value and tags of each gauge.
+type gaugeRecorder struct {
+	mu     sync.Mutex
+	values map[string]float64
+	tags   []string
+	calls  int
+}
+
+func (r *gaugeRecorder) Gauge(name string, value float64, tags []string, _ float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.values == nil {
+		r.values = make(map[string]float64)
+	}
+	r.values[name] = value
+	r.tags = tags
+	r.calls++
+	return nil
+}
+
+func (r *gaugeRecorder) snapshot() (map[string]float64, []string, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	values := make(map[string]float64, len(r.values))
+	for k, v := range r.values {
+		values[k] = v
+	}
+	return values, r.tags, r.calls
+}
+
+func TestPoolMetrics_ReportedUntilReset(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	mockDB, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	maxOpen := 7
+	client := &gaugeRecorder{}
+	result := GetConnection(Config{
+		Dialector:           postgres.New(postgres.Config{Conn: mockDB}),
+		MaxOpenConns:        &maxOpen,
+		EnableTracing:       true,
+		TracingServiceName:  "orders-db",
+		PoolMetricsInterval: 5 * time.Millisecond,
+		PoolMetricsClient:   client,
+	})
+	assert.NoError(t, result.Error)
+
+	assert.Eventually(t, func() bool {
+		values, _, _ := client.snapshot()
+		return len(values) == 9
+	}, time.Second, 5*time.Millisecond)
+	values, tags, _ := client.snapshot()
+	assert.Equal(t, float64(7), values[MetricPoolMaxOpen])
+	assert.Contains(t, values, MetricPoolInUse)
+	assert.Equal(t, []string{"service:orders-db"}, tags)
+
+	ResetConnection()
+	_, _, calls := client.snapshot()
+	time.Sleep(20 * time.Millisecond)
+	_, _, after := client.snapshot()
+	assert.Equal(t, calls, after, "ResetConnection stops the reporter")
+}
+
+func TestPoolMetrics_RequiresTracing(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	mockDB, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	client := &gaugeRecorder{}
+	result := GetConnection(Config{
+		Dialector:           postgres.New(postgres.Config{Conn: mockDB}),
+		PoolMetricsInterval: time.Millisecond,
+		PoolMetricsClient:   client,
+	})
+	assert.NoError(t, result.Error)
+	time.Sleep(20 * time.Millisecond)
+	_, _, calls := client.snapshot()
+	assert.Zero(t, calls)
+}
@@ -0,0 +1,74 @@
+package dbgo
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// migrationLockName identifies the advisory lock taken by MigrateWithAdvisoryLock (hashed by PostgreSQL's hashtext).
+const migrationLockName = "dbgo:migrate"
+
+// MigrateWithAdvisoryLock runs AutoMigrate for models while holding a PostgreSQL advisory lock, so when several
+// pods start at once only one migrates at a time and the others wait for it instead of racing (and deadlocking)
+// on DDL. The migration runs in a transaction on the primary that holds pg_advisory_xact_lock: DDL is
+// transactional in PostgreSQL, so a failed migration is rolled back as a whole and the lock is released with
+// the transaction, even if the process dies. ctx bounds the wait for the lock and the migration.
+// Returns c.Error when the connection failed to open, or ErrNoDatabase when c holds no connection.
+// Example:
+//
+//	if err := dbConn.MigrateWithAdvisoryLock(ctx, &User{}, &Order{}); err != nil {
+//	    log.Fatal(err)
+//	}
+func (c *DBConn) MigrateWithAdvisoryLock(ctx context.Context, models ...interface{}) error {
+	if c == nil {
+		return ErrNoDatabase
+	}
+	if c.Error != nil {
+		return c.Error
+	}
+	if !hasConnection(c.Instance) {
+		return ErrNoDatabase
+	}
+	return c.Instance.WithContext(ctx).Clauses(dbresolver.Write).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", migrationLockName).Error; err != nil {
+			return fmt.Errorf("dbgo: acquiring migration lock: %w", err)
+		}
+		return tx.AutoMigrate(models...)
+	})
+}
+
+// MigrateWithProgress runs AutoMigrate for each model in turn on the primary, using the DB from ctx (or the
+// default singleton), and calls onEach (when not nil) after each one with the model and its error, so deploys
+// can log the progress of long migrations and time each model. It stops at the first failure, returning it
+// with the model's type; models migrated before it are kept. Unlike MigrateWithAdvisoryLock, models are not
+// migrated in a single transaction. Returns ErrNoDatabase when no connection is available.
+// Example:
+//
+//	start := time.Now()
+//	err := dbgo.MigrateWithProgress(ctx, []interface{}{&User{}, &Order{}}, func(model interface{}, err error) {
+//	    log.Printf("migrated %T in %s (err: %v)", model, time.Since(start), err)
+//	    start = time.Now()
+//	})
+func MigrateWithProgress(ctx context.Context, models []interface{}, onEach func(model interface{}, err error)) error {
+	db, err := dbFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	db = db.Clauses(dbresolver.Write)
+	for _, model := range models {
+		err := ctx.Err()
+		if err == nil {
+			err = db.AutoMigrate(model)
+		}
+		if onEach != nil {
+			onEach(model, err)
+		}
+		if err != nil {
+			return fmt.Errorf("dbgo: migrating %T: %w", model, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package dbgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+type migratedUser struct {
+	ID   uint
+	Name string
+}
+
+func TestMigrateWithAdvisoryLock_LocksInTransaction(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock\(hashtext\(\$1\)\)`).
+		WithArgs(migrationLockName).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// The models are migrated in the same transaction, once the lock is held.
+	mock.ExpectQuery(`SELECT count\(\*\) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA\(\) AND table_name = \$1`).
+		WithArgs("migrated_users", "BASE TABLE").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(`CREATE TABLE "migrated_users" \("id" bigserial,"name" text,PRIMARY KEY \("id"\)\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	assert.NoError(t, (&DBConn{Instance: db}).MigrateWithAdvisoryLock(context.Background(), &migratedUser{}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrateWithAdvisoryLock_MigrationFailureRollsBack(t *testing.T) {
+	db, mock := newMockDB(t)
+	errDDL := errors.New("permission denied for schema public")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM information_schema.tables`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(`CREATE TABLE "migrated_users"`).WillReturnError(errDDL)
+	mock.ExpectRollback()
+
+	err := (&DBConn{Instance: db}).MigrateWithAdvisoryLock(context.Background(), &migratedUser{})
+	assert.ErrorIs(t, err, errDDL)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrateWithAdvisoryLock_LockFailureRollsBack(t *testing.T) {
+	db, mock := newMockDB(t)
+	errTimeout := errors.New("canceling statement due to lock timeout")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WillReturnError(errTimeout)
+	mock.ExpectRollback()
+
+	err := (&DBConn{Instance: db}).MigrateWithAdvisoryLock(context.Background(), &migratedUser{})
+	assert.ErrorIs(t, err, errTimeout)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrateWithAdvisoryLock_NoConnection(t *testing.T) {
+	errOpen := errors.New("open failed")
+	var nilConn *DBConn
+	assert.ErrorIs(t, nilConn.MigrateWithAdvisoryLock(context.Background()), ErrNoDatabase)
+	assert.ErrorIs(t, (&DBConn{}).MigrateWithAdvisoryLock(context.Background()), ErrNoDatabase)
+	assert.ErrorIs(t, (&DBConn{Error: errOpen}).MigrateWithAdvisoryLock(context.Background()), errOpen)
+}
+
+type migratedOrder struct {
+	ID     uint
+	UserID uint
+}
+
+func TestMigrateWithProgress_ReportsEachModel(t *testing.T) {
+	saveAndRestoreConn(t)
+	db, mock := newMockDB(t)
+	errDDL := errors.New("permission denied for schema public")
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM information_schema.tables`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(`CREATE TABLE "migrated_users"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM information_schema.tables`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(`CREATE TABLE "migrated_orders"`).WillReturnError(errDDL)
+
+	var reported []interface{}
+	var errs []error
+	models := []interface{}{&migratedUser{}, &migratedOrder{}, &migratedUser{}}
+	err := MigrateWithProgress(SetFromContext(context.Background(), db), models, func(model interface{}, err error) {
+		reported = append(reported, model)
+		errs = append(errs, err)
+	})
+	assert.ErrorIs(t, err, errDDL)
+	assert.Contains(t, err.Error(), "*dbgo.migratedOrder")
+	assert.Equal(t, models[:2], reported, "stops at the first failure")
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], errDDL)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrateWithProgress_Errors(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+	assert.ErrorIs(t, MigrateWithProgress(context.Background(), []interface{}{&migratedUser{}}, nil), ErrNoDatabase)
+
+	db, mock := newMockDB(t)
+	ctx, cancel := context.WithCancel(SetFromContext(context.Background(), db))
+	cancel()
+	var reported error
+	err := MigrateWithProgress(ctx, []interface{}{&migratedUser{}}, func(_ interface{}, err error) { reported = err })
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, reported, context.Canceled)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,89 @@
+package dbgo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// paramGuardPlugin rejects statements with more than max bind parameters (Config.MaxQueryParams). It is
+// installed by getConnection when MaxQueryParams is set.
+type paramGuardPlugin struct {
+	max int
+}
+
+func (paramGuardPlugin) Name() string {
+	return "dbgo:max_query_params"
+}
+
+// Initialize wraps the callbacks that execute statements. GORM builds the SQL inside them, so the parameters
+// are counted by the pool the statement runs on (see paramGuardPool) rather than in a before callback.
+func (p paramGuardPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, c := range []struct {
+		get     func(string) func(*gorm.DB)
+		replace func(string, func(*gorm.DB)) error
+		name    string
+	}{
+		{cb.Create().Get, cb.Create().Replace, "gorm:create"},
+		{cb.Query().Get, cb.Query().Replace, "gorm:query"},
+		{cb.Update().Get, cb.Update().Replace, "gorm:update"},
+		{cb.Delete().Get, cb.Delete().Replace, "gorm:delete"},
+		{cb.Row().Get, cb.Row().Replace, "gorm:row"},
+		{cb.Raw().Get, cb.Raw().Replace, "gorm:raw"},
+	} {
+		next := c.get(c.name)
+		if next == nil {
+			continue
+		}
+		if err := c.replace(c.name, p.guard(next)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// guard runs next with the statement's pool wrapped in a paramGuardPool. The pool is restored afterwards:
+// later callbacks (e.g. committing GORM's implicit transaction) rely on its concrete type.
+func (p paramGuardPlugin) guard(next func(*gorm.DB)) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		pool := db.Statement.ConnPool
+		if pool == nil {
+			next(db)
+			return
+		}
+		db.Statement.ConnPool = paramGuardPool{ConnPool: pool, max: p.max}
+		defer func() { db.Statement.ConnPool = pool }()
+		next(db)
+	}
+}
+
+// paramGuardPool fails ExecContext and QueryContext calls with more than max arguments. QueryRowContext (used
+// by Row) cannot return an error of its own and is passed through.
+type paramGuardPool struct {
+	gorm.ConnPool
+	max int
+}
+
+func (p paramGuardPool) check(args []interface{}) error {
+	if len(args) > p.max {
+		return fmt.Errorf("%w: %d (max %d)", ErrTooManyParameters, len(args), p.max)
+	}
+	return nil
+}
+
+func (p paramGuardPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if err := p.check(args); err != nil {
+		return nil, err
+	}
+	return p.ConnPool.ExecContext(ctx, query, args...)
+}
+
+func (p paramGuardPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if err := p.check(args); err != nil {
+		return nil, err
+	}
+	return p.ConnPool.QueryContext(ctx, query, args...)
+}
@@ -0,0 +1,34 @@
+package dbgo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParamGuardPlugin(t *testing.T) {
+	db, mock := newMockDB(t)
+	assert.NoError(t, db.Use(paramGuardPlugin{max: 3}))
+
+	mock.ExpectQuery(`SELECT \* FROM "commented_users" WHERE id IN \(\$1,\$2,\$3\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	var users []commentedUser
+	assert.NoError(t, db.Where("id IN ?", []int{1, 2, 3}).Find(&users).Error)
+
+	err := db.Where("id IN ?", []int{1, 2, 3, 4}).Find(&users).Error
+	assert.ErrorIs(t, err, ErrTooManyParameters)
+	assert.EqualError(t, err, "dbgo: too many query parameters: 4 (max 3)")
+
+	_, err = Exec(SetFromContext(context.Background(), db), "DELETE FROM users WHERE id IN ?", []int{1, 2, 3, 4})
+	assert.ErrorIs(t, err, ErrTooManyParameters)
+
+	// The implicit transaction around a create is still rolled back on its own connection.
+	err = db.Create(&[]commentedUser{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}).Error
+	assert.ErrorIs(t, err, ErrTooManyParameters)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,264 @@
+package dbgo
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// callbacksPluginName is the name under which callbacksPlugin is registered in gorm.Config.Plugins.
+const callbacksPluginName = "dbgo:callbacks"
+
+// callbacksPlugin registers the GORM callbacks dbgo relies on (e.g. write tracking and failed statements inside WithTransaction,
+// default scopes, query comments, mapping of cancelled statements' errors, MaybeTransaction).
+// It is installed by getConnection; DBs created elsewhere can install it with db.Use(callbacksPlugin{}).
+type callbacksPlugin struct{}
+
+func (callbacksPlugin) Name() string {
+	return callbacksPluginName
+}
+
+func (callbacksPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("dbgo:track_write", trackWrite); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("dbgo:track_write", trackWrite); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("dbgo:track_write", trackWrite); err != nil {
+		return err
+	}
+	if err := cb.Raw().Before("gorm:raw").Register("dbgo:track_write", trackRawWrite); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("dbgo:track_write", trackPresetWrite); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("dbgo:track_write", trackPresetWrite); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("dbgo:scopes", applyScopes); err != nil {
+		return err
+	}
+	if err := registerLazyTransactions(db); err != nil {
+		return err
+	}
+	if err := registerWrittenTables(db); err != nil {
+		return err
+	}
+	if err := registerQueryComments(db); err != nil {
+		return err
+	}
+	if err := registerFailedStatements(db); err != nil {
+		return err
+	}
+	if err := registerContextErrors(db); err != nil {
+		return err
+	}
+	return registerQueryDeadlines(db)
+}
+
+// registerFailedStatements registers the callbacks recording the last failed statement of a transaction
+// (see Config.LogRollbackSQL).
+func registerFailedStatements(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().After("gorm:create").Register("dbgo:failed_statement", recordFailedStatement); err != nil {
+		return err
+	}
+	if err := cb.Query().After("gorm:query").Register("dbgo:failed_statement", recordFailedStatement); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register("dbgo:failed_statement", recordFailedStatement); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:delete").Register("dbgo:failed_statement", recordFailedStatement); err != nil {
+		return err
+	}
+	if err := cb.Row().After("gorm:row").Register("dbgo:failed_statement", recordFailedStatement); err != nil {
+		return err
+	}
+	return cb.Raw().After("gorm:raw").Register("dbgo:failed_statement", recordFailedStatement)
+}
+
+// registerContextErrors registers the callbacks that map errors of cancelled statements (see contextError).
+func registerContextErrors(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().After("gorm:create").Register("dbgo:context_error", mapContextError); err != nil {
+		return err
+	}
+	if err := cb.Query().After("gorm:query").Register("dbgo:context_error", mapContextError); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register("dbgo:context_error", mapContextError); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:delete").Register("dbgo:context_error", mapContextError); err != nil {
+		return err
+	}
+	if err := cb.Row().After("gorm:row").Register("dbgo:context_error", mapContextError); err != nil {
+		return err
+	}
+	return cb.Raw().After("gorm:raw").Register("dbgo:context_error", mapContextError)
+}
+
+// registerQueryComments registers the callbacks that apply WithQueryComment. They run after the
+// processors' other before callbacks, right before the statement is built.
+func registerQueryComments(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("dbgo:query_comment", commentStatement("INSERT")); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("dbgo:query_comment", commentStatement("SELECT")); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("dbgo:query_comment", commentStatement("UPDATE")); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("dbgo:query_comment", commentStatement("DELETE")); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("dbgo:query_comment", commentStatement("SELECT")); err != nil {
+		return err
+	}
+	return cb.Raw().Before("gorm:raw").Register("dbgo:query_comment", commentStatement(""))
+}
+
+// hasCallbacksPlugin reports whether callbacksPlugin is installed on db.
+func hasCallbacksPlugin(db *gorm.DB) bool {
+	if db == nil || db.Config == nil {
+		return false
+	}
+	_, ok := db.Config.Plugins[callbacksPluginName]
+	return ok
+}
+
+// trackWrite records a write statement on the transaction state carried by the statement context.
+func trackWrite(db *gorm.DB) {
+	if state := txStateFrom(db.Statement.Context); state != nil {
+		state.writes.Add(1)
+	}
+}
+
+// recordFailedStatement records the SQL of a statement that failed inside WithTransaction, or in the transaction
+// of a MaybeTransaction (without its bound values, which may hold personal data). gorm.ErrRecordNotFound is not
+// a statement failure.
+func recordFailedStatement(db *gorm.DB) {
+	if db.Error == nil || errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		return
+	}
+	sql := db.Statement.SQL.String()
+	if state := txStateFrom(db.Statement.Context); state != nil {
+		state.failedSQL.Store(&sql)
+	} else if lazy := lazyTxFrom(db.Statement.Context); lazy != nil && isTransaction(db) {
+		lazy.failedSQL.Store(&sql)
+	}
+}
+
+// trackRawWrite records raw statements (db.Exec) that are not plain reads.
+func trackRawWrite(db *gorm.DB) {
+	if !isReadOnlySQL(db.Statement.SQL.String()) {
+		trackWrite(db)
+	}
+}
+
+// trackPresetWrite records the statements of the query and row processors that run SQL given by the caller
+// (db.Raw(...).Scan, .Row, .Rows, .Find) when it is not a plain read, e.g. "INSERT ... RETURNING id". Statements
+// GORM builds from the model are reads.
+func trackPresetWrite(db *gorm.DB) {
+	if isRawWrite(db) {
+		trackWrite(db)
+	}
+}
+
+var (
+	// settingStatement matches the SET forms that only change session or transaction-local settings.
+	settingStatement = regexp.MustCompile(`^set\s+((local|session)\s+)?(role\b|time\s+zone\b|session\s+authorization\b|[a-z_][a-z0-9_.]*\s*(=|\bto\b))`)
+	// modifyingKeyword matches the keywords of data-modifying statements, anywhere in a statement.
+	modifyingKeyword = regexp.MustCompile(`\b(insert|update|delete|merge|truncate|copy|call|do)\b`)
+	// rowLockClause matches the row-locking clauses of a SELECT (FOR UPDATE, FOR NO KEY UPDATE, ...).
+	rowLockClause = regexp.MustCompile(`\bfor\s+(no\s+key\s+)?(update|share|key\s+share)\b`)
+	// functionCall matches a (possibly schema-qualified) name followed by an opening parenthesis.
+	functionCall = regexp.MustCompile(`([a-z_][a-z0-9_$]*\.)?([a-z_][a-z0-9_$]*)\s*\(`)
+)
+
+// readOnlyCallNames lists the SQL keywords that precede a parenthesis and the built-in functions known to leave
+// data untouched. A SELECT calling any other function is treated as a write: it may call a data-modifying one
+// (e.g. nextval or a user-defined function).
+var readOnlyCallNames = map[string]bool{
+	// Keywords and type names.
+	"select": true, "from": true, "where": true, "in": true, "exists": true, "values": true, "as": true, "any": true,
+	"all": true, "some": true, "and": true, "or": true, "not": true, "on": true, "using": true, "join": true,
+	"over": true, "filter": true, "within": true, "case": true, "when": true, "then": true, "else": true,
+	"array": true, "row": true, "cast": true, "interval": true, "lateral": true, "by": true, "having": true,
+	"is": true, "between": true, "like": true, "ilike": true, "distinct": true, "union": true, "intersect": true,
+	"except": true, "limit": true, "offset": true, "numeric": true, "decimal": true, "varchar": true, "char": true,
+	"character": true, "timestamp": true, "time": true, "varying": true,
+	// Built-in functions.
+	"count": true, "sum": true, "avg": true, "min": true, "max": true, "coalesce": true, "nullif": true,
+	"greatest": true, "least": true, "lower": true, "upper": true, "length": true, "char_length": true,
+	"trim": true, "btrim": true, "ltrim": true, "rtrim": true, "substring": true, "substr": true, "concat": true,
+	"concat_ws": true, "replace": true, "left": true, "right": true, "position": true, "strpos": true,
+	"split_part": true, "format": true, "abs": true, "round": true, "ceil": true, "floor": true, "trunc": true,
+	"mod": true, "power": true, "sqrt": true, "now": true, "date_trunc": true, "date_part": true, "extract": true,
+	"to_char": true, "to_date": true, "to_timestamp": true, "age": true, "date": true, "array_agg": true,
+	"string_agg": true, "json_agg": true, "jsonb_agg": true, "json_build_object": true,
+	"jsonb_build_object": true, "to_json": true, "to_jsonb": true, "row_to_json": true, "row_number": true,
+	"rank": true, "dense_rank": true, "lag": true, "lead": true, "first_value": true, "last_value": true,
+	"bool_and": true, "bool_or": true, "unnest": true, "generate_series": true, "array_length": true,
+	"cardinality": true, "md5": true, "encode": true, "decode": true, "host": true, "inet_server_addr": true,
+	"pg_is_in_recovery": true, "current_setting": true, "version": true, "stddev": true, "variance": true,
+}
+
+// isReadOnlySQL reports whether a raw statement provably leaves data untouched: a single SELECT or SHOW that
+// calls no function outside readOnlyCallNames and has no data-modifying keyword, or a SET/RESET of a session
+// setting, possibly preceded by comments. Anything else (several statements, WITH, SET CONSTRAINTS, a SELECT
+// calling an unknown function, ...) is treated as a write, so SkipEmptyCommit commits when in doubt.
+func isReadOnlySQL(sql string) bool {
+	sql, ok := skipLeadingComments(strings.ToLower(sql))
+	if !ok {
+		return false
+	}
+	sql = strings.TrimRight(sql, "; \t\r\n")
+	if strings.Contains(sql, ";") {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(sql, "select"), strings.HasPrefix(sql, "show "):
+		return !mayModifyData(sql)
+	case strings.HasPrefix(sql, "reset "):
+		return true
+	default:
+		return settingStatement.MatchString(sql)
+	}
+}
+
+// mayModifyData reports whether a lower-cased SELECT may modify data: it has a data-modifying keyword (other
+// than in a row-locking clause) or calls a function not known to be read-only.
+func mayModifyData(sql string) bool {
+	if modifyingKeyword.MatchString(rowLockClause.ReplaceAllString(sql, "")) {
+		return true
+	}
+	for _, m := range functionCall.FindAllStringSubmatch(sql, -1) {
+		if !readOnlyCallNames[m[2]] {
+			return true
+		}
+	}
+	return false
+}
+
+// skipLeadingComments trims sql and removes its leading comments, such as the ones added by WithQueryComment.
+// ok is false when a comment is not terminated.
+func skipLeadingComments(sql string) (_ string, ok bool) {
+	sql = strings.TrimSpace(sql)
+	for strings.HasPrefix(sql, "/*") {
+		end := strings.Index(sql, "*/")
+		if end < 0 {
+			return "", false
+		}
+		sql = strings.TrimSpace(sql[end+2:])
+	}
+	return sql, true
+}
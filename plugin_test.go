@@ -0,0 +1,98 @@
+package dbgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestIsReadOnlySQL(t *testing.T) {
+	assert.True(t, isReadOnlySQL("SELECT 1"))
+	assert.True(t, isReadOnlySQL("  select * from users for update"))
+	assert.True(t, isReadOnlySQL("SHOW search_path"))
+	assert.True(t, isReadOnlySQL(`SET LOCAL ROLE "reporting"`))
+	assert.True(t, isReadOnlySQL("RESET ROLE"))
+	assert.False(t, isReadOnlySQL("UPDATE users SET name = 'x'"))
+	assert.False(t, isReadOnlySQL("WITH d AS (DELETE FROM users RETURNING id) SELECT count(*) FROM d"))
+	assert.True(t, isReadOnlySQL("/* route:/users */ SELECT 1"))
+	assert.False(t, isReadOnlySQL("/* route:/users */ DELETE FROM users"))
+	assert.False(t, isReadOnlySQL("/* unterminated SELECT 1"))
+	assert.False(t, isReadOnlySQL(""))
+	assert.True(t, isReadOnlySQL("SELECT count(*), coalesce(max(id), 0) FROM users WHERE id IN ($1)"))
+	assert.True(t, isReadOnlySQL("SET statement_timeout = 0"))
+	assert.True(t, isReadOnlySQL("SET TIME ZONE 'UTC'"))
+	assert.False(t, isReadOnlySQL("SELECT nextval('orders_id_seq')"))
+	assert.False(t, isReadOnlySQL("SELECT archive_orders($1)"))
+	assert.False(t, isReadOnlySQL("SELECT 1; DELETE FROM users"))
+	assert.False(t, isReadOnlySQL("SET CONSTRAINTS ALL DEFERRED"))
+	assert.False(t, isReadOnlySQL("SETTLE"))
+}
+
+func TestCallbacksPlugin_TracksPresetRowAndQueryWrites(t *testing.T) {
+	db, mock := newMockDB(t)
+	assert.NoError(t, db.Use(callbacksPlugin{}))
+
+	mock.ExpectQuery("INSERT INTO users").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery("INSERT INTO users").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+
+	state := &txState{}
+	ctx := context.WithValue(context.Background(), txStateKey{}, state)
+
+	var id int
+	assert.NoError(t, db.WithContext(ctx).Raw("INSERT INTO users (name) VALUES (?) RETURNING id", "a").Row().Scan(&id))
+	assert.Equal(t, int32(1), state.writes.Load())
+
+	var ids []int
+	assert.NoError(t, db.WithContext(ctx).Raw("INSERT INTO users (name) VALUES (?) RETURNING id", "b").Find(&ids).Error)
+	assert.Equal(t, int32(2), state.writes.Load())
+
+	var n int
+	assert.NoError(t, db.WithContext(ctx).Raw("SELECT 1").Row().Scan(&n))
+	assert.Equal(t, int32(2), state.writes.Load())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCallbacksPlugin_TracksWritesInTransactionState(t *testing.T) {
+	db, mock := newMockDB(t)
+	assert.NoError(t, db.Use(callbacksPlugin{}))
+	assert.True(t, hasCallbacksPlugin(db))
+
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	state := &txState{}
+	ctx := context.WithValue(context.Background(), txStateKey{}, state)
+
+	var n int
+	assert.NoError(t, db.WithContext(ctx).Raw("SELECT 1").Scan(&n).Error)
+	assert.Equal(t, int32(0), state.writes.Load())
+
+	assert.NoError(t, db.WithContext(ctx).Exec("UPDATE users SET name = ?", "x").Error)
+	assert.Equal(t, int32(1), state.writes.Load())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCallbacksPlugin_RecordsFailedStatement(t *testing.T) {
+	db, mock := newMockDB(t)
+	assert.NoError(t, db.Use(callbacksPlugin{}))
+
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectExec("INSERT INTO orders").WillReturnError(errors.New(`duplicate key value violates unique constraint "orders_pkey"`))
+
+	state := &txState{}
+	ctx := context.WithValue(context.Background(), txStateKey{}, state)
+
+	var user commentedUser
+	assert.ErrorIs(t, db.WithContext(ctx).First(&user).Error, gorm.ErrRecordNotFound)
+	assert.Nil(t, state.failedSQL.Load(), "record not found is not a failure")
+
+	assert.Error(t, db.WithContext(ctx).Exec("INSERT INTO orders (id) VALUES (?)", 1).Error)
+	if sql := state.failedSQL.Load(); assert.NotNil(t, sql) {
+		assert.Equal(t, "INSERT INTO orders (id) VALUES ($1)", *sql, "bound values are not recorded")
+	}
+}
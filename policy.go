@@ -0,0 +1,47 @@
+package dbgo
+
+import (
+	"math/rand/v2"
+
+	"gorm.io/gorm"
+)
+
+// WeightedPolicy is a dbresolver.Policy that picks a connection pool with probability proportional to its weight.
+// Weights are aligned by index with the pools dbresolver passes to Resolve, which follow the order of
+// Config.ReplicasDSN. getConnection installs it automatically when Config.ReplicaWeights is set.
+type WeightedPolicy struct {
+	weights []int
+}
+
+// NewWeightedPolicy returns a WeightedPolicy for the given weights (e.g. []int{70, 30}).
+// Pools without a weight, or with a non-positive one, are never chosen unless all weights are non-positive,
+// in which case the choice falls back to uniform random.
+func NewWeightedPolicy(weights []int) *WeightedPolicy {
+	return &WeightedPolicy{weights: append([]int(nil), weights...)}
+}
+
+// Resolve implements dbresolver.Policy.
+func (p *WeightedPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	n := min(len(p.weights), len(connPools))
+	total := 0
+	for _, w := range p.weights[:n] {
+		if w > 0 {
+			total += w
+		}
+	}
+	if total == 0 {
+		return connPools[rand.IntN(len(connPools))]
+	}
+
+	r := rand.IntN(total)
+	for i, w := range p.weights[:n] {
+		if w <= 0 {
+			continue
+		}
+		if r < w {
+			return connPools[i]
+		}
+		r -= w
+	}
+	return connPools[n-1]
+}
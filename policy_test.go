@@ -0,0 +1,66 @@
+package dbgo
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func testConnPools(n int) []gorm.ConnPool {
+	pools := make([]gorm.ConnPool, n)
+	for i := range pools {
+		pools[i] = &sql.DB{}
+	}
+	return pools
+}
+
+func TestWeightedPolicy_ZeroWeightNeverChosen(t *testing.T) {
+	pools := testConnPools(3)
+	policy := NewWeightedPolicy([]int{0, 5, 0})
+
+	for i := 0; i < 100; i++ {
+		assert.Same(t, pools[1], policy.Resolve(pools))
+	}
+}
+
+func TestWeightedPolicy_Distribution(t *testing.T) {
+	pools := testConnPools(2)
+	policy := NewWeightedPolicy([]int{70, 30})
+
+	const n = 10000
+	first := 0
+	for i := 0; i < n; i++ {
+		if policy.Resolve(pools) == pools[0] {
+			first++
+		}
+	}
+	assert.InDelta(t, 0.7, float64(first)/n, 0.05)
+}
+
+func TestWeightedPolicy_AllZero_FallsBackToRandom(t *testing.T) {
+	pools := testConnPools(2)
+	policy := NewWeightedPolicy([]int{0, 0})
+
+	assert.NotPanics(t, func() {
+		assert.Contains(t, pools, policy.Resolve(pools))
+	})
+}
+
+func TestConfig_Validate_ReplicaWeights(t *testing.T) {
+	base := Config{PrimaryDSN: "host=primary", ReplicasDSN: []string{"host=r1", "host=r2"}}
+
+	cfg := base
+	cfg.ReplicaWeights = []int{70, 30}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.ReplicaWeights = []int{100}
+	assert.ErrorIs(t, cfg.Validate(), ErrInvalidConfig)
+
+	cfg.ReplicaWeights = []int{-1, 2}
+	assert.ErrorIs(t, cfg.Validate(), ErrInvalidConfig)
+
+	cfg.ReplicaWeights = []int{0, 0}
+	assert.ErrorIs(t, cfg.Validate(), ErrInvalidConfig)
+}
@@ -0,0 +1,85 @@
+package dbgo
+
+import (
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// preparedStmtPluginName is the name under which preparedStmtPlugin is registered in gorm.Config.Plugins.
+const preparedStmtPluginName = "dbgo:prepare_stmt"
+
+// preparedStmtPlugin enables prepared statements on only the primary or only the replicas.
+// dbresolver can only toggle prepared statements for all sources at once, so when Config.PrepareStmt and
+// Config.ReplicaPrepareStmt differ the connection is opened without them and this plugin wraps the pool
+// that dbresolver picked for each statement.
+type preparedStmtPlugin struct {
+	// primaryOnly selects which side prepares: the primary (true) or the replicas (false).
+	primaryOnly bool
+
+	mu      sync.Mutex
+	primary gorm.ConnPool
+	stores  map[gorm.ConnPool]*gorm.PreparedStmtDB
+}
+
+func newPreparedStmtPlugin(primaryOnly bool) *preparedStmtPlugin {
+	return &preparedStmtPlugin{primaryOnly: primaryOnly, stores: map[gorm.ConnPool]*gorm.PreparedStmtDB{}}
+}
+
+func (*preparedStmtPlugin) Name() string {
+	return preparedStmtPluginName
+}
+
+func (p *preparedStmtPlugin) Initialize(db *gorm.DB) error {
+	p.primary = db.ConnPool
+
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("dbgo:prepare_stmt", p.wrap); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("dbgo:prepare_stmt", p.wrap); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("dbgo:prepare_stmt", p.wrap); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("dbgo:prepare_stmt", p.wrap); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("dbgo:prepare_stmt", p.wrap); err != nil {
+		return err
+	}
+	return cb.Raw().Before("gorm:raw").Register("dbgo:prepare_stmt", p.wrap)
+}
+
+// wrap replaces the statement's pool with its prepared-statement store when that side should prepare.
+// Transactions are left alone: WithTransaction wraps the pool before Begin (see prepareConnPool).
+func (p *preparedStmtPlugin) wrap(db *gorm.DB) {
+	if db.Error != nil || isTransaction(db) {
+		return
+	}
+	pool := db.Statement.ConnPool
+	if _, ok := pool.(*gorm.PreparedStmtDB); ok || pool == nil {
+		return
+	}
+	if (pool == p.primary) != p.primaryOnly {
+		return
+	}
+
+	p.mu.Lock()
+	store, ok := p.stores[pool]
+	if !ok {
+		store = gorm.NewPreparedStmtDB(pool, db.PrepareStmtMaxSize, db.PrepareStmtTTL)
+		p.stores[pool] = store
+	}
+	p.mu.Unlock()
+	db.Statement.ConnPool = store
+}
+
+// prepareConnPool applies preparedStmtPlugin (when installed) to db's current pool, so a transaction
+// begun on it uses prepared statements when its side is configured to.
+func prepareConnPool(db *gorm.DB) {
+	if p, ok := db.Config.Plugins[preparedStmtPluginName].(*preparedStmtPlugin); ok {
+		p.wrap(db)
+	}
+}
@@ -0,0 +1,114 @@
+package dbgo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+func TestConfig_prepareStmt(t *testing.T) {
+	on, off := true, false
+	cases := []struct {
+		name             string
+		cfg              Config
+		primary, replica bool
+	}{
+		{"defaults", Config{}, true, true},
+		{"replicas follow primary", Config{PrepareStmt: &off}, false, false},
+		{"replicas only", Config{PrepareStmt: &off, ReplicaPrepareStmt: &on}, false, true},
+		{"primary only", Config{ReplicaPrepareStmt: &off}, true, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			primary, replica := tc.cfg.prepareStmt()
+			assert.Equal(t, tc.primary, primary)
+			assert.Equal(t, tc.replica, replica)
+		})
+	}
+}
+
+func TestGormConfig_PrepareStmt(t *testing.T) {
+	off := false
+	assert.True(t, gormConfig(Config{}).PrepareStmt)
+	assert.False(t, gormConfig(Config{PrepareStmt: &off}).PrepareStmt)
+	// Without replicas a replica-only override has nothing to apply to.
+	assert.True(t, gormConfig(Config{ReplicaPrepareStmt: &off}).PrepareStmt)
+	// Split settings are handled by preparedStmtPlugin, so the global flag is off.
+	assert.False(t, gormConfig(Config{ReplicasDSN: []string{"replica"}, ReplicaPrepareStmt: &off}).PrepareStmt)
+}
+
+// newSplitPrepareDB returns a primary with one replica and preparedStmtPlugin installed.
+func newSplitPrepareDB(t *testing.T, primaryOnly bool) (*gorm.DB, sqlmock.Sqlmock, sqlmock.Sqlmock) {
+	t.Helper()
+	db, primary := newMockDB(t)
+	replicaDB, replica, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { replicaDB.Close() })
+
+	assert.NoError(t, db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: []gorm.Dialector{postgres.New(postgres.Config{Conn: replicaDB})},
+	})))
+	assert.NoError(t, db.Use(newPreparedStmtPlugin(primaryOnly)))
+	return db, primary, replica
+}
+
+func TestPreparedStmtPlugin_PrimaryOnly(t *testing.T) {
+	db, primary, replica := newSplitPrepareDB(t, true)
+
+	primary.ExpectPrepare(`UPDATE users`).ExpectExec().
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	replica.ExpectQuery(`SELECT count`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	assert.NoError(t, db.Exec("UPDATE users SET active = true").Error)
+	var count int64
+	assert.NoError(t, db.Raw("SELECT count(*) FROM users").Scan(&count).Error)
+
+	assert.NoError(t, primary.ExpectationsWereMet())
+	assert.NoError(t, replica.ExpectationsWereMet())
+}
+
+func TestPreparedStmtPlugin_ReplicasOnly(t *testing.T) {
+	db, primary, replica := newSplitPrepareDB(t, false)
+
+	primary.ExpectExec(`UPDATE users`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	replica.ExpectPrepare(`SELECT count`).ExpectQuery().
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	assert.NoError(t, db.Exec("UPDATE users SET active = true").Error)
+	var count int64
+	assert.NoError(t, db.Raw("SELECT count(*) FROM users").Scan(&count).Error)
+
+	assert.NoError(t, primary.ExpectationsWereMet())
+	assert.NoError(t, replica.ExpectationsWereMet())
+}
+
+func TestWithTransaction_PrimaryOnlyPrepareStmt(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, primary, replica := newSplitPrepareDB(t, true)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	primary.ExpectBegin()
+	// database/sql re-prepares the cached statement on the transaction's connection (Tx.StmtContext).
+	primary.ExpectPrepare(`UPDATE users`)
+	primary.ExpectPrepare(`UPDATE users`).ExpectExec().
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	primary.ExpectCommit()
+
+	err := WithTransaction(context.Background(), func(ctx context.Context) error {
+		return GetFromContext(ctx).Exec("UPDATE users SET active = true").Error
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, primary.ExpectationsWereMet())
+	assert.NoError(t, replica.ExpectationsWereMet())
+}
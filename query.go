@@ -0,0 +1,305 @@
+package dbgo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Exec runs a raw SQL statement on the DB from ctx (or the default singleton) and returns the number of rows affected.
+// Inside WithTransaction it runs on the context transaction, and it is traced like any other GORM
+// statement when tracing is enabled. Returns ErrNoDatabase when no connection is available.
+// Example:
+//
+//	n, err := dbgo.Exec(ctx, "UPDATE jobs SET state = ? WHERE state = ?", "queued", "stale")
+func Exec(ctx context.Context, sql string, args ...interface{}) (int64, error) {
+	start := time.Now()
+	db, err := dbFromContext(ctx)
+	if err != nil {
+		return 0, wrapError(GetActiveConfig(), "Exec", start, nil, err)
+	}
+	result := db.Exec(sql, args...)
+	return result.RowsAffected, wrapError(GetActiveConfig(), "Exec", start, db, result.Error)
+}
+
+// Raw runs a raw SQL query on the DB from ctx (or the default singleton) and scans the result into dest.
+// Like Exec, it honors the context transaction and tracing. Returns ErrNoDatabase when no connection is available.
+// Example:
+//
+//	var total int64
+//	err := dbgo.Raw(ctx, &total, "SELECT count(*) FROM users WHERE active = ?", true)
+func Raw(ctx context.Context, dest interface{}, sql string, args ...interface{}) error {
+	start := time.Now()
+	db, err := dbFromContext(ctx)
+	if err != nil {
+		return wrapError(GetActiveConfig(), "Raw", start, nil, err)
+	}
+	return wrapError(GetActiveConfig(), "Raw", start, db, db.Raw(sql, args...).Scan(dest).Error)
+}
+
+// Find loads the rows of T's table matching conds (inline conditions, as in GORM's Find: a primary key, a slice
+// of keys, a struct, or a SQL fragment followed by its arguments) using the DB from ctx (or the default
+// singleton), so repositories don't need a destination variable per query. Like Raw, it honors the context
+// transaction and tracing. No match returns an empty slice and no error. Returns ErrNoDatabase when no
+// connection is available.
+// Example:
+//
+//	users, err := dbgo.Find[User](ctx, "active = ? AND created_at > ?", true, since)
+func Find[T any](ctx context.Context, conds ...interface{}) ([]T, error) {
+	start := time.Now()
+	db, err := dbFromContext(ctx)
+	if err != nil {
+		return nil, wrapError(GetActiveConfig(), "Find", start, nil, err)
+	}
+	var results []T
+	if err := db.Find(&results, conds...).Error; err != nil {
+		return nil, wrapError(GetActiveConfig(), "Find", start, db, err)
+	}
+	return results, nil
+}
+
+// First is like Find, but loads the first matching row ordered by primary key. No match returns the zero T and
+// gorm.ErrRecordNotFound.
+// Example:
+//
+//	user, err := dbgo.First[User](ctx, id)
+//	if errors.Is(err, gorm.ErrRecordNotFound) {
+//	    return nil, ErrUserNotFound
+//	}
+func First[T any](ctx context.Context, conds ...interface{}) (T, error) {
+	start := time.Now()
+	var result T
+	db, err := dbFromContext(ctx)
+	if err != nil {
+		return result, wrapError(GetActiveConfig(), "First", start, nil, err)
+	}
+	if err := db.First(&result, conds...).Error; err != nil {
+		var zero T
+		return zero, wrapError(GetActiveConfig(), "First", start, db, err)
+	}
+	return result, nil
+}
+
+// QueryMaps runs a raw SQL query on the DB from ctx (or the default singleton) and returns each row as a map
+// from column name to value, for ad-hoc reporting queries where defining a struct is overkill. Values are the
+// driver's types (e.g. int64, string, time.Time, nil for NULL). Like Raw, it honors the context transaction and
+// tracing. Returns ErrNoDatabase when no connection is available.
+// Example:
+//
+//	rows, err := dbgo.QueryMaps(ctx, "SELECT state, count(*) AS n FROM jobs GROUP BY state")
+func QueryMaps(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error) {
+	start := time.Now()
+	db, err := dbFromContext(ctx)
+	if err != nil {
+		return nil, wrapError(GetActiveConfig(), "QueryMaps", start, nil, err)
+	}
+	var rows []map[string]interface{}
+	if err := db.Raw(sql, args...).Scan(&rows).Error; err != nil {
+		return nil, wrapError(GetActiveConfig(), "QueryMaps", start, db, err)
+	}
+	return rows, nil
+}
+
+// QueryRows runs a raw SQL query on the DB from ctx (or the default singleton) and calls fn for each row of
+// the result, positioned on that row, so large results (exports) are streamed instead of loaded into memory;
+// fn typically calls rows.Scan. Iteration stops at the first error from fn, which is returned as-is. Like Raw,
+// it honors the context transaction and tracing (the span covers the query, not the iteration). Inside a
+// transaction, the transaction's connection is busy until QueryRows returns, so fn must not run statements on it.
+// Returns ErrNoDatabase when no connection is available.
+// Example:
+//
+//	err := dbgo.QueryRows(ctx, func(rows *sql.Rows) error {
+//	    var id int64
+//	    var email string
+//	    if err := rows.Scan(&id, &email); err != nil {
+//	        return err
+//	    }
+//	    return csvWriter.Write([]string{strconv.FormatInt(id, 10), email})
+//	}, "SELECT id, email FROM users WHERE created_at > ?", since)
+func QueryRows(ctx context.Context, fn func(*sql.Rows) error, sql string, args ...interface{}) error {
+	start := time.Now()
+	db, err := dbFromContext(ctx)
+	if err != nil {
+		return wrapError(GetActiveConfig(), "QueryRows", start, nil, err)
+	}
+	rows, err := db.Raw(sql, args...).Rows()
+	if err != nil {
+		return wrapError(GetActiveConfig(), "QueryRows", start, db, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return wrapError(GetActiveConfig(), "QueryRows", start, db, contextError(ctx, err))
+	}
+	return nil
+}
+
+// Stream runs a raw SQL query on the DB from ctx (or the default singleton) and sends each row, scanned into a T
+// (a struct or map scanned like GORM's ScanRows, matching columns by name, or any other type holding the single
+// column), on the first channel, so pipelines process large tables without loading them into memory. Both channels are closed
+// when the rows are exhausted; the error channel receives at most one error first (a query, scan or iteration
+// error, or ctx.Err() when ctx is done before the consumer takes the next row). Drain the rows channel, or cancel
+// ctx, so the query's connection is released. Like QueryRows, it honors the context transaction and tracing, and
+// the transaction's connection is busy until the rows channel is closed. Returns ErrNoDatabase on the error
+// channel when no connection is available.
+// Example:
+//
+//	users, errs := dbgo.Stream[User](ctx, "SELECT * FROM users WHERE created_at > ?", since)
+//	for u := range users {
+//	    process(u)
+//	}
+//	if err := <-errs; err != nil {
+//	    return err
+//	}
+func Stream[T any](ctx context.Context, sql string, args ...interface{}) (<-chan T, <-chan error) {
+	out := make(chan T)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(out)
+		start := time.Now()
+		db, err := dbFromContext(ctx)
+		if err != nil {
+			errs <- wrapError(GetActiveConfig(), "Stream", start, nil, err)
+			return
+		}
+		rows, err := db.Raw(sql, args...).Rows()
+		if err != nil {
+			errs <- wrapError(GetActiveConfig(), "Stream", start, db, err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var row T
+			if err := scanRow(db, rows, &row); err != nil {
+				errs <- wrapError(GetActiveConfig(), "Stream", start, db, err)
+				return
+			}
+			select {
+			case out <- row:
+			case <-ctx.Done():
+				errs <- wrapError(GetActiveConfig(), "Stream", start, db, ctx.Err())
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			errs <- wrapError(GetActiveConfig(), "Stream", start, db, contextError(ctx, err))
+		}
+	}()
+	return out, errs
+}
+
+// scanRow scans the current row into dest. Structs and maps are scanned by GORM; other types, which GORM's Scan
+// would fill from every remaining row, take the single column directly.
+func scanRow(db *gorm.DB, rows *sql.Rows, dest interface{}) error {
+	switch dest.(type) {
+	case sql.Scanner, *time.Time:
+		return rows.Scan(dest)
+	}
+	switch reflect.TypeOf(dest).Elem().Kind() {
+	case reflect.Struct, reflect.Map:
+		return db.ScanRows(rows, dest)
+	}
+	return rows.Scan(dest)
+}
+
+// DeleteInBatches deletes the rows of model's table matching where/args in batches of at most batchSize rows,
+// so a large cleanup does not hold locks on (or write WAL for) the whole set in a single statement.
+// It loops until a batch deletes no rows, and stops early with ctx.Err() when ctx is cancelled; the returned
+// count covers the batches already deleted. Outside a transaction each batch commits on its own; inside
+// WithTransaction every batch runs on the context transaction. model only selects the table (pass a zero value
+// such as &User{}); models with gorm.DeletedAt are soft-deleted, as with GORM's Delete.
+// Returns gorm.ErrMissingWhereClause when where is empty and ErrNoDatabase when no connection is available.
+// Example:
+//
+//	n, err := dbgo.DeleteInBatches(ctx, &Event{}, "created_at < ?", []interface{}{cutoff}, 5000)
+func DeleteInBatches(ctx context.Context, model interface{}, where string, args []interface{}, batchSize int) (int64, error) {
+	if where == "" {
+		return 0, gorm.ErrMissingWhereClause
+	}
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("%w: DeleteInBatches batchSize must be positive (got %d)", ErrInvalidArgument, batchSize)
+	}
+	start := time.Now()
+	db, err := dbFromContext(ctx)
+	if err != nil {
+		return 0, wrapError(GetActiveConfig(), "DeleteInBatches", start, nil, err)
+	}
+
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, wrapError(GetActiveConfig(), "DeleteInBatches", start, db, err)
+		}
+		// ctid identifies the physical row, so the batch works for tables without a primary key too.
+		batch := db.Session(&gorm.Session{NewDB: true}).
+			Model(model).
+			Select("ctid").
+			Where(where, args...).
+			Limit(batchSize)
+		result := db.Where("ctid IN (?)", batch).Delete(model)
+		if result.Error != nil {
+			return total, wrapError(GetActiveConfig(), "DeleteInBatches", start, db, result.Error)
+		}
+		total += result.RowsAffected
+		if result.RowsAffected == 0 {
+			return total, nil
+		}
+	}
+}
+
+// HardDelete permanently deletes the rows of model's table matching conds (inline conditions, as in GORM's
+// Delete: a primary key, a slice of keys, or a SQL fragment followed by its arguments) using the DB from ctx (or
+// the default singleton). Unlike GORM's Delete, models with gorm.DeletedAt are deleted rather than soft-deleted,
+// for erasure requests that must remove the data. Like Exec, it honors the context transaction and tracing.
+// Without conds the rows are selected by model's primary key; a model without one returns
+// gorm.ErrMissingWhereClause instead of deleting the whole table. Returns ErrNoDatabase when no connection is
+// available.
+// Example:
+//
+//	n, err := dbgo.HardDelete(ctx, &Customer{}, "id = ?", customerID)
+func HardDelete(ctx context.Context, model interface{}, conds ...interface{}) (int64, error) {
+	start := time.Now()
+	db, err := dbFromContext(ctx)
+	if err != nil {
+		return 0, wrapError(GetActiveConfig(), "HardDelete", start, nil, err)
+	}
+	result := db.Unscoped().Delete(model, conds...)
+	return result.RowsAffected, wrapError(GetActiveConfig(), "HardDelete", start, db, result.Error)
+}
+
+// Upsert inserts value (a model or a slice of models, inserted in batches of Config.CreateBatchSize) using the
+// DB from ctx (or the default singleton), updating updateColumns of the existing row when a row conflicts on
+// conflictColumns, i.e. INSERT ... ON CONFLICT (conflictColumns) DO UPDATE SET col = excluded.col. With no
+// updateColumns conflicting rows are left unchanged (DO NOTHING). Columns are database column names. Like Exec,
+// it honors the context transaction and tracing. Returns ErrNoDatabase when no connection is available.
+// Example:
+//
+//	err := dbgo.Upsert(ctx, &prices, []string{"sku"}, []string{"amount", "updated_at"})
+func Upsert(ctx context.Context, value interface{}, conflictColumns []string, updateColumns []string) error {
+	if len(conflictColumns) == 0 && len(updateColumns) > 0 {
+		return fmt.Errorf("%w: Upsert requires conflictColumns to update conflicting rows", ErrInvalidArgument)
+	}
+	start := time.Now()
+	db, err := dbFromContext(ctx)
+	if err != nil {
+		return wrapError(GetActiveConfig(), "Upsert", start, nil, err)
+	}
+	onConflict := clause.OnConflict{DoNothing: len(updateColumns) == 0}
+	for _, c := range conflictColumns {
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: c})
+	}
+	if len(updateColumns) > 0 {
+		onConflict.DoUpdates = clause.AssignmentColumns(updateColumns)
+	}
+	return wrapError(GetActiveConfig(), "Upsert", start, db, db.Clauses(onConflict).Create(value).Error)
+}
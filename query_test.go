@@ -0,0 +1,334 @@
+package dbgo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestExec_UsesContextDB(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectExec(`UPDATE jobs SET state = \$1`).
+		WithArgs("queued").
+		WillReturnResult(sqlmock.NewResult(0, 4))
+
+	ctx := SetFromContext(context.Background(), db)
+	n, err := Exec(ctx, "UPDATE jobs SET state = ?", "queued")
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRaw_ScansIntoDest(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+
+	ctx := SetFromContext(context.Background(), db)
+	var total int64
+	err := Raw(ctx, &total, "SELECT count(*) FROM users")
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), total)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestQueryMaps_ScansRowsIntoMaps(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT state, count\(\*\) AS n FROM jobs WHERE queue = \$1`).
+		WithArgs("default").
+		WillReturnRows(sqlmock.NewRows([]string{"state", "n"}).AddRow("queued", int64(3)).AddRow("done", int64(9)))
+
+	ctx := SetFromContext(context.Background(), db)
+	rows, err := QueryMaps(ctx, "SELECT state, count(*) AS n FROM jobs WHERE queue = ? GROUP BY state", "default")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"state": "queued", "n": int64(3)},
+		{"state": "done", "n": int64(9)},
+	}, rows)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFind_ReturnsTypedRows(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "batch_events" WHERE status = \$1`).
+		WithArgs("failed").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "failed").AddRow(4, "failed"))
+
+	ctx := SetFromContext(context.Background(), db)
+	events, err := Find[batchEvent](ctx, "status = ?", "failed")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []batchEvent{{ID: 1, Status: "failed"}, {ID: 4, Status: "failed"}}, events)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFirst_ReturnsTypedRow(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "batch_events" WHERE "batch_events"."id" = \$1 ORDER BY "batch_events"."id" LIMIT \$2`).
+		WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(7, "done"))
+	mock.ExpectQuery(`SELECT \* FROM "batch_events"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}))
+
+	ctx := SetFromContext(context.Background(), db)
+	event, err := First[batchEvent](ctx, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, batchEvent{ID: 7, Status: "done"}, event)
+
+	event, err = First[batchEvent](ctx, 8)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	assert.Zero(t, event)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestQueryRows_StreamsRows(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT id, email FROM users WHERE id > \$1`).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(11, "a@x").AddRow(12, "b@x").AddRow(13, "c@x"))
+
+	ctx := SetFromContext(context.Background(), db)
+	var emails []string
+	err := QueryRows(ctx, func(rows *sql.Rows) error {
+		var id int
+		var email string
+		if err := rows.Scan(&id, &email); err != nil {
+			return err
+		}
+		emails = append(emails, email)
+		return nil
+	}, "SELECT id, email FROM users WHERE id > ?", 10)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a@x", "b@x", "c@x"}, emails)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestQueryRows_StopsAtCallbackError(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT id FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3)).
+		RowsWillBeClosed()
+
+	ctx := SetFromContext(context.Background(), db)
+	stop := errors.New("disk full")
+	calls := 0
+	err := QueryRows(ctx, func(rows *sql.Rows) error {
+		calls++
+		return stop
+	}, "SELECT id FROM users")
+
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+	assert.NoError(t, mock.ExpectationsWereMet(), "rows are closed")
+}
+
+func TestStream_SendsRows(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT id, email FROM users WHERE id > \$1`).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(11, "a@x").AddRow(12, "b@x"))
+
+	type user struct {
+		ID    int
+		Email string
+	}
+	ctx := SetFromContext(context.Background(), db)
+	rows, errs := Stream[user](ctx, "SELECT id, email FROM users WHERE id > ?", 10)
+	var got []user
+	for u := range rows {
+		got = append(got, u)
+	}
+
+	assert.NoError(t, <-errs)
+	assert.Equal(t, []user{{11, "a@x"}, {12, "b@x"}}, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStream_StopsWhenContextDone(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT id FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3)).
+		RowsWillBeClosed()
+
+	ctx, cancel := context.WithCancel(SetFromContext(context.Background(), db))
+	defer cancel()
+	rows, errs := Stream[int](ctx, "SELECT id FROM users")
+	assert.Equal(t, 1, <-rows)
+	cancel()
+
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	for range rows {
+	}
+	assert.NoError(t, mock.ExpectationsWereMet(), "rows are closed")
+}
+
+func TestStream_NoDatabase(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	rows, errs := Stream[int](context.Background(), "SELECT 1")
+	assert.ErrorIs(t, <-errs, ErrNoDatabase)
+	_, open := <-rows
+	assert.False(t, open)
+}
+
+func TestExec_InsideTransaction_UsesTransaction(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM sessions").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := Exec(ctx, "DELETE FROM sessions")
+		return err
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExecAndRaw_NoDB_ReturnErrNoDatabase(t *testing.T) {
+	saveAndRestoreConn(t)
+	connMu.Lock()
+	conn = DBConn{}
+	connMu.Unlock()
+
+	_, err := Exec(context.Background(), "SELECT 1")
+	assert.ErrorIs(t, err, ErrNoDatabase)
+
+	var n int
+	err = Raw(context.Background(), &n, "SELECT 1")
+	assert.ErrorIs(t, err, ErrNoDatabase)
+
+	_, err = QueryMaps(context.Background(), "SELECT 1")
+	assert.ErrorIs(t, err, ErrNoDatabase)
+
+	err = QueryRows(context.Background(), func(*sql.Rows) error { return nil }, "SELECT 1")
+	assert.ErrorIs(t, err, ErrNoDatabase)
+
+	_, err = Find[batchEvent](context.Background())
+	assert.ErrorIs(t, err, ErrNoDatabase)
+
+	_, err = First[batchEvent](context.Background())
+	assert.ErrorIs(t, err, ErrNoDatabase)
+}
+
+type batchEvent struct {
+	ID     uint
+	Status string
+}
+
+func TestDeleteInBatches_LoopsUntilNothingLeft(t *testing.T) {
+	db, mock := newMockDB(t)
+	deleteSQL := `DELETE FROM "batch_events" WHERE ctid IN \(SELECT ctid FROM "batch_events" WHERE status = \$1 LIMIT \$2\)`
+	for _, n := range []int64{2, 1, 0} {
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteSQL).WithArgs("done", 2).WillReturnResult(sqlmock.NewResult(0, n))
+		mock.ExpectCommit()
+	}
+
+	ctx := SetFromContext(context.Background(), db)
+	n, err := DeleteInBatches(ctx, &batchEvent{}, "status = ?", []interface{}{"done"}, 2)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteInBatches_StopsWhenContextCancelled(t *testing.T) {
+	db, mock := newMockDB(t)
+	ctx, cancel := context.WithCancel(SetFromContext(context.Background(), db))
+	cancel()
+
+	n, err := DeleteInBatches(ctx, &batchEvent{}, "status = ?", []interface{}{"done"}, 2)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteInBatches_InvalidArguments(t *testing.T) {
+	db, _ := newMockDB(t)
+	ctx := SetFromContext(context.Background(), db)
+
+	_, err := DeleteInBatches(ctx, &batchEvent{}, "", nil, 10)
+	assert.ErrorIs(t, err, gorm.ErrMissingWhereClause)
+
+	_, err = DeleteInBatches(ctx, &batchEvent{}, "status = ?", []interface{}{"done"}, 0)
+	assert.Error(t, err)
+}
+
+type erasedCustomer struct {
+	ID        uint
+	Email     string
+	DeletedAt gorm.DeletedAt
+}
+
+func TestHardDelete_BypassesSoftDelete(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "erased_customers" WHERE email = \$1`).
+		WithArgs("a@x").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	ctx := SetFromContext(context.Background(), db)
+	n, err := HardDelete(ctx, &erasedCustomer{}, "email = ?", "a@x")
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHardDelete_WithoutConditions(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	ctx := SetFromContext(context.Background(), db)
+
+	_, err := HardDelete(ctx, &erasedCustomer{})
+	assert.ErrorIs(t, err, gorm.ErrMissingWhereClause)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+type upsertPrice struct {
+	ID     uint
+	SKU    string
+	Amount int
+}
+
+func TestUpsert(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "upsert_prices" \("sku","amount"\) VALUES \(\$1,\$2\),\(\$3,\$4\) ON CONFLICT \("sku"\) DO UPDATE SET "amount"="excluded"."amount" RETURNING "id"`).
+		WithArgs("a", 1, "b", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "upsert_prices" .* ON CONFLICT \("sku"\) DO NOTHING RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	ctx := SetFromContext(context.Background(), db)
+	prices := []upsertPrice{{SKU: "a", Amount: 1}, {SKU: "b", Amount: 2}}
+	assert.NoError(t, Upsert(ctx, &prices, []string{"sku"}, []string{"amount"}))
+	assert.NoError(t, Upsert(ctx, &upsertPrice{SKU: "a", Amount: 3}, []string{"sku"}, nil))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Error(t, Upsert(ctx, &prices, nil, []string{"amount"}), "updating requires a conflict target")
+}
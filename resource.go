@@ -0,0 +1,75 @@
+package dbgo
+
+import (
+	"strings"
+
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/ext"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/tracer"
+	"gorm.io/gorm"
+)
+
+// nameResources replaces the tracing plugin's after callbacks, which finish the statement span with the SQL as
+// its resource name, with versions naming the resource with namer (Config.TracingResourceNamer). An empty name
+// falls back to the plugin's callback and the SQL. Spans are reported as errors according to errCheck (nil
+// reports every error), like the plugin does with Config.TracingErrorCheck.
+func nameResources(db *gorm.DB, namer func(op, table string) string, errCheck func(error) bool) error {
+	cb := db.Callback()
+	for _, p := range []struct {
+		get     func(string) func(*gorm.DB)
+		replace func(string, func(*gorm.DB)) error
+		name    string
+	}{
+		{cb.Create().Get, cb.Create().Replace, "dd-trace-go:after_create"},
+		{cb.Query().Get, cb.Query().Replace, "dd-trace-go:after_query"},
+		{cb.Update().Get, cb.Update().Replace, "dd-trace-go:after_update"},
+		{cb.Delete().Get, cb.Delete().Replace, "dd-trace-go:after_delete"},
+		{cb.Row().Get, cb.Row().Replace, "dd-trace-go:after_row_query"},
+		{cb.Raw().Get, cb.Raw().Replace, "dd-trace-go:after_raw_query"},
+	} {
+		after := p.get(p.name)
+		if after == nil {
+			continue
+		}
+		if err := p.replace(p.name, finishNamedSpan(after, namer, errCheck)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func finishNamedSpan(after func(*gorm.DB), namer func(op, table string) string, errCheck func(error) bool) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Statement == nil || db.Statement.Context == nil || db.DryRun {
+			after(db)
+			return
+		}
+		span, ok := tracer.SpanFromContext(db.Statement.Context)
+		if !ok {
+			after(db)
+			return
+		}
+		resource := namer(sqlOperation(db.Statement.SQL.String()), db.Statement.Table)
+		if resource == "" {
+			after(db)
+			return
+		}
+		var err error
+		if errCheck == nil || errCheck(db.Error) {
+			err = db.Error
+		}
+		span.SetTag(ext.ResourceName, resource)
+		span.Finish(tracer.WithError(err))
+	}
+}
+
+// sqlOperation returns the upper-cased first keyword of sql (e.g. "SELECT"), skipping leading comments.
+func sqlOperation(sql string) string {
+	sql, ok := skipLeadingComments(sql)
+	if !ok {
+		return ""
+	}
+	if i := strings.IndexFunc(sql, func(r rune) bool { return r == ' ' || r == '\t' || r == '\n' || r == '(' }); i >= 0 {
+		sql = sql[:i]
+	}
+	return strings.ToUpper(sql)
+}
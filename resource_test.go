@@ -0,0 +1,58 @@
+package dbgo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/ext"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/mocktracer"
+	"github.com/stretchr/testify/assert"
+)
+
+type tracedUser struct {
+	ID   uint
+	Name string
+}
+
+func TestTracingResourceNamer(t *testing.T) {
+	saveAndRestoreConn(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	db, mock := newMockDB(t)
+	cfg := *WithTracingResourceNamer(func(op, table string) string {
+		if table == "" {
+			return "" // keep the SQL
+		}
+		return op + " " + table
+	})(&Config{EnableTracing: true})
+	db, err := EnableTracing(db, cfg)
+	assert.NoError(t, err)
+
+	mock.ExpectQuery(`SELECT \* FROM "traced_users"`).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "a"))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "traced_users"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`UPDATE users`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ctx := context.Background()
+	var users []tracedUser
+	assert.NoError(t, db.WithContext(ctx).Where("name = ?", "a").Find(&users).Error)
+	assert.NoError(t, db.WithContext(ctx).Delete(&tracedUser{ID: 1}).Error)
+	assert.NoError(t, db.WithContext(ctx).Exec("UPDATE users SET active = true").Error)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var resources []string
+	for _, s := range mt.FinishedSpans() {
+		resources = append(resources, s.Tag(ext.ResourceName).(string))
+	}
+	assert.Equal(t, []string{"SELECT traced_users", "DELETE traced_users", "UPDATE users SET active = true"}, resources)
+}
+
+func TestSQLOperation(t *testing.T) {
+	assert.Equal(t, "SELECT", sqlOperation(`select * from users`))
+	assert.Equal(t, "INSERT", sqlOperation("/* request_id=1 */ INSERT INTO users (name) VALUES ($1)"))
+	assert.Equal(t, "WITH", sqlOperation("WITH(x) AS (SELECT 1) SELECT * FROM x"))
+	assert.Equal(t, "", sqlOperation("/* unterminated"))
+}
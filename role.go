@@ -0,0 +1,84 @@
+package dbgo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type roleContextKey struct{}
+
+// WithRole returns a context whose transactions run as the given PostgreSQL role: WithTransaction executes
+// SET LOCAL ROLE right after BEGIN, so the role is reset automatically when the transaction commits or rolls
+// back (including on error or panic) and never leaks to other users of the pooled connection.
+// A nested WithTransaction with a different role switches for the duration of its fn and then restores the
+// outer role. The role is quoted as an identifier, so it cannot inject SQL.
+// Statements outside WithTransaction are not affected: a pooled connection has no scope to reset the role in.
+// Example:
+//
+//	err := dbgo.WithTransaction(dbgo.WithRole(ctx, "reporting_ro"), func(ctx context.Context) error {
+//	    return dbgo.GetFromContext(ctx).Find(&rows).Error
+//	})
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleContextKey{}, role)
+}
+
+func roleFrom(ctx context.Context) (string, bool) {
+	role, ok := ctx.Value(roleContextKey{}).(string)
+	return role, ok
+}
+
+// errEmptyRole is returned when WithRole was given an empty role name.
+var errEmptyRole = fmt.Errorf("%w: WithRole requires a non-empty role", ErrInvalidArgument)
+
+// setLocalRole switches the role of the transaction db for the rest of the transaction.
+func setLocalRole(db *gorm.DB, role string) error {
+	if role == "" {
+		return errEmptyRole
+	}
+	return db.Exec("SET LOCAL ROLE " + quoteIdentifier(role)).Error
+}
+
+// withNestedRole runs fn inside the existing transaction db as role, restoring the outer role afterwards, whether
+// fn fails or not: the outer fn may go on after a nested error (e.g. one of Config.NonFatalErrors) and commit.
+func withNestedRole(ctx context.Context, db *gorm.DB, role string, fn UnitOfWork) (err error) {
+	state := txStateFrom(ctx)
+	var outer string
+	if state != nil {
+		outer = state.role
+	}
+	if role == outer {
+		return fn(ctx)
+	}
+	if err := setLocalRole(db, role); err != nil {
+		return err
+	}
+	if state != nil {
+		state.role = role
+	}
+	defer func() {
+		if state != nil {
+			state.role = outer
+		}
+		// After a failed statement the transaction is aborted and the restore fails too: fn's error is kept.
+		if restoreErr := restoreRole(db, outer); err == nil {
+			err = restoreErr
+		}
+	}()
+	return fn(ctx)
+}
+
+// restoreRole switches the role of the transaction db back to role, or to the session's when role is empty.
+func restoreRole(db *gorm.DB, role string) error {
+	if role == "" {
+		return db.Exec("SET LOCAL ROLE NONE").Error
+	}
+	return setLocalRole(db, role)
+}
+
+// quoteIdentifier quotes name as a PostgreSQL identifier, doubling embedded quotes.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,113 @@
+package dbgo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestQuoteIdentifier(t *testing.T) {
+	assert.Equal(t, `"reporting"`, quoteIdentifier("reporting"))
+	assert.Equal(t, `"we""ird; DROP TABLE users; --"`, quoteIdentifier(`we"ird; DROP TABLE users; --`))
+}
+
+func TestWithTransaction_WithRole(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SET LOCAL ROLE "reporting"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT 1`).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+	mock.ExpectCommit()
+
+	err := WithTransaction(WithRole(context.Background(), "reporting"), func(ctx context.Context) error {
+		var n int
+		return GetFromContext(ctx).Raw("SELECT 1").Scan(&n).Error
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransaction_WithRole_NestedRestoresOuterRole(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SET LOCAL ROLE "writer"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SET LOCAL ROLE "auditor"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SET LOCAL ROLE "writer"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err := WithTransaction(WithRole(context.Background(), "writer"), func(ctx context.Context) error {
+		// Same role as the outer transaction: nothing to switch.
+		if err := WithTransaction(ctx, func(context.Context) error { return nil }); err != nil {
+			return err
+		}
+		return WithTransaction(WithRole(ctx, "auditor"), func(context.Context) error { return nil })
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransaction_WithRole_NestedRestoresOuterRoleOnError(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{NonFatalErrors: []error{gorm.ErrRecordNotFound}}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SET LOCAL ROLE "writer"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SET LOCAL ROLE "auditor"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SET LOCAL ROLE "writer"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO audit_log`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := WithTransaction(WithRole(context.Background(), "writer"), func(ctx context.Context) error {
+		err := WithTransaction(WithRole(ctx, "auditor"), func(context.Context) error { return gorm.ErrRecordNotFound })
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		assert.Equal(t, "writer", txStateFrom(ctx).role)
+		// The outer fn goes on after the nested error: it must run as the outer role again.
+		return GetFromContext(ctx).Exec("INSERT INTO audit_log (event) VALUES ('missing')").Error
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransaction_WithRole_EmptyRoleRollsBack(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	called := false
+	err := WithTransaction(WithRole(context.Background(), ""), func(context.Context) error {
+		called = true
+		return nil
+	})
+
+	assert.ErrorIs(t, err, errEmptyRole)
+	assert.False(t, called)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
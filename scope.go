@@ -0,0 +1,52 @@
+package dbgo
+
+import (
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// scopes holds the default scopes registered with RegisterScope, by model struct type.
+var scopes struct {
+	sync.RWMutex
+	byType map[reflect.Type][]func(*gorm.DB) *gorm.DB
+}
+
+// RegisterScope registers scope as a default scope of model's type: it is applied to every query on that
+// model (Find, First, Count, ...) run on a connection from GetConnection, e.g. a tenant or "active = true" filter
+// that every repository would otherwise have to remember. model is a value or pointer of the model struct;
+// several scopes for the same model are applied in registration order. Queries with Unscoped() skip them.
+// Scopes are meant to be registered at startup, before the models are queried.
+// Example:
+//
+//	dbgo.RegisterScope(&Account{}, func(db *gorm.DB) *gorm.DB {
+//	    return db.Where("active = ?", true)
+//	})
+//	err := dbgo.GetFromContext(ctx).Find(&accounts).Error // ... WHERE active = true
+func RegisterScope(model interface{}, scope func(*gorm.DB) *gorm.DB) {
+	t := reflect.TypeOf(model)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	scopes.Lock()
+	defer scopes.Unlock()
+	if scopes.byType == nil {
+		scopes.byType = make(map[reflect.Type][]func(*gorm.DB) *gorm.DB)
+	}
+	scopes.byType[t] = append(scopes.byType[t], scope)
+}
+
+// applyScopes is the query callback applying the scopes registered for the statement's model. Inside a
+// callback the DB shares its Statement, so the scopes' conditions are added to the query being built.
+func applyScopes(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Unscoped || db.Statement.Schema == nil {
+		return
+	}
+	scopes.RLock()
+	registered := scopes.byType[db.Statement.Schema.ModelType]
+	scopes.RUnlock()
+	for _, scope := range registered {
+		scope(db)
+	}
+}
@@ -0,0 +1,52 @@
+package dbgo
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type scopedAccount struct {
+	ID     uint
+	Active bool
+	Tenant string
+}
+
+func TestRegisterScope(t *testing.T) {
+	t.Cleanup(func() {
+		scopes.Lock()
+		delete(scopes.byType, reflect.TypeOf(scopedAccount{}))
+		scopes.Unlock()
+	})
+	RegisterScope(&scopedAccount{}, func(db *gorm.DB) *gorm.DB { return db.Where("active = ?", true) })
+	RegisterScope(scopedAccount{}, func(db *gorm.DB) *gorm.DB { return db.Where("tenant = ?", "acme") })
+
+	db, mock := newMockDB(t)
+	assert.NoError(t, db.Use(callbacksPlugin{}))
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "scoped_accounts" WHERE id > $1 AND active = $2 AND tenant = $3`)).
+		WithArgs(1, true, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "scoped_accounts" WHERE active = $1 AND tenant = $2`)).
+		WithArgs(true, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "scoped_accounts"`)).
+		WithoutArgs().
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "commented_users"`)).
+		WithoutArgs().
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	var accounts []scopedAccount
+	assert.NoError(t, db.Where("id > ?", 1).Find(&accounts).Error)
+	var count int64
+	assert.NoError(t, db.Model(&scopedAccount{}).Count(&count).Error)
+	assert.NoError(t, db.Unscoped().Find(&accounts).Error, "Unscoped skips the registered scopes")
+	var users []commentedUser
+	assert.NoError(t, db.Find(&users).Error, "other models are not scoped")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,58 @@
+package dbgo
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// slowQueryPlugin calls Config.OnSlowQuery for statements slower than Config.SlowQueryThreshold. It is installed
+// by getConnection when OnSlowQuery is set.
+type slowQueryPlugin struct {
+	threshold time.Duration
+	fn        func(ctx context.Context, sql string, elapsed time.Duration)
+}
+
+func (slowQueryPlugin) Name() string {
+	return "dbgo:slow_query"
+}
+
+// Initialize wraps the callbacks that execute statements, so the measured time covers the round trip
+// to the database only (not hooks, associations or the implicit transaction around single writes).
+func (p slowQueryPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, c := range []struct {
+		get     func(string) func(*gorm.DB)
+		replace func(string, func(*gorm.DB)) error
+		name    string
+	}{
+		{cb.Create().Get, cb.Create().Replace, "gorm:create"},
+		{cb.Query().Get, cb.Query().Replace, "gorm:query"},
+		{cb.Update().Get, cb.Update().Replace, "gorm:update"},
+		{cb.Delete().Get, cb.Delete().Replace, "gorm:delete"},
+		{cb.Row().Get, cb.Row().Replace, "gorm:row"},
+		{cb.Raw().Get, cb.Raw().Replace, "gorm:raw"},
+	} {
+		next := c.get(c.name)
+		if next == nil {
+			continue
+		}
+		if err := c.replace(c.name, p.timed(next)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timed returns next wrapped with the slow-query check. The SQL passed to fn keeps its placeholders, so it
+// can be used as a low-cardinality tag and carries no bound values.
+func (p slowQueryPlugin) timed(next func(*gorm.DB)) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		start := time.Now()
+		next(db)
+		if elapsed := time.Since(start); elapsed > p.threshold && !db.DryRun {
+			p.fn(db.Statement.Context, db.Statement.SQL.String(), elapsed)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package dbgo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlowQueryPlugin(t *testing.T) {
+	type slowQuery struct {
+		ctx     context.Context
+		sql     string
+		elapsed time.Duration
+	}
+	var got []slowQuery
+	db, mock := newMockDB(t)
+	assert.NoError(t, db.Use(slowQueryPlugin{threshold: 20 * time.Millisecond, fn: func(ctx context.Context, sql string, elapsed time.Duration) {
+		got = append(got, slowQuery{ctx, sql, elapsed})
+	}}))
+
+	type requestKey struct{}
+	ctx := context.WithValue(context.Background(), requestKey{}, "req-7")
+	mock.ExpectQuery(`SELECT \* FROM "commented_users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`UPDATE users`).
+		WithArgs("x").
+		WillDelayFor(30 * time.Millisecond).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var users []commentedUser
+	assert.NoError(t, db.WithContext(ctx).Find(&users).Error)
+	assert.NoError(t, db.WithContext(ctx).Exec("UPDATE users SET name = ?", "x").Error)
+
+	if assert.Len(t, got, 1, "only the slow statement is reported") {
+		assert.Equal(t, "UPDATE users SET name = $1", got[0].sql)
+		assert.GreaterOrEqual(t, got[0].elapsed, 30*time.Millisecond)
+		assert.Equal(t, "req-7", got[0].ctx.Value(requestKey{}))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
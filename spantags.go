@@ -0,0 +1,100 @@
+package dbgo
+
+import (
+	"context"
+
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/tracer"
+	"gorm.io/gorm"
+)
+
+type spanTagsKey struct{}
+
+// WithSpanTags returns a copy of ctx whose tags are set on the spans of the statements run under it, and on the
+// spans started from it by StartSpan (the "db.transaction" span, TracedTransaction), so a query can be labelled
+// deep in the call stack (e.g. with the feature or endpoint it serves) without passing the tags along. The tags
+// are added to the ones already in ctx, replacing those with the same key. They only apply while tracing is
+// enabled.
+// Example:
+//
+//	ctx = dbgo.WithSpanTags(ctx, map[string]string{"feature": "checkout", "endpoint": "POST /orders"})
+//	err := dbgo.GetFromContext(ctx).Create(&order).Error // its span is tagged feature:checkout
+func WithSpanTags(ctx context.Context, tags map[string]string) context.Context {
+	if len(tags) == 0 {
+		return ctx
+	}
+	parent := spanTagsFrom(ctx)
+	merged := make(map[string]string, len(parent)+len(tags))
+	for k, v := range parent {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, spanTagsKey{}, merged)
+}
+
+// WithSpanTag is WithSpanTags for a single tag.
+// Example:
+//
+//	ctx = dbgo.WithSpanTag(ctx, "feature", "checkout")
+func WithSpanTag(ctx context.Context, key, value string) context.Context {
+	return WithSpanTags(ctx, map[string]string{key: value})
+}
+
+// spanTagsFrom returns the tags set on ctx by WithSpanTags; the map must not be modified.
+func spanTagsFrom(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+	tags, _ := ctx.Value(spanTagsKey{}).(map[string]string)
+	return tags
+}
+
+// setSpanTags sets the tags of ctx (see WithSpanTags) on span.
+func setSpanTags(ctx context.Context, span *tracer.Span) {
+	for k, v := range spanTagsFrom(ctx) {
+		span.SetTag(k, v)
+	}
+}
+
+// spanTagsPlugin sets the tags of WithSpanTags on statement spans. Like analyticsPlugin, its callbacks run right
+// after the tracing plugin's before callbacks, once the statement span is in the statement context.
+type spanTagsPlugin struct {
+	tracing *liveTracing
+}
+
+func (spanTagsPlugin) Name() string {
+	return "dbgo:span_tags"
+}
+
+func (p spanTagsPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").After("dd-trace-go:before_create").Register("dbgo:span_tags", p.tag); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").After("dd-trace-go:before_query").Register("dbgo:span_tags", p.tag); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").After("dd-trace-go:before_update").Register("dbgo:span_tags", p.tag); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").After("dd-trace-go:before_delete").Register("dbgo:span_tags", p.tag); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").After("dd-trace-go:before_row_query").Register("dbgo:span_tags", p.tag); err != nil {
+		return err
+	}
+	return cb.Raw().Before("gorm:raw").After("dd-trace-go:before_raw_query").Register("dbgo:span_tags", p.tag)
+}
+
+// tag sets the tags on the span of a traced statement. Untraced statements are skipped: the span in their
+// context is a parent's, such as the transaction span.
+func (p spanTagsPlugin) tag(db *gorm.DB) {
+	ctx := db.Statement.Context
+	if ctx == nil || len(spanTagsFrom(ctx)) == 0 || !p.tracing.active(db) {
+		return
+	}
+	if span, ok := tracer.SpanFromContext(ctx); ok {
+		setSpanTags(ctx, span)
+	}
+}
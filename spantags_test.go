@@ -0,0 +1,68 @@
+package dbgo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/ext"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/mocktracer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithSpanTags_Merge(t *testing.T) {
+	ctx := WithSpanTags(context.Background(), map[string]string{"feature": "checkout", "endpoint": "POST /orders"})
+	inner := WithSpanTag(ctx, "feature", "refund")
+
+	assert.Equal(t, map[string]string{"feature": "refund", "endpoint": "POST /orders"}, spanTagsFrom(inner))
+	assert.Equal(t, "checkout", spanTagsFrom(ctx)["feature"], "the parent's tags are left untouched")
+	assert.Equal(t, ctx, WithSpanTags(ctx, nil))
+}
+
+func TestWithSpanTag_TagsStatementAndTransactionSpans(t *testing.T) {
+	saveAndRestoreConn(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	db, mock := newMockDB(t)
+	cfg := Config{EnableTracing: true}
+	db, err := EnableTracing(db, cfg)
+	assert.NoError(t, err)
+
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = cfg
+	connMu.Unlock()
+
+	mock.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`DELETE FROM sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ctx := WithSpanTag(context.Background(), "feature", "checkout")
+	var count int64
+	assert.NoError(t, Raw(ctx, &count, "SELECT count(*) FROM users"))
+	assert.NoError(t, WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := Exec(WithSpanTag(ctx, "step", "activate"), "UPDATE users SET active = true")
+		return err
+	}))
+	_, err = Exec(context.Background(), "DELETE FROM sessions")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	tags := map[string]map[string]interface{}{}
+	for _, s := range mt.FinishedSpans() {
+		name := s.OperationName()
+		if name != SpanNameTransaction {
+			name = s.Tag(ext.ResourceName).(string)
+		}
+		tags[name] = map[string]interface{}{"feature": s.Tag("feature"), "step": s.Tag("step")}
+	}
+	assert.Equal(t, "checkout", tags["SELECT count(*) FROM users"]["feature"])
+	assert.Equal(t, "checkout", tags[SpanNameTransaction]["feature"])
+	assert.Nil(t, tags[SpanNameTransaction]["step"], "tags added inside the transaction stay off its span")
+	assert.Equal(t, "checkout", tags["UPDATE users SET active = true"]["feature"])
+	assert.Equal(t, "activate", tags["UPDATE users SET active = true"]["step"])
+	assert.Nil(t, tags["DELETE FROM sessions"]["feature"])
+}
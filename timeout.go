@@ -0,0 +1,52 @@
+package dbgo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WithTransactionLockTimeout runs fn in a transaction like WithTransaction, with PostgreSQL's lock_timeout set
+// to d (SET LOCAL, so it ends with the transaction): a statement that waits longer than d for a lock fails with
+// SQLSTATE 55P03 (lock_not_available) instead of blocking indefinitely behind a long-running transaction,
+// turning a pile-up into a fast, retryable error. d is rounded up to whole milliseconds and must be positive.
+// A nested call applies d for the duration of its fn and then restores the outer transaction's lock_timeout,
+// whether fn fails or not.
+// Example:
+//
+//	err := dbgo.WithTransactionLockTimeout(ctx, 2*time.Second, func(ctx context.Context) error {
+//	    return dbgo.GetFromContext(ctx).Model(&counter).Update("n", gorm.Expr("n + 1")).Error
+//	})
+func WithTransactionLockTimeout(ctx context.Context, d time.Duration, fn UnitOfWork) error {
+	if fn == nil {
+		return ErrNilUnitOfWork
+	}
+	if d <= 0 {
+		return fmt.Errorf("%w: WithTransactionLockTimeout requires a positive timeout (got %s)", ErrInvalidArgument, d)
+	}
+	nested := isTransaction(GetFromContext(ctx))
+	return WithTransaction(ctx, func(ctx context.Context) (err error) {
+		db := GetFromContext(ctx)
+		var outer string
+		if nested {
+			if err := db.Raw("SELECT current_setting('lock_timeout')").Scan(&outer).Error; err != nil {
+				return err
+			}
+		}
+		ms := (d + time.Millisecond - 1) / time.Millisecond
+		if err := db.Exec(fmt.Sprintf("SET LOCAL lock_timeout = '%dms'", ms)).Error; err != nil {
+			return err
+		}
+		if nested {
+			// Restored even when fn fails: the outer fn may go on (e.g. after one of Config.NonFatalErrors). After a
+			// failed statement the transaction is aborted and the restore fails too: fn's error is kept.
+			defer func() {
+				// set_config(..., true) is SET LOCAL with a bound value.
+				if restoreErr := db.Exec("SELECT set_config('lock_timeout', ?, true)", outer).Error; err == nil {
+					err = restoreErr
+				}
+			}()
+		}
+		return fn(ctx)
+	})
+}
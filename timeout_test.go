@@ -0,0 +1,94 @@
+package dbgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTransactionLockTimeout(t *testing.T) {
+	saveAndRestoreConn(t)
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SET LOCAL lock_timeout = '1501ms'`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE counters`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := WithTransactionLockTimeout(context.Background(), 1500*time.Millisecond+time.Microsecond, func(ctx context.Context) error {
+		_, err := Exec(ctx, "UPDATE counters SET n = n + 1")
+		return err
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransactionLockTimeout_NestedRestoresOuter(t *testing.T) {
+	saveAndRestoreConn(t)
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SET LOCAL lock_timeout = '5000ms'`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT current_setting\('lock_timeout'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"current_setting"}).AddRow("5s"))
+	mock.ExpectExec(`SET LOCAL lock_timeout = '100ms'`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SELECT set_config\('lock_timeout', \$1, true\)`).
+		WithArgs("5s").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err := WithTransactionLockTimeout(context.Background(), 5*time.Second, func(ctx context.Context) error {
+		return WithTransactionLockTimeout(ctx, 100*time.Millisecond, func(ctx context.Context) error {
+			return nil
+		})
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransactionLockTimeout_NestedRestoresOuterOnError(t *testing.T) {
+	saveAndRestoreConn(t)
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	errSkipped := errors.New("skipped")
+	mock.ExpectBegin()
+	mock.ExpectExec(`SET LOCAL lock_timeout = '5000ms'`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT current_setting\('lock_timeout'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"current_setting"}).AddRow("5s"))
+	mock.ExpectExec(`SET LOCAL lock_timeout = '100ms'`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SELECT set_config\('lock_timeout', \$1, true\)`).
+		WithArgs("5s").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE counters`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := WithTransactionLockTimeout(context.Background(), 5*time.Second, func(ctx context.Context) error {
+		err := WithTransactionLockTimeout(ctx, 100*time.Millisecond, func(ctx context.Context) error {
+			return errSkipped
+		})
+		assert.ErrorIs(t, err, errSkipped)
+		// The outer fn goes on after the nested error, with its own lock_timeout.
+		_, err = Exec(ctx, "UPDATE counters SET n = n + 1")
+		return err
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransactionLockTimeout_RequiresPositiveTimeout(t *testing.T) {
+	err := WithTransactionLockTimeout(context.Background(), 0, func(ctx context.Context) error { return nil })
+	assert.ErrorContains(t, err, "positive timeout")
+}
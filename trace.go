@@ -11,6 +11,8 @@ import (
 const (
 	// SpanNameTransaction is the span name used for transaction spans in Datadog.
 	SpanNameTransaction = "db.transaction"
+	// SpanNameConnectionAcquire is the span name used for connection pool waits (Config.TraceConnectionAcquire).
+	SpanNameConnectionAcquire = "db.connection.acquire"
 	// DefaultTracingServiceName is the default service name for tracing when Config.TracingServiceName is empty.
 	DefaultTracingServiceName = "db-go"
 )
@@ -55,6 +57,23 @@ func WithTracingAnalyticsRate(rate float64) func(*Config) *Config {
 	}
 }
 
+// WithTracingOperationAnalyticsRates sets separate analytics rates for read statements, write statements and
+// transaction spans (see Config.TracingReadAnalyticsRate and friends), e.g. to analyze every write but only
+// a sample of high-volume reads.
+// Example:
+//
+//	config := dbgo.Config{PrimaryDSN: "..."}
+//	config = *dbgo.WithTracing(&config)
+//	config = *dbgo.WithTracingOperationAnalyticsRates(0.1, 1.0, 1.0)(&config)
+func WithTracingOperationAnalyticsRates(read, write, transaction float64) func(*Config) *Config {
+	return func(cfg *Config) *Config {
+		cfg.TracingReadAnalyticsRate = &read
+		cfg.TracingWriteAnalyticsRate = &write
+		cfg.TracingTransactionAnalyticsRate = &transaction
+		return cfg
+	}
+}
+
 // WithTracingErrorCheck sets a custom error check function for Datadog tracing.
 // This allows you to control which errors are reported to Datadog.
 // Example:
@@ -72,53 +91,132 @@ func WithTracingErrorCheck(errCheck func(error) bool) func(*Config) *Config {
 	}
 }
 
+// WithTracingResourceNamer sets the function naming the resource of statement spans (see
+// Config.TracingResourceNamer).
+// Example:
+//
+//	config := dbgo.Config{PrimaryDSN: "..."}
+//	config = *dbgo.WithTracing(&config)
+//	config = *dbgo.WithTracingResourceNamer(func(op, table string) string {
+//	    return op + " " + table
+//	})(&config)
+func WithTracingResourceNamer(namer func(op, table string) string) func(*Config) *Config {
+	return func(cfg *Config) *Config {
+		cfg.TracingResourceNamer = namer
+		return cfg
+	}
+}
+
 // EnableTracing applies Datadog tracing to a GORM database connection.
-// This function is called internally by getConnection when tracing is enabled.
-// You generally don't need to call this function directly.
+// GetConnection installs the same callbacks on its connection (whether or not tracing is enabled, see
+// UpdateTracing), so you generally don't need to call this function directly.
+// Statements under WithoutTracing are skipped by the plugin's callbacks. With cfg.TracingResourceNamer the spans
+// are finished by dbgo's callbacks, which set the resource name.
+// Analytics rates (cfg.TracingAnalyticsRate and the per-operation rates) are applied by callbacks that run after
+// the tracing plugin's. With cfg.TraceConnectionAcquire it also installs the callbacks that start connection acquisition spans;
+// the spans are only emitted for connections opened by GetConnection, whose connector reports acquisitions.
+// Returns ErrNoDatabase when tracing is enabled but db is nil or not an opened connection.
 func EnableTracing(db *gorm.DB, cfg Config) (*gorm.DB, error) {
 	if !cfg.EnableTracing {
 		return db, nil
 	}
+	if !hasConnection(db) {
+		return db, ErrNoDatabase
+	}
 
-	var opts []gormtrace.Option
+	return db, installTracing(db, cfg, newLiveTracing(cfg))
+}
 
-	svc := cfg.TracingServiceName
-	if svc == "" {
-		svc = DefaultTracingServiceName
+// installTracing installs the tracing plugin and dbgo's tracing callbacks on db. They follow the settings in
+// tracing, which UpdateTracing changes for the singleton connection.
+func installTracing(db *gorm.DB, cfg Config, tracing *liveTracing) error {
+	svc := tracingServiceName(cfg)
+	// The analytics rate is not given to the plugin: analyticsPlugin sets it from the live settings.
+	plugin := gormtrace.NewTracePlugin(gormtrace.WithService(svc), gormtrace.WithErrorCheck(tracing.reportError))
+	if err := db.Use(plugin); err != nil {
+		return err
 	}
-	opts = append(opts, gormtrace.WithService(svc))
-
-	if cfg.TracingAnalyticsRate != nil {
-		opts = append(opts, gormtrace.WithAnalyticsRate(*cfg.TracingAnalyticsRate))
+	if cfg.TracingResourceNamer != nil {
+		if err := nameResources(db, cfg.TracingResourceNamer, tracing.reportError); err != nil {
+			return err
+		}
 	}
-
-	if cfg.TracingErrorCheck != nil {
-		opts = append(opts, gormtrace.WithErrorCheck(cfg.TracingErrorCheck))
+	if err := skipUntracedStatements(db, tracing); err != nil {
+		return err
 	}
-
-	plugin := gormtrace.NewTracePlugin(opts...)
-	if err := db.Use(plugin); err != nil {
-		return db, err
+	if err := db.Use(analyticsPlugin{tracing: tracing}); err != nil {
+		return err
+	}
+	if err := db.Use(spanTagsPlugin{tracing: tracing}); err != nil {
+		return err
+	}
+	if cfg.TraceConnectionAcquire {
+		if err := db.Use(acquirePlugin{service: svc, tracing: tracing}); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	return db, nil
+// tracingServiceName returns cfg.TracingServiceName, or DefaultTracingServiceName when empty.
+func tracingServiceName(cfg Config) string {
+	if cfg.TracingServiceName == "" {
+		return DefaultTracingServiceName
+	}
+	return cfg.TracingServiceName
 }
 
 // WithContext wraps the GORM database connection with a context and also stores
 // the DB instance in the context for retrieval via GetFromContext.
 // This combines db.WithContext(ctx) and SetFromContext in a single call,
 // enabling both GORM context propagation and dbgo context-based DB lookup.
+// If db is nil or not an opened connection, ctx and db are returned unchanged.
 // Example:
 //
 //	span, ctx := tracer.StartSpanFromContext(context.Background(), "my-operation")
 //	defer span.Finish()
 //	ctx, db := dbgo.WithContext(ctx, dbConn.Instance)
 func WithContext(ctx context.Context, db *gorm.DB) (context.Context, *gorm.DB) {
+	if !hasConnection(db) {
+		return ctx, db
+	}
 	dbCtx := db.WithContext(ctx)
 	return SetFromContext(ctx, dbCtx), dbCtx
 }
 
-// StartSpan creates a new Datadog span from the given context.
+// TracedTransaction runs fn in WithTransaction under a new span named opName (for the service of
+// Config.TracingServiceName), so a unit of work gets its own span in APM without starting one by hand. The span
+// is tagged with the transaction's outcome in "db.transaction.status": "committed", "rolled_back", or "nested"
+// when fn joined an outer transaction; errors are tagged like the "db.transaction" span's. The span is started
+// even when Config.EnableTracing is false (statement spans are not).
+// Example:
+//
+//	err := dbgo.TracedTransaction(ctx, "checkout", func(ctx context.Context) error {
+//	    return dbgo.GetFromContext(ctx).Create(&order).Error
+//	})
+func TracedTransaction(ctx context.Context, opName string, fn UnitOfWork) error {
+	db, _ := contextDB(ctx)
+	nested := hasConnection(db) && isTransaction(db)
+
+	ctx, span := StartSpan(ctx, opName, GetActiveConfig().TracingServiceName)
+	committed, err := runTransaction(ctx, fn, false)
+	switch {
+	case committed:
+		span.SetTag("db.transaction.status", "committed")
+	case nested:
+		span.SetTag("db.transaction.status", "nested")
+	default:
+		span.SetTag("db.transaction.status", "rolled_back")
+	}
+	if err != nil {
+		span.SetTag("error", true)
+		span.SetTag("error.message", err.Error())
+	}
+	span.Finish()
+	return err
+}
+
+// StartSpan creates a new Datadog span from the given context, tagged with the tags of WithSpanTags.
 // If service is empty, DefaultTracingServiceName is used.
 // Example:
 //
@@ -132,5 +230,6 @@ func StartSpan(ctx context.Context, name, service string) (context.Context, *tra
 	span, ctx := tracer.StartSpanFromContext(ctx, name,
 		tracer.ServiceName(service),
 	)
+	setSpanTags(ctx, span)
 	return ctx, span
 }
@@ -2,10 +2,14 @@ package dbgo
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/ext"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/mocktracer"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -154,3 +158,39 @@ func TestStartSpan_WithService_UsesGivenService(t *testing.T) {
 		span.Finish()
 	}
 }
+
+func TestTracedTransaction(t *testing.T) {
+	saveAndRestoreConn(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{TracingServiceName: "orders"}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	errDeclined := errors.New("card declined")
+	assert.NoError(t, TracedTransaction(context.Background(), "checkout", func(ctx context.Context) error {
+		// Joining the transaction from within records the inner span as nested.
+		return TracedTransaction(ctx, "reserve-stock", func(context.Context) error { return nil })
+	}))
+	assert.ErrorIs(t, TracedTransaction(context.Background(), "refund", func(context.Context) error { return errDeclined }), errDeclined)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	spans := map[string]*mocktracer.Span{}
+	for _, s := range mt.FinishedSpans() {
+		spans[s.OperationName()] = s
+	}
+	require.Len(t, spans, 3)
+	assert.Equal(t, "orders", spans["checkout"].Tag(ext.ServiceName))
+	assert.Equal(t, "committed", spans["checkout"].Tag("db.transaction.status"))
+	assert.Equal(t, "nested", spans["reserve-stock"].Tag("db.transaction.status"))
+	assert.Equal(t, "rolled_back", spans["refund"].Tag("db.transaction.status"))
+	assert.Equal(t, "card declined", spans["refund"].Tag("error.message"))
+}
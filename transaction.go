@@ -2,20 +2,67 @@ package dbgo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
 
 	logger "github.com/adnvilla/logger-go"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/ext"
 	"github.com/DataDog/dd-trace-go/v2/ddtrace/tracer"
 	"gorm.io/gorm"
 	"gorm.io/plugin/dbresolver"
 )
 
-// ErrNoDatabase is returned when no database connection is available.
-var ErrNoDatabase = errors.New("dbgo: no database connection available")
+// txDepthKey holds the number of nested WithTransaction calls the context is inside of.
+type txDepthKey struct{}
+
+// sqlStateReadOnlyTransaction is the SQLSTATE of PostgreSQL's read_only_sql_transaction error.
+const sqlStateReadOnlyTransaction = "25006"
+
+// transactionTimeoutError wraps err with ErrTransactionTimeout when ctx is the context of a transaction that
+// outlived Config.MaxTransactionDuration.
+func transactionTimeoutError(ctx context.Context, err error) error {
+	if err == nil || !errors.Is(context.Cause(ctx), ErrTransactionTimeout) || errors.Is(err, ErrTransactionTimeout) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrTransactionTimeout, err)
+}
+
+// readOnlyError wraps err with ErrReadOnlyConnection when the server reported a read-only transaction.
+// The driver error is matched through its SQLState method, so dbgo does not depend on pgconn directly.
+func readOnlyError(err error) error {
+	var sqlErr interface{ SQLState() string }
+	if errors.As(err, &sqlErr) && sqlErr.SQLState() == sqlStateReadOnlyTransaction && !errors.Is(err, ErrReadOnlyConnection) {
+		return fmt.Errorf("%w: %w", ErrReadOnlyConnection, err)
+	}
+	return err
+}
 
 // UnitOfWork represents a function that executes within a transaction context.
 type UnitOfWork func(ctx context.Context) error
 
+type txStateKey struct{}
+
+// txState carries per-transaction bookkeeping through the transaction context.
+type txState struct {
+	writes atomic.Int32 // write statements executed so far (tracked by callbacksPlugin)
+	role   string       // role set with SET LOCAL ROLE (see WithRole); empty for the session role
+
+	failedSQL atomic.Pointer[string] // SQL of the last statement that failed (tracked by callbacksPlugin)
+	use       txUse                  // the statement holding the connection (tracked by concurrentTxPlugin)
+}
+
+func txStateFrom(ctx context.Context) *txState {
+	if ctx == nil {
+		return nil
+	}
+	state, _ := ctx.Value(txStateKey{}).(*txState)
+	return state
+}
+
 func isTransaction(db *gorm.DB) bool {
 	if db == nil || db.Statement == nil {
 		return false
@@ -24,24 +71,163 @@ func isTransaction(db *gorm.DB) bool {
 	return ok
 }
 
-// WithTransaction executes the given UnitOfWork within a database transaction.
+// transactionDB resolves the DB WithTransaction runs on. It falls back to the default connection like
+// GetFromContext unless cfg.StrictTransactionContext is set.
+func transactionDB(ctx context.Context, cfg Config) (*gorm.DB, error) {
+	if !cfg.StrictTransactionContext {
+		if db := GetFromContext(ctx); hasConnection(db) {
+			if cfg.Debug {
+				if ctxDB, _ := contextDB(ctx); !hasConnection(ctxDB) {
+					logger.Warn(ctx, "WithTransaction called with a context without a DB: using the default connection (missing SetFromContext?).")
+				}
+			}
+			return db, nil
+		}
+		return nil, ErrNoDatabase
+	}
+	if db, _ := contextDB(ctx); hasConnection(db) {
+		return db, nil
+	}
+	logger.Error(ctx, "WithTransaction called with a context without a DB (strict transaction context mode: not falling back to the default connection).")
+	return nil, fmt.Errorf("%w: WithTransaction requires a DB in the context (Config.StrictTransactionContext)", ErrNoDatabase)
+}
+
+// beginOptions returns the TxOptions to pass to Begin for the given Config and access mode, or none to use the
+// driver default.
+func beginOptions(cfg Config, readOnly bool) []*sql.TxOptions {
+	if cfg.DefaultIsolation == sql.LevelDefault && !readOnly {
+		return nil
+	}
+	return []*sql.TxOptions{{Isolation: cfg.DefaultIsolation, ReadOnly: readOnly}}
+}
+
+// WithTransaction executes the given UnitOfWork within a database transaction on the primary; every statement
+// issued through the context DB inside fn, reads included, runs on its connection, so use it from fn's goroutine only.
 // If the context already contains an active transaction, it reuses it instead of nesting.
-// On panic, the transaction is rolled back and the panic is re-thrown.
+// On panic, the transaction is rolled back, the panic is logged with its stack trace and re-thrown.
 // When tracing is enabled, a "db.transaction" span is automatically created.
-func WithTransaction(ctx context.Context, fn UnitOfWork) (err error) {
-	dbInstance := GetFromContext(ctx)
-	if dbInstance == nil {
-		return ErrNoDatabase
+// Config (DefaultIsolation, SkipEmptyCommit, NonFatalErrors, MaxTransactionDuration, ...) and WithRole tune it.
+func WithTransaction(ctx context.Context, fn UnitOfWork) error {
+	_, err := runTransaction(ctx, fn, false)
+	return err
+}
+
+// WithTransactionStatus is like WithTransaction, but also reports whether the transaction was committed.
+// committed is true only when COMMIT succeeded, so callers such as message-queue consumers can tell whether
+// fn's writes were persisted before acknowledging a message. It is false when fn or the commit failed, when
+// Config.SkipEmptyCommit rolled back a transaction without writes, and for a nested call, whose writes are
+// only persisted when the outer transaction commits.
+// Example:
+//
+//	committed, err := dbgo.WithTransactionStatus(ctx, handle)
+//	if err == nil && committed {
+//	    msg.Ack()
+//	}
+func WithTransactionStatus(ctx context.Context, fn UnitOfWork) (committed bool, err error) {
+	return runTransaction(ctx, fn, false)
+}
+
+// WithReadOnlyTransaction is like WithTransaction, but begins a READ ONLY transaction on a replica (resolved
+// like other reads, e.g. with Config.ReplicaWeights) instead of the primary, so read-heavy reporting flows that
+// need a consistent snapshot (with Config.DefaultIsolation set to sql.LevelRepeatableRead) keep the load off
+// the primary. Without replicas it runs on the primary. Writes in fn fail with ErrReadOnlyConnection. A nested
+// call joins the outer transaction as it is, read-write or not.
+// Example:
+//
+//	err := dbgo.WithReadOnlyTransaction(ctx, func(ctx context.Context) error {
+//	    db := dbgo.GetFromContext(ctx)
+//	    if err := db.Model(&Order{}).Count(&total).Error; err != nil {
+//	        return err
+//	    }
+//	    return db.Find(&orders).Error
+//	})
+func WithReadOnlyTransaction(ctx context.Context, fn UnitOfWork) error {
+	_, err := runTransaction(ctx, fn, true)
+	return err
+}
+
+// WithTransactionDeferred is like WithTransaction, but runs SET CONSTRAINTS ALL DEFERRED before fn, so
+// constraints declared DEFERRABLE (e.g. foreign keys between rows inserted in any order) are checked at COMMIT
+// instead of after each statement; a violation then fails the commit. Constraints not declared DEFERRABLE are
+// still checked immediately. In a nested call the checks stay deferred until the outer transaction commits.
+// Example:
+//
+//	err := dbgo.WithTransactionDeferred(ctx, func(ctx context.Context) error {
+//	    db := dbgo.GetFromContext(ctx)
+//	    if err := db.Create(&edges).Error; err != nil { // references nodes inserted below
+//	        return err
+//	    }
+//	    return db.Create(&nodes).Error
+//	})
+func WithTransactionDeferred(ctx context.Context, fn UnitOfWork) error {
+	if fn == nil {
+		return ErrNilUnitOfWork
+	}
+	return WithTransaction(ctx, func(ctx context.Context) error {
+		if err := GetFromContext(ctx).Exec("SET CONSTRAINTS ALL DEFERRED").Error; err != nil {
+			return err
+		}
+		return fn(ctx)
+	})
+}
+
+// runTransaction implements WithTransaction, WithTransactionStatus and, with readOnly, WithReadOnlyTransaction.
+func runTransaction(ctx context.Context, fn UnitOfWork, readOnly bool) (committed bool, err error) {
+	start := time.Now()
+	cfg := GetActiveConfig()
+	if fn == nil {
+		return false, ErrNilUnitOfWork
+	}
+	dbInstance, err := transactionDB(ctx, cfg)
+	if err != nil {
+		return false, wrapError(cfg, "WithTransaction", start, nil, err)
+	}
+
+	if lazy := lazyTxFrom(ctx); lazy != nil && !isTransaction(dbInstance) {
+		// Inside MaybeTransaction: begin its transaction and join it.
+		if dbInstance, err = lazy.begin(); err != nil {
+			return false, err
+		}
+		ctx = SetFromContext(ctx, dbInstance)
 	}
 
 	if isTransaction(dbInstance) {
-		return fn(ctx)
+		depth, _ := ctx.Value(txDepthKey{}).(int)
+		depth++
+		if cfg.MaxTransactionNesting > 0 && depth > cfg.MaxTransactionNesting {
+			return false, fmt.Errorf("%w (%d)", ErrMaxNestingExceeded, cfg.MaxTransactionNesting)
+		}
+		ctx = context.WithValue(ctx, txDepthKey{}, depth)
+		if role, ok := roleFrom(ctx); ok {
+			return false, withNestedRole(ctx, dbInstance, role, fn)
+		}
+		return false, fn(ctx)
 	}
 
-	cfg := GetActiveConfig()
-	if cfg.EnableTracing {
-		var span *tracer.Span
+	if cfg.OnTransactionEnd != nil {
+		callerCtx := ctx
+		defer func() {
+			if p := recover(); p != nil {
+				cfg.OnTransactionEnd(callerCtx, false, time.Since(start), fmt.Errorf("panic in transaction: %v", p))
+				panic(p)
+			}
+			cfg.OnTransactionEnd(callerCtx, committed, time.Since(start), err)
+		}()
+	}
+
+	if cfg.MaxTransactionDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, cfg.MaxTransactionDuration, ErrTransactionTimeout)
+		defer cancel()
+	}
+
+	tracing := cfg.EnableTracing && !tracingDisabled(ctx)
+	var span *tracer.Span
+	if tracing {
 		ctx, span = StartSpan(ctx, SpanNameTransaction, cfg.TracingServiceName)
+		if cfg.TracingTransactionAnalyticsRate != nil {
+			span.SetTag(ext.EventSampleRate, *cfg.TracingTransactionAnalyticsRate)
+		}
 		defer func() {
 			if err != nil {
 				span.SetTag("error", true)
@@ -51,29 +237,109 @@ func WithTransaction(ctx context.Context, fn UnitOfWork) (err error) {
 		}()
 	}
 
-	db := dbInstance.
+	state := &txState{}
+	ctx = context.WithValue(ctx, txStateKey{}, state)
+	if tracing && cfg.TraceConnectionAcquire {
+		// Begin is where the transaction waits for its connection.
+		ctx = withAcquireProbe(ctx, tracingServiceName(cfg))
+	}
+
+	op := dbresolver.Write
+	if readOnly {
+		op = dbresolver.Read
+	}
+	session := dbInstance.
 		Session(&gorm.Session{Context: ctx}).
-		Clauses(dbresolver.Write).
-		Begin()
+		Clauses(op)
+	prepareConnPool(session)
+	db := session.Begin(beginOptions(cfg, readOnly)...)
 	if db.Error != nil {
-		return db.Error
+		return false, wrapError(cfg, "WithTransaction", start, nil, readOnlyError(contextError(ctx, db.Error)))
 	}
+	defer trackTransaction()()
 
+	// fnRan tells fn's errors, which may be non-fatal, from those of the setup (role, OnBeginTx), which always
+	// roll back: fn never ran, so committing would report as done work that was never attempted.
+	fnRan := false
 	defer func() {
 		if p := recover(); p != nil {
 			if rbErr := db.Rollback().Error; rbErr != nil {
 				logger.Error(ctx, "failed to rollback transaction: %v", rbErr)
 			}
+			recordPanic(ctx, span, p)
 			panic(p) // re-throw panic
-		} else if err != nil {
+		} else if err != nil && (!fnRan || !nonFatalError(cfg, err)) {
+			if cfg.LogRollbackSQL {
+				logRollback(ctx, state.failedSQL.Load(), err)
+			}
 			if rbErr := db.Rollback().Error; rbErr != nil {
 				logger.Error(ctx, "failed to rollback transaction: %v", rbErr)
 			}
+		} else if errors.Is(context.Cause(ctx), ErrTransactionTimeout) {
+			// database/sql rolls the transaction back once its context expires (Rollback then returns sql.ErrTxDone).
+			_ = db.Rollback()
+			err = ErrTransactionTimeout
+		} else if cfg.SkipEmptyCommit && hasCallbacksPlugin(db) && state.writes.Load() == 0 {
+			if rbErr := db.Rollback().Error; rbErr != nil {
+				err = rbErr
+			}
+		} else if commitErr := db.Commit().Error; commitErr != nil {
+			err = commitErr
 		} else {
-			err = db.Commit().Error
+			committed = true
 		}
+		err = wrapError(cfg, "WithTransaction", start, nil, readOnlyError(contextError(ctx, transactionTimeoutError(ctx, err))))
 	}()
 
-	err = fn(SetFromContext(ctx, db))
-	return err
+	if role, ok := roleFrom(ctx); ok {
+		if err = setLocalRole(db, role); err != nil {
+			return false, err
+		}
+		state.role = role
+	}
+
+	txCtx := SetFromContext(ctx, db)
+	if cfg.StrictTransactionContext {
+		defer enterTxGoroutine()()
+	}
+	if cfg.OnBeginTx != nil {
+		if err = cfg.OnBeginTx(txCtx, db); err != nil {
+			return false, err
+		}
+	}
+	fnRan = true
+	err = fn(txCtx)
+	return false, err
+}
+
+// nonFatalError reports whether err matches one of cfg.NonFatalErrors, which WithTransaction commits on.
+func nonFatalError(cfg Config, err error) bool {
+	for _, target := range cfg.NonFatalErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// logRollback logs the error that made WithTransaction or MaybeTransaction roll back, with the SQL of the last
+// statement that failed in the transaction when there is one (Config.LogRollbackSQL).
+func logRollback(ctx context.Context, sql *string, err error) {
+	if sql != nil {
+		logger.Warn(ctx, "transaction rolled back: %v (last failed statement: %s)", err, *sql)
+		return
+	}
+	logger.Warn(ctx, "transaction rolled back: %v", err)
+}
+
+// recordPanic logs a panic recovered in WithTransaction with its stack trace and tags the transaction span
+// (nil when tracing is disabled) as errored, so the panic is visible before it is re-thrown.
+func recordPanic(ctx context.Context, span *tracer.Span, p interface{}) {
+	stack := debug.Stack()
+	logger.Error(ctx, "panic in transaction (rolled back): %v\n%s", p, stack)
+	if span != nil {
+		span.SetTag("error", true)
+		span.SetTag("error.message", fmt.Sprintf("panic: %v", p))
+		span.SetTag("error.stack", string(stack))
+	}
 }
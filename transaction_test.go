@@ -2,13 +2,19 @@ package dbgo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/ext"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/mocktracer"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
 func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
@@ -103,6 +109,36 @@ func TestWithTransaction_Panic(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestWithTransaction_Panic_TagsSpan(t *testing.T) {
+	saveAndRestoreConn(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{EnableTracing: true}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = WithTransaction(context.Background(), func(ctx context.Context) error {
+			panic("boom")
+		})
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	spans := mt.FinishedSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, SpanNameTransaction, spans[0].OperationName())
+		// The v2 tracer records an error as its message and stack tags, not as a boolean "error" tag.
+		assert.Equal(t, "panic: boom", spans[0].Tag(ext.ErrorMsg))
+		assert.Contains(t, spans[0].Tag(ext.ErrorStack), "TestWithTransaction_Panic_TagsSpan")
+	}
+}
+
 func TestWithTransaction_NilDB_ReturnsError(t *testing.T) {
 	saveAndRestoreConn(t)
 
@@ -228,3 +264,631 @@ func TestWithTransaction_NestedPropagatesError(t *testing.T) {
 	assert.ErrorIs(t, err, innerErr)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestWithTransaction_ReadsStayOnPrimaryWithReplicas(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, primary := newMockDB(t)
+	replicaDB, replica, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { replicaDB.Close() })
+
+	err = db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: []gorm.Dialector{postgres.New(postgres.Config{Conn: replicaDB})},
+	}))
+	assert.NoError(t, err)
+
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	primary.ExpectBegin()
+	primary.ExpectQuery(`SELECT \* FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	primary.ExpectQuery(`SELECT count`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	primary.ExpectCommit()
+
+	err = WithTransaction(context.Background(), func(ctx context.Context) error {
+		var rows []map[string]interface{}
+		if err := GetFromContext(ctx).Table("users").Find(&rows).Error; err != nil {
+			return err
+		}
+		var count int64
+		return GetFromContext(ctx).Raw("SELECT count(*) FROM users").Scan(&count).Error
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, primary.ExpectationsWereMet())
+	assert.NoError(t, replica.ExpectationsWereMet(), "no statement inside the transaction may reach a replica")
+}
+
+func TestBeginOptions_DefaultIsolation(t *testing.T) {
+	assert.Empty(t, beginOptions(Config{}, false), "zero value must keep the driver default")
+
+	opts := beginOptions(Config{DefaultIsolation: sql.LevelRepeatableRead}, false)
+	if assert.Len(t, opts, 1) {
+		assert.Equal(t, sql.LevelRepeatableRead, opts[0].Isolation)
+		assert.False(t, opts[0].ReadOnly)
+	}
+
+	opts = beginOptions(Config{}, true)
+	if assert.Len(t, opts, 1) {
+		assert.Equal(t, sql.LevelDefault, opts[0].Isolation)
+		assert.True(t, opts[0].ReadOnly)
+	}
+}
+
+func TestWithReadOnlyTransaction_RunsOnReplica(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, primary := newMockDB(t)
+	replicaDB, replica, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { replicaDB.Close() })
+
+	err = db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: []gorm.Dialector{postgres.New(postgres.Config{Conn: replicaDB})},
+	}))
+	assert.NoError(t, err)
+
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	replica.ExpectBegin()
+	replica.ExpectQuery(`SELECT \* FROM "orders"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	replica.ExpectQuery(`SELECT count`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	replica.ExpectCommit()
+
+	err = WithReadOnlyTransaction(context.Background(), func(ctx context.Context) error {
+		var rows []map[string]interface{}
+		if err := GetFromContext(ctx).Table("orders").Find(&rows).Error; err != nil {
+			return err
+		}
+		var count int64
+		return GetFromContext(ctx).Raw("SELECT count(*) FROM orders").Scan(&count).Error
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, primary.ExpectationsWereMet(), "no statement may reach the primary")
+	assert.NoError(t, replica.ExpectationsWereMet())
+}
+
+func TestWithTransaction_DefaultIsolation_Begins(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{DefaultIsolation: sql.LevelSerializable}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	err := WithTransaction(context.Background(), func(ctx context.Context) error {
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransaction_SkipEmptyCommit(t *testing.T) {
+	tests := []struct {
+		name         string
+		installHooks bool
+		write        bool
+		wantCommit   bool
+	}{
+		{"read only rolls back", true, false, false},
+		{"write commits", true, true, true},
+		{"without callbacks plugin always commits", false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saveAndRestoreConn(t)
+
+			db, mock := newMockDB(t)
+			if tt.installHooks {
+				assert.NoError(t, db.Use(callbacksPlugin{}))
+			}
+			connMu.Lock()
+			conn = DBConn{Instance: db}
+			activeConfig = Config{SkipEmptyCommit: true}
+			connMu.Unlock()
+
+			mock.ExpectBegin()
+			if tt.write {
+				mock.ExpectExec("DELETE FROM sessions").WillReturnResult(sqlmock.NewResult(0, 3))
+			} else {
+				mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+			}
+			if tt.wantCommit {
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			err := WithTransaction(context.Background(), func(ctx context.Context) error {
+				db := GetFromContext(ctx)
+				if tt.write {
+					return db.Exec("DELETE FROM sessions WHERE expired").Error
+				}
+				var n int
+				return db.Raw("SELECT 1").Scan(&n).Error
+			})
+
+			assert.NoError(t, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestWithTransaction_SkipEmptyCommitCommitsReturningScan(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	assert.NoError(t, db.Use(callbacksPlugin{}))
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{SkipEmptyCommit: true}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO orders").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectCommit()
+
+	var id int
+	err := WithTransaction(context.Background(), func(ctx context.Context) error {
+		return GetFromContext(ctx).Raw("INSERT INTO orders (total) VALUES (?) RETURNING id", 10).Scan(&id).Error
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 7, id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransactionStatus(t *testing.T) {
+	errFn := errors.New("fn failed")
+	tests := []struct {
+		name          string
+		cfg           Config
+		fnErr         error
+		commitErr     error
+		wantCommitted bool
+	}{
+		{"commit", Config{}, nil, nil, true},
+		{"fn error rolls back", Config{}, errFn, nil, false},
+		{"commit error", Config{}, nil, errors.New("connection reset"), false},
+		{"empty transaction skipped", Config{SkipEmptyCommit: true}, nil, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saveAndRestoreConn(t)
+
+			db, mock := newMockDB(t)
+			assert.NoError(t, db.Use(callbacksPlugin{}))
+			connMu.Lock()
+			conn = DBConn{Instance: db}
+			activeConfig = tt.cfg
+			connMu.Unlock()
+
+			mock.ExpectBegin()
+			switch {
+			case tt.fnErr != nil, tt.cfg.SkipEmptyCommit:
+				mock.ExpectRollback()
+			case tt.commitErr != nil:
+				mock.ExpectCommit().WillReturnError(tt.commitErr)
+			default:
+				mock.ExpectCommit()
+			}
+
+			committed, err := WithTransactionStatus(context.Background(), func(ctx context.Context) error {
+				return tt.fnErr
+			})
+
+			assert.Equal(t, tt.wantCommitted, committed)
+			if tt.fnErr != nil || tt.commitErr != nil {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestWithTransactionStatus_NestedIsNotCommitted(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	var nestedCommitted bool
+	committed, err := WithTransactionStatus(context.Background(), func(ctx context.Context) error {
+		var err error
+		nestedCommitted, err = WithTransactionStatus(ctx, func(ctx context.Context) error { return nil })
+		return err
+	})
+
+	assert.NoError(t, err)
+	assert.True(t, committed)
+	assert.False(t, nestedCommitted, "a nested call is only persisted by the outer commit")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransaction_NilUnitOfWork(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	assert.ErrorIs(t, WithTransaction(context.Background(), nil), ErrNilUnitOfWork)
+	_, err := WithTransactionStatus(context.Background(), nil)
+	assert.ErrorIs(t, err, ErrNilUnitOfWork)
+	assert.ErrorIs(t, WithTransactionDeferred(context.Background(), nil), ErrNilUnitOfWork)
+	assert.ErrorIs(t, WithTransactionLockTimeout(context.Background(), time.Second, nil), ErrNilUnitOfWork)
+
+	// Nested calls are checked too.
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	err = WithTransaction(context.Background(), func(ctx context.Context) error {
+		return WithTransaction(ctx, nil)
+	})
+	assert.ErrorIs(t, err, ErrNilUnitOfWork)
+	assert.NoError(t, mock.ExpectationsWereMet(), "no transaction is begun for a nil fn")
+}
+
+func TestWithTransactionDeferred(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SET CONSTRAINTS ALL DEFERRED`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO edges`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit().WillReturnError(errors.New(`insert or update on table "edges" violates foreign key constraint`))
+
+	err := WithTransactionDeferred(context.Background(), func(ctx context.Context) error {
+		return GetFromContext(ctx).Exec("INSERT INTO edges (from_id, to_id) VALUES (1, 2)").Error
+	})
+
+	assert.ErrorContains(t, err, "violates foreign key constraint", "deferred violations surface at commit")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransaction_TracingEnabled_QuerySpansAreChildren(t *testing.T) {
+	saveAndRestoreConn(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	db, mock := newMockDB(t)
+	cfg := Config{EnableTracing: true, TracingServiceName: "test-svc"}
+	db, err := EnableTracing(db, cfg)
+	assert.NoError(t, err)
+
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = cfg
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err = WithTransaction(context.Background(), func(ctx context.Context) error {
+		return GetFromContext(ctx).Exec("UPDATE accounts SET balance = 0").Error
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var txSpan, querySpan *mocktracer.Span
+	for _, s := range mt.FinishedSpans() {
+		switch s.OperationName() {
+		case SpanNameTransaction:
+			txSpan = s
+		case "gorm.raw_query":
+			querySpan = s
+		}
+	}
+	if assert.NotNil(t, txSpan) && assert.NotNil(t, querySpan) {
+		assert.Equal(t, txSpan.SpanID(), querySpan.ParentID(), "query span must be a child of the transaction span")
+	}
+}
+
+// sqlStateError mimics *pgconn.PgError, which exposes its code through SQLState.
+type sqlStateError struct{ code string }
+
+func (e *sqlStateError) Error() string {
+	return "ERROR: cannot execute UPDATE in a read-only transaction (SQLSTATE " + e.code + ")"
+}
+func (e *sqlStateError) SQLState() string { return e.code }
+
+func TestWithTransaction_ReadOnlyConnection(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	pgErr := &sqlStateError{code: "25006"}
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users`).WillReturnError(pgErr)
+	mock.ExpectRollback()
+
+	err := WithTransaction(context.Background(), func(ctx context.Context) error {
+		return GetFromContext(ctx).Exec("UPDATE users SET active = true").Error
+	})
+
+	assert.ErrorIs(t, err, ErrReadOnlyConnection)
+	assert.ErrorIs(t, err, pgErr, "the driver error stays in the chain")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReadOnlyError(t *testing.T) {
+	assert.NoError(t, readOnlyError(nil))
+
+	other := &sqlStateError{code: "23505"}
+	assert.Same(t, other, readOnlyError(other))
+
+	wrapped := readOnlyError(&sqlStateError{code: "25006"})
+	assert.ErrorIs(t, wrapped, ErrReadOnlyConnection)
+	assert.Same(t, wrapped, readOnlyError(wrapped), "already wrapped errors are returned as-is")
+}
+
+func TestWithTransaction_MaxTransactionDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(ctx context.Context) error
+	}{
+		{"fn returns nil after the deadline", func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		}},
+		{"fn returns the context error", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saveAndRestoreConn(t)
+
+			db, mock := newMockDB(t)
+			connMu.Lock()
+			conn = DBConn{Instance: db}
+			activeConfig = Config{MaxTransactionDuration: 20 * time.Millisecond}
+			connMu.Unlock()
+
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+
+			committed, err := WithTransactionStatus(context.Background(), tt.fn)
+			assert.False(t, committed)
+			assert.ErrorIs(t, err, ErrTransactionTimeout)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestWithTransaction_MaxTransactionDurationNotReached(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{MaxTransactionDuration: time.Minute}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	err := WithTransaction(context.Background(), func(ctx context.Context) error {
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransaction_MaxTransactionNesting(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{MaxTransactionNesting: 2}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	calls := 0
+	var recurse UnitOfWork
+	recurse = func(ctx context.Context) error {
+		calls++
+		return WithTransaction(ctx, recurse)
+	}
+	err := WithTransaction(context.Background(), recurse)
+
+	assert.ErrorIs(t, err, ErrMaxNestingExceeded)
+	assert.Equal(t, 3, calls, "the outer call and two nested ones")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransaction_OnTransactionEnd(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	type outcome struct {
+		committed bool
+		err       error
+	}
+	var outcomes []outcome
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{OnTransactionEnd: func(ctx context.Context, committed bool, d time.Duration, err error) {
+		assert.Positive(t, d)
+		outcomes = append(outcomes, outcome{committed, err})
+	}}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	errBoom := errors.New("boom")
+	assert.NoError(t, WithTransaction(context.Background(), func(ctx context.Context) error {
+		return WithTransaction(ctx, func(context.Context) error { return nil }) // nested: not reported
+	}))
+	assert.ErrorIs(t, WithTransaction(context.Background(), func(context.Context) error { return errBoom }), errBoom)
+	assert.Panics(t, func() {
+		_ = WithTransaction(context.Background(), func(context.Context) error { panic("oops") })
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	if !assert.Len(t, outcomes, 3) {
+		return
+	}
+	assert.Equal(t, outcome{true, nil}, outcomes[0])
+	assert.False(t, outcomes[1].committed)
+	assert.ErrorIs(t, outcomes[1].err, errBoom)
+	assert.False(t, outcomes[2].committed)
+	assert.EqualError(t, outcomes[2].err, "panic in transaction: oops")
+}
+
+func TestWithTransaction_OnBeginTx(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	errDenied := errors.New("permission denied")
+	fail := false
+	calls := 0
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{OnBeginTx: func(ctx context.Context, tx *gorm.DB) error {
+		calls++
+		assert.True(t, isTransaction(tx))
+		assert.Same(t, tx, GetFromContext(ctx), "the hook gets fn's context")
+		if fail {
+			return errDenied
+		}
+		return tx.Exec("SELECT set_config('app.current_user', ?, true)", "42").Error
+	}}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT set_config\('app.current_user', \$1, true\)`).WithArgs("42").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	assert.NoError(t, WithTransaction(context.Background(), func(ctx context.Context) error {
+		return WithTransaction(ctx, func(context.Context) error { return nil }) // nested: not called again
+	}))
+	assert.Equal(t, 1, calls)
+
+	fail = true
+	ran := false
+	err := WithTransaction(context.Background(), func(context.Context) error {
+		ran = true
+		return nil
+	})
+	assert.ErrorIs(t, err, errDenied)
+	assert.False(t, ran, "fn does not run when the hook fails")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransaction_OnBeginTxNonFatalErrorRollsBack(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{
+		NonFatalErrors: []error{gorm.ErrRecordNotFound},
+		OnBeginTx: func(ctx context.Context, tx *gorm.DB) error {
+			return fmt.Errorf("loading tenant: %w", gorm.ErrRecordNotFound)
+		},
+	}
+	connMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	ran := false
+	committed, err := WithTransactionStatus(context.Background(), func(context.Context) error {
+		ran = true
+		return nil
+	})
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	assert.False(t, committed, "a hook error is never committed, even when it matches NonFatalErrors")
+	assert.False(t, ran)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTransaction_NonFatalErrors(t *testing.T) {
+	errFatal := errors.New("boom")
+	tests := []struct {
+		name          string
+		fnErr         error
+		wantCommitted bool
+	}{
+		{"non-fatal error commits", fmt.Errorf("loading account: %w", gorm.ErrRecordNotFound), true},
+		{"other error rolls back", errFatal, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saveAndRestoreConn(t)
+
+			db, mock := newMockDB(t)
+			connMu.Lock()
+			conn = DBConn{Instance: db}
+			activeConfig = Config{NonFatalErrors: []error{gorm.ErrRecordNotFound}}
+			connMu.Unlock()
+
+			mock.ExpectBegin()
+			mock.ExpectExec(`INSERT INTO audit_log`).WillReturnResult(sqlmock.NewResult(0, 1))
+			if tt.wantCommitted {
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			committed, err := WithTransactionStatus(context.Background(), func(ctx context.Context) error {
+				if err := GetFromContext(ctx).Exec("INSERT INTO audit_log (event) VALUES ('lookup')").Error; err != nil {
+					return err
+				}
+				return tt.fnErr
+			})
+			assert.ErrorIs(t, err, tt.fnErr)
+			assert.Equal(t, tt.wantCommitted, committed)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
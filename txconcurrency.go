@@ -0,0 +1,152 @@
+package dbgo
+
+import (
+	"context"
+	"database/sql"
+	"runtime/debug"
+	"sync"
+
+	logger "github.com/adnvilla/logger-go"
+	"gorm.io/gorm"
+)
+
+// concurrentTxPlugin fails the statements that overlap another statement of the same transaction
+// (Config.Debug), the mark of a transaction DB shared with goroutines started in fn. It guards the transactions
+// of WithTransaction and MaybeTransaction; it is installed by getConnection when Debug is set.
+//
+// A statement holds the connection from right before to right after its core callback (gorm:create,
+// gorm:query, ...), so the statements GORM issues around it on the same transaction (associations, preloads,
+// hooks querying through tx) are not mistaken for concurrent ones. Rows holds it until its rows are closed, since
+// the connection is busy until then.
+type concurrentTxPlugin struct{}
+
+// concurrentTxEntered marks, in the statement's instance settings, a statement holding the connection.
+const concurrentTxEntered = "dbgo:concurrent_tx"
+
+func (concurrentTxPlugin) Name() string {
+	return "dbgo:concurrent_tx"
+}
+
+func (concurrentTxPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("dbgo:concurrent_tx_enter", enterTxStatement); err != nil {
+		return err
+	}
+	if err := cb.Create().Before("gorm:save_after_associations").Register("dbgo:concurrent_tx_exit", exitTxStatement); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("dbgo:concurrent_tx_enter", enterTxStatement); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:preload").Register("dbgo:concurrent_tx_exit", exitTxStatement); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("dbgo:concurrent_tx_enter", enterTxStatement); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:save_after_associations").Register("dbgo:concurrent_tx_exit", exitTxStatement); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("dbgo:concurrent_tx_enter", enterTxStatement); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:after_delete").Register("dbgo:concurrent_tx_exit", exitTxStatement); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("dbgo:concurrent_tx_enter", enterTxStatement); err != nil {
+		return err
+	}
+	if err := cb.Row().After("gorm:row").Register("dbgo:concurrent_tx_exit", exitTxStatement); err != nil {
+		return err
+	}
+	if err := cb.Raw().Before("gorm:raw").Register("dbgo:concurrent_tx_enter", enterTxStatement); err != nil {
+		return err
+	}
+	return cb.Raw().After("gorm:raw").Register("dbgo:concurrent_tx_exit", exitTxStatement)
+}
+
+// txUse tracks which statement holds the connection of a transaction (see concurrentTxPlugin).
+type txUse struct {
+	mu      sync.Mutex
+	running bool      // a statement is between its enter and exit callbacks
+	rows    *sql.Rows // rows of the last Rows statement, which keep the connection busy until closed
+}
+
+// enter takes the connection for a statement; it reports false when another statement holds it.
+func (u *txUse) enter() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.rows != nil && rowsClosed(u.rows) {
+		u.rows = nil
+	}
+	if u.running || u.rows != nil {
+		return false
+	}
+	u.running = true
+	return true
+}
+
+// exit releases the connection, unless rows are still to be read from it.
+func (u *txUse) exit(rows *sql.Rows) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.running = false
+	u.rows = rows
+}
+
+// txUseFrom returns the guard of the transaction the statement context belongs to: WithTransaction's, or
+// MaybeTransaction's once begun.
+func txUseFrom(ctx context.Context) *txUse {
+	if state := txStateFrom(ctx); state != nil {
+		return &state.use
+	}
+	if lazy := lazyTxFrom(ctx); lazy != nil {
+		return &lazy.use
+	}
+	return nil
+}
+
+// enterTxStatement takes the transaction's connection for a statement, or fails the statement when another
+// one holds it.
+func enterTxStatement(db *gorm.DB) {
+	if db.Error != nil || db.DryRun || !isTransaction(db) {
+		return
+	}
+	use := txUseFrom(db.Statement.Context)
+	if use == nil {
+		return
+	}
+	if !use.enter() {
+		logger.Error(db.Statement.Context, "dbgo: transaction used concurrently (from another goroutine?); the statement was not sent.",
+			"stack", string(debug.Stack()))
+		_ = db.AddError(ErrConcurrentTransactionUse)
+		return
+	}
+	db.InstanceSet(concurrentTxEntered, true)
+}
+
+// exitTxStatement releases the connection taken by enterTxStatement.
+func exitTxStatement(db *gorm.DB) {
+	if _, entered := db.InstanceGet(concurrentTxEntered); !entered {
+		return
+	}
+	if use := txUseFrom(db.Statement.Context); use != nil {
+		use.exit(openRows(db))
+	}
+}
+
+// openRows returns the rows a Rows statement left open on the connection, if any. A Row statement is not
+// tracked: *sql.Row keeps its rows to itself and closes them in Scan.
+func openRows(db *gorm.DB) *sql.Rows {
+	if db.Error != nil {
+		return nil
+	}
+	rows, _ := db.Statement.Dest.(*sql.Rows)
+	return rows
+}
+
+// rowsClosed reports whether rows have been closed, explicitly or by reading them to the end.
+func rowsClosed(rows *sql.Rows) bool {
+	_, err := rows.ColumnTypes()
+	return err != nil
+}
@@ -0,0 +1,178 @@
+package dbgo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type concTxAuthor struct {
+	ID    uint
+	Name  string
+	Books []concTxBook `gorm:"foreignKey:AuthorID"`
+}
+
+type concTxBook struct {
+	ID       uint
+	AuthorID uint
+	Title    string
+}
+
+type concTxOrder struct {
+	ID    uint
+	Total int
+}
+
+// AfterCreate queries through the statement's transaction, like hooks keeping aggregates up to date do.
+func (o *concTxOrder) AfterCreate(tx *gorm.DB) error {
+	var n int64
+	return tx.Model(&concTxOrder{}).Count(&n).Error
+}
+
+// setupConcurrentTxDB installs a mock DB with dbgo's callbacks and concurrentTxPlugin as the default connection.
+func setupConcurrentTxDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	saveAndRestoreConn(t)
+	db, mock := newMockDB(t)
+	require.NoError(t, db.Use(callbacksPlugin{}))
+	require.NoError(t, db.Use(concurrentTxPlugin{}))
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = Config{}
+	connMu.Unlock()
+	return mock
+}
+
+func (u *txUse) isRunning() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.running
+}
+
+func TestConcurrentTxPlugin_FailsOverlappingStatements(t *testing.T) {
+	mock := setupConcurrentTxDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE stock`).WillDelayFor(100 * time.Millisecond).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO audit`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := WithTransaction(context.Background(), func(ctx context.Context) error {
+		state := txStateFrom(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- GetFromContext(ctx).Exec("UPDATE stock SET n = n - 1").Error
+		}()
+		require.Eventually(t, state.use.isRunning, time.Second, time.Millisecond)
+
+		err := GetFromContext(ctx).Exec("DELETE FROM reservations").Error
+		assert.ErrorIs(t, err, ErrConcurrentTransactionUse)
+		assert.ErrorIs(t, err, ErrTransaction)
+
+		if err := <-done; err != nil {
+			return err
+		}
+		// Sequential statements are fine, whichever goroutine runs them.
+		return GetFromContext(ctx).Exec("INSERT INTO audit VALUES (1)").Error
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConcurrentTxPlugin_AllowsNestedStatements(t *testing.T) {
+	mock := setupConcurrentTxDB(t)
+
+	mock.ExpectBegin()
+	// Association save after the author's INSERT.
+	mock.ExpectQuery(`INSERT INTO "conc_tx_authors"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "conc_tx_books"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	// Preload after the authors' SELECT.
+	mock.ExpectQuery(`SELECT \* FROM "conc_tx_authors"`).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "ann"))
+	mock.ExpectQuery(`SELECT \* FROM "conc_tx_books"`).WillReturnRows(sqlmock.NewRows([]string{"id", "author_id", "title"}).AddRow(1, 1, "b"))
+	// AfterCreate hook querying through tx.
+	mock.ExpectQuery(`INSERT INTO "conc_tx_orders"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectCommit()
+
+	err := WithTransaction(context.Background(), func(ctx context.Context) error {
+		db := GetFromContext(ctx)
+		if err := db.Create(&concTxAuthor{Name: "ann", Books: []concTxBook{{Title: "b"}}}).Error; err != nil {
+			return err
+		}
+		var authors []concTxAuthor
+		if err := db.Preload("Books").Find(&authors).Error; err != nil {
+			return err
+		}
+		return db.Create(&concTxOrder{Total: 10}).Error
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConcurrentTxPlugin_RowsHoldTheConnection(t *testing.T) {
+	mock := setupConcurrentTxDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM orders`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`SELECT total FROM orders`).WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(10))
+	mock.ExpectExec(`UPDATE orders`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := WithTransaction(context.Background(), func(ctx context.Context) error {
+		db := GetFromContext(ctx)
+		rows, err := db.Raw("SELECT id FROM orders").Rows()
+		if err != nil {
+			return err
+		}
+		assert.ErrorIs(t, db.Exec("UPDATE orders SET total = 0").Error, ErrConcurrentTransactionUse, "rows are still open")
+		require.NoError(t, rows.Close())
+
+		var total int
+		require.NoError(t, db.Raw("SELECT total FROM orders").Row().Scan(&total))
+		return db.Exec("UPDATE orders SET total = 0").Error
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConcurrentTxPlugin_GuardsMaybeTransaction(t *testing.T) {
+	mock := setupConcurrentTxDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE orders`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT id FROM orders`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	err := MaybeTransaction(context.Background(), func(ctx context.Context) error {
+		db := GetFromContext(ctx)
+		if err := db.Exec("UPDATE orders SET total = 0").Error; err != nil {
+			return err
+		}
+		rows, err := db.Raw("SELECT id FROM orders").Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		assert.ErrorIs(t, db.Exec("UPDATE orders SET total = 1").Error, ErrConcurrentTransactionUse)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetConnection_DebugInstallsConcurrentTxPlugin(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	db, _ := newMockDB(t)
+	noPrepare := false
+	result := GetConnection(Config{Dialector: db.Dialector, PrepareStmt: &noPrepare, Debug: true})
+	require.NoError(t, result.Error)
+	_, ok := result.Instance.Config.Plugins["dbgo:concurrent_tx"]
+	assert.True(t, ok)
+}
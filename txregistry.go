@@ -0,0 +1,147 @@
+package dbgo
+
+import (
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TxInfo describes a transaction opened by WithTransaction that has not ended yet (see ActiveTransactions).
+type TxInfo struct {
+	ID    uint64    // unique for the life of the process
+	Start time.Time // when the transaction began
+	Stack string    // stack trace of the goroutine that began it
+}
+
+// openTx is a registry entry; the stack is only formatted when ActiveTransactions is called.
+type openTx struct {
+	start time.Time
+	pcs   []uintptr
+}
+
+var openTxs struct {
+	sync.Mutex
+	lastID atomic.Uint64
+	byID   map[uint64]openTx
+}
+
+// ActiveTransactions returns the transactions opened by WithTransaction (and its variants) that are still
+// running, oldest first, with the stack trace captured when each began — for a debug endpoint that shows which
+// code path is holding a transaction, and its locks, open. Nested calls share their outer transaction's entry.
+// Example:
+//
+//	http.HandleFunc("/debug/transactions", func(w http.ResponseWriter, r *http.Request) {
+//	    for _, tx := range dbgo.ActiveTransactions() {
+//	        fmt.Fprintf(w, "tx %d open for %s\n%s\n", tx.ID, time.Since(tx.Start), tx.Stack)
+//	    }
+//	})
+func ActiveTransactions() []TxInfo {
+	openTxs.Lock()
+	infos := make([]TxInfo, 0, len(openTxs.byID))
+	entries := make([]openTx, 0, len(openTxs.byID))
+	for id, tx := range openTxs.byID {
+		infos = append(infos, TxInfo{ID: id, Start: tx.start})
+		entries = append(entries, tx)
+	}
+	openTxs.Unlock()
+
+	for i := range infos {
+		infos[i].Stack = formatStack(entries[i].pcs)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// trackTransaction registers a transaction that began now, with the caller's stack, and returns the function
+// removing it once the transaction has ended.
+func trackTransaction() (untrack func()) {
+	pcs := make([]uintptr, 32)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+	id := openTxs.lastID.Add(1)
+
+	openTxs.Lock()
+	if openTxs.byID == nil {
+		openTxs.byID = make(map[uint64]openTx)
+	}
+	openTxs.byID[id] = openTx{start: time.Now(), pcs: pcs}
+	openTxs.Unlock()
+
+	return func() {
+		openTxs.Lock()
+		delete(openTxs.byID, id)
+		openTxs.Unlock()
+	}
+}
+
+func formatStack(pcs []uintptr) string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		b.WriteString(frame.Function)
+		b.WriteString("\n\t")
+		b.WriteString(frame.File)
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(frame.Line))
+		b.WriteByte('\n')
+		if !more {
+			return b.String()
+		}
+	}
+}
+
+// txGoroutines counts, by goroutine id, the transactions whose fn is running on that goroutine under
+// Config.StrictTransactionContext, so GetFromContext can tell that a context without a DB lost the transaction.
+var txGoroutines struct {
+	sync.Mutex
+	byID map[uint64]int
+}
+
+// enterTxGoroutine records that the calling goroutine runs a transaction's fn and returns the function undoing it.
+func enterTxGoroutine() (exit func()) {
+	id := goroutineID()
+	txGoroutines.Lock()
+	if txGoroutines.byID == nil {
+		txGoroutines.byID = make(map[uint64]int)
+	}
+	txGoroutines.byID[id]++
+	txGoroutines.Unlock()
+
+	return func() {
+		txGoroutines.Lock()
+		if txGoroutines.byID[id]--; txGoroutines.byID[id] == 0 {
+			delete(txGoroutines.byID, id)
+		}
+		txGoroutines.Unlock()
+	}
+}
+
+// inTxGoroutine reports whether the calling goroutine runs a transaction's fn (see enterTxGoroutine).
+func inTxGoroutine() bool {
+	txGoroutines.Lock()
+	n := len(txGoroutines.byID)
+	txGoroutines.Unlock()
+	if n == 0 {
+		return false
+	}
+	id := goroutineID()
+	txGoroutines.Lock()
+	defer txGoroutines.Unlock()
+	return txGoroutines.byID[id] > 0
+}
+
+// goroutineID returns the id of the calling goroutine, parsed from the "goroutine N [...]" header of its stack.
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = buf[len("goroutine "):]
+	if i := strings.IndexByte(string(buf), ' '); i > 0 {
+		buf = buf[:i]
+	}
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return id
+}
@@ -0,0 +1,39 @@
+package dbgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActiveTransactions(t *testing.T) {
+	saveAndRestoreConn(t)
+
+	db, mock := newMockDB(t)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	connMu.Unlock()
+
+	before := len(ActiveTransactions())
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	errDone := errors.New("done")
+	err := WithTransaction(context.Background(), func(ctx context.Context) error {
+		return WithTransaction(ctx, func(ctx context.Context) error {
+			active := ActiveTransactions()
+			if assert.Len(t, active, before+1, "a nested call shares the outer transaction's entry") {
+				tx := active[len(active)-1]
+				assert.NotZero(t, tx.ID)
+				assert.False(t, tx.Start.IsZero())
+				assert.Contains(t, tx.Stack, "TestActiveTransactions")
+			}
+			return errDone
+		})
+	})
+	assert.ErrorIs(t, err, errDone)
+	assert.Len(t, ActiveTransactions(), before)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
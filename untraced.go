@@ -0,0 +1,75 @@
+package dbgo
+
+import (
+	"context"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type untracedKey struct{}
+
+// WithoutTracing returns a copy of ctx under which statements produce no spans, e.g. for a liveness
+// "SELECT 1" that would otherwise flood APM with uninteresting spans. WithTransaction does not start a
+// "db.transaction" span under it either. Spans already in ctx (such as the request span) are unaffected.
+// It applies to connections whose tracing was enabled by EnableTracing.
+// Example:
+//
+//	var one int
+//	err := dbgo.Raw(dbgo.WithoutTracing(ctx), &one, "SELECT 1")
+func WithoutTracing(ctx context.Context) context.Context {
+	return context.WithValue(ctx, untracedKey{}, true)
+}
+
+// tracingDisabled reports whether ctx was returned by WithoutTracing.
+func tracingDisabled(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	disabled, _ := ctx.Value(untracedKey{}).(bool)
+	return disabled
+}
+
+// skipUntracedStatements replaces the tracing plugin's callbacks on db with versions that do nothing for
+// statements under WithoutTracing, or for every statement while tracing is disabled. Both the before and after callbacks are skipped: the after callback
+// finishes the span it finds in the statement context, which would otherwise be the caller's span. The decision is
+// taken once by the before callback and honoured by the after callback, even if UpdateTracing runs in between.
+func skipUntracedStatements(db *gorm.DB, tracing *liveTracing) error {
+	cb := db.Callback()
+	for _, p := range []struct {
+		get     func(string) func(*gorm.DB)
+		replace func(string, func(*gorm.DB)) error
+		op      string
+	}{
+		{cb.Create().Get, cb.Create().Replace, "create"},
+		{cb.Query().Get, cb.Query().Replace, "query"},
+		{cb.Update().Get, cb.Update().Replace, "update"},
+		{cb.Delete().Get, cb.Delete().Replace, "delete"},
+		{cb.Row().Get, cb.Row().Replace, "row_query"},
+		{cb.Raw().Get, cb.Raw().Replace, "raw_query"},
+	} {
+		for _, name := range []string{"dd-trace-go:before_" + p.op, "dd-trace-go:after_" + p.op} {
+			fn := p.get(name)
+			if fn == nil {
+				continue
+			}
+			traced := tracing.consume
+			if strings.HasPrefix(name, "dd-trace-go:before_") {
+				traced = tracing.decide
+			}
+			if err := p.replace(name, unlessUntraced(fn, traced)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func unlessUntraced(fn func(*gorm.DB), traced func(*gorm.DB) bool) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if !traced(db) {
+			return
+		}
+		fn(db)
+	}
+}
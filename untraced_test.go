@@ -0,0 +1,57 @@
+package dbgo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/ext"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/mocktracer"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/tracer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithoutTracing_SuppressesStatementSpans(t *testing.T) {
+	saveAndRestoreConn(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	db, mock := newMockDB(t)
+	rate := 1.0
+	cfg := Config{EnableTracing: true, TracingReadAnalyticsRate: &rate}
+	db, err := EnableTracing(db, cfg)
+	assert.NoError(t, err)
+	connMu.Lock()
+	conn = DBConn{Instance: db}
+	activeConfig = cfg
+	connMu.Unlock()
+
+	parent, ctx := tracer.StartSpanFromContext(context.Background(), "http.request")
+	untraced := WithoutTracing(ctx)
+	assert.True(t, tracingDisabled(untraced))
+	assert.False(t, tracingDisabled(ctx))
+
+	mock.ExpectQuery(`SELECT 1`).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT 2`).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(2))
+
+	var n int
+	assert.NoError(t, Raw(untraced, &n, "SELECT 1"))
+	assert.NoError(t, WithTransaction(untraced, func(ctx context.Context) error {
+		_, err := Exec(ctx, "UPDATE users SET active = true")
+		return err
+	}))
+	assert.Empty(t, mt.FinishedSpans(), "no spans, and the caller's span is left open")
+
+	assert.NoError(t, Raw(ctx, &n, "SELECT 2"))
+	parent.Finish()
+	spans := mt.FinishedSpans()
+	if assert.Len(t, spans, 2, "statements outside WithoutTracing are traced") {
+		assert.Equal(t, "gorm.row_query", spans[0].OperationName())
+		assert.Equal(t, "http.request", spans[1].OperationName())
+		assert.Nil(t, spans[1].Tag(ext.EventSampleRate), "the caller's span is not tagged")
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
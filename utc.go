@@ -0,0 +1,59 @@
+package dbgo
+
+import (
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// utcPlugin converts the time.Time and *time.Time fields of loaded models to UTC (Config.ForceUTC). The
+// driver returns timestamps in the process's local time zone, so without it the same row reads differently
+// on services running in different zones. It is installed by getConnection when ForceUTC is set.
+type utcPlugin struct{}
+
+func (utcPlugin) Name() string {
+	return "dbgo:utc"
+}
+
+func (utcPlugin) Initialize(db *gorm.DB) error {
+	return db.Callback().Query().After("gorm:query").Register("dbgo:utc", loadedTimesToUTC)
+}
+
+// loadedTimesToUTC converts the timestamps of the models a query loaded into its destination. Destinations
+// other than the statement's model (e.g. Scan into a different struct) are left untouched.
+func loadedTimesToUTC(db *gorm.DB) {
+	s := db.Statement.Schema
+	if db.Error != nil || s == nil || !db.Statement.ReflectValue.IsValid() {
+		return
+	}
+	rv := reflect.Indirect(db.Statement.ReflectValue)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			timesToUTC(db, s, reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		timesToUTC(db, s, rv)
+	}
+}
+
+func timesToUTC(db *gorm.DB, s *schema.Schema, rv reflect.Value) {
+	if rv.Type() != s.ModelType || !rv.CanAddr() {
+		return
+	}
+	ctx := db.Statement.Context
+	for _, f := range s.Fields {
+		v, zero := f.ValueOf(ctx, rv)
+		if zero {
+			continue
+		}
+		switch t := v.(type) {
+		case time.Time:
+			_ = f.Set(ctx, rv, t.UTC())
+		case *time.Time:
+			_ = f.Set(ctx, rv, t.UTC())
+		}
+	}
+}
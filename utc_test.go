@@ -0,0 +1,52 @@
+package dbgo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type utcEvent struct {
+	ID        uint
+	CreatedAt time.Time
+	SeenAt    *time.Time
+}
+
+func TestUTCPlugin_ConvertsLoadedTimes(t *testing.T) {
+	db, mock := newMockDB(t)
+	require.NoError(t, db.Use(utcPlugin{}))
+
+	zone := time.FixedZone("UTC-5", -5*3600)
+	local := time.Date(2024, 3, 1, 7, 0, 0, 0, zone)
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "created_at", "seen_at"}).AddRow(1, local, local).AddRow(2, local, nil)
+	}
+	mock.ExpectQuery(`SELECT \* FROM "utc_events"`).WillReturnRows(rows())
+	mock.ExpectQuery(`SELECT \* FROM "utc_events"`).WillReturnRows(rows())
+
+	var events []utcEvent
+	require.NoError(t, db.Find(&events).Error)
+	require.Len(t, events, 2)
+	assert.Equal(t, time.UTC, events[0].CreatedAt.Location())
+	assert.True(t, events[0].CreatedAt.Equal(local))
+	assert.Equal(t, time.UTC, events[0].SeenAt.Location())
+	assert.Nil(t, events[1].SeenAt)
+
+	var event utcEvent
+	require.NoError(t, db.First(&event).Error)
+	assert.Equal(t, time.UTC, event.CreatedAt.Location())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConfig_NowFunc_ForceUTC(t *testing.T) {
+	zone := time.FixedZone("UTC+2", 2*3600)
+	frozen := time.Date(2024, 1, 2, 5, 4, 5, 0, zone)
+
+	assert.Nil(t, Config{}.nowFunc())
+	now := Config{NowFunc: func() time.Time { return frozen }, ForceUTC: true}.nowFunc()
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), now())
+	assert.Equal(t, time.UTC, Config{ForceUTC: true}.nowFunc()().Location())
+}
@@ -0,0 +1,98 @@
+package dbgo
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+type writtenTablesKey struct{}
+
+// MarkWritten returns a copy of ctx under which queries of table (read through the context DB, see
+// GetFromContext) run on the primary, while queries of other tables keep using the replicas. Mark the tables a
+// request wrote outside a transaction so its later reads of them see its writes, which a lagging replica may
+// not have yet, without sending every read to the primary like RequirePrimary. Tables accumulate across calls.
+// Only statements built from a model or Table(...) are matched; raw SQL keeps the replica routing.
+// Example:
+//
+//	if err := dbgo.GetFromContext(ctx).Create(&order).Error; err != nil {
+//	    return err
+//	}
+//	ctx = dbgo.MarkWritten(ctx, "orders")
+//	err := dbgo.GetFromContext(ctx).First(&order, order.ID).Error // primary
+//	err = dbgo.GetFromContext(ctx).Find(&products).Error          // replica
+func MarkWritten(ctx context.Context, table string) context.Context {
+	prev := writtenTables(ctx)
+	if _, ok := prev[table]; ok {
+		return ctx
+	}
+	tables := make(map[string]struct{}, len(prev)+1)
+	for t := range prev {
+		tables[t] = struct{}{}
+	}
+	tables[table] = struct{}{}
+	ctx = context.WithValue(ctx, writtenTablesKey{}, tables)
+
+	// The context DB is bound to the context it was stored with; rebind it so its statements see the mark.
+	if db, ok := contextDB(ctx); ok && hasConnection(db) && !isTransaction(db) {
+		ctx = SetFromContext(ctx, db.WithContext(ctx))
+	}
+	return ctx
+}
+
+func writtenTables(ctx context.Context) map[string]struct{} {
+	if ctx == nil {
+		return nil
+	}
+	tables, _ := ctx.Value(writtenTablesKey{}).(map[string]struct{})
+	return tables
+}
+
+// writtenTablesRouting marks a statement while registerWrittenTables' wrapper routes it to the primary.
+const writtenTablesRouting = "dbgo:written_tables"
+
+// registerWrittenTables wraps dbresolver's callbacks choosing the source of queries (installed with replicas,
+// before callbacksPlugin), so queries of a table marked with MarkWritten are resolved to the primary.
+func registerWrittenTables(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, p := range []struct {
+		get     func(string) func(*gorm.DB)
+		replace func(string, func(*gorm.DB)) error
+	}{
+		// The replacement keeps the position dbresolver registers its callbacks at (before all others).
+		{cb.Query().Get, cb.Query().Before("*").Replace},
+		{cb.Row().Get, cb.Row().Before("*").Replace},
+	} {
+		next := p.get("gorm:db_resolver")
+		if next == nil {
+			continue
+		}
+		if err := p.replace("gorm:db_resolver", routeWrittenTables(next)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func routeWrittenTables(next func(*gorm.DB)) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if isWrittenTable(db) {
+			if _, routing := db.Statement.Settings.LoadOrStore(writtenTablesRouting, struct{}{}); !routing {
+				// ModifyStatement runs this callback again, which then resolves the statement to the primary.
+				dbresolver.Write.ModifyStatement(db.Statement)
+				db.Statement.Settings.Delete(writtenTablesRouting)
+				return
+			}
+		}
+		next(db)
+	}
+}
+
+func isWrittenTable(db *gorm.DB) bool {
+	if db.Statement.Table == "" {
+		return false
+	}
+	_, ok := writtenTables(db.Statement.Context)[db.Statement.Table]
+	return ok
+}
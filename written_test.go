@@ -0,0 +1,75 @@
+package dbgo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type writtenOrder struct {
+	ID uint
+}
+
+type writtenProduct struct {
+	ID uint
+}
+
+func TestMarkWritten_RoutesMarkedTablesToPrimary(t *testing.T) {
+	saveAndRestoreConn(t)
+	ResetConnection()
+
+	primaryDB, primary, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { primaryDB.Close() })
+	replicaDB, replica, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { replicaDB.Close() })
+
+	noPrepare := false
+	result := GetConnection(Config{
+		Dialector:         postgres.New(postgres.Config{Conn: primaryDB}),
+		ReplicaDialectors: []gorm.Dialector{postgres.New(postgres.Config{Conn: replicaDB})},
+		PrepareStmt:       &noPrepare,
+	})
+	require.NoError(t, result.Error)
+
+	replica.ExpectQuery(`SELECT \* FROM "written_orders"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	primary.ExpectQuery(`SELECT \* FROM "written_orders"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	replica.ExpectQuery(`SELECT \* FROM "written_products"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	primary.ExpectQuery(`SELECT count\(\*\) FROM "written_orders"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	primary.ExpectQuery(`SELECT \* FROM "written_orders"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	replica.ExpectQuery(`SELECT \* FROM "written_products"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	ctx := SetFromContext(context.Background(), result.Instance)
+	var orders []writtenOrder
+	require.NoError(t, GetFromContext(ctx).Find(&orders).Error)
+
+	ctx = MarkWritten(ctx, "written_orders")
+	require.NoError(t, GetFromContext(ctx).Find(&orders).Error)
+	var products []writtenProduct
+	require.NoError(t, GetFromContext(ctx).Find(&products).Error)
+	var count int64
+	require.NoError(t, GetFromContext(ctx).Model(&writtenOrder{}).Count(&count).Error)
+
+	// Marks also override a context pinned to the replicas.
+	get := MarkWritten(RouteByMethod(SetFromContext(context.Background(), result.Instance), http.MethodGet), "written_orders")
+	require.NoError(t, GetFromContext(get).Find(&orders).Error)
+	require.NoError(t, GetFromContext(get).Find(&products).Error)
+
+	assert.NoError(t, primary.ExpectationsWereMet())
+	assert.NoError(t, replica.ExpectationsWereMet())
+}
+
+func TestMarkWritten_Accumulates(t *testing.T) {
+	ctx := MarkWritten(context.Background(), "orders")
+	ctx = MarkWritten(ctx, "order_items")
+	assert.Equal(t, map[string]struct{}{"orders": {}, "order_items": {}}, writtenTables(ctx))
+	assert.Equal(t, ctx, MarkWritten(ctx, "orders"))
+	assert.Nil(t, writtenTables(context.Background()))
+}